@@ -3,10 +3,14 @@ module github.com/adamdecaf/cert-manage
 go 1.20
 
 require (
+	filippo.io/age v1.0.0
 	github.com/adamdecaf/extract-nss-root-certs v0.0.0-20180504185435-b4eb4db979cb
 	github.com/go-sqlite/sqlite3 v0.0.0-20180313105335-53dd8e640ee7
 	golang.org/x/crypto v0.16.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
-require github.com/gonuts/binary v0.2.0 // indirect
+require (
+	github.com/gonuts/binary v0.2.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+)
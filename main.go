@@ -26,6 +26,7 @@ import (
 	"github.com/adamdecaf/cert-manage/pkg/cmd"
 	"github.com/adamdecaf/cert-manage/pkg/store"
 	"github.com/adamdecaf/cert-manage/pkg/ui"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
 )
 
 const Version = "0.1.1-dev"
@@ -52,9 +53,55 @@ var (
 	// -from is used by 'gen-whitelist' to specify url sources
 	flagFrom = fs.String("from", "", "")
 
-	// -out is used by 'gen-whitelist' to specify output file location
+	// -out is used by 'gen-whitelist' to specify output file location and by 'backup' to write an archive
 	flagOutFile = fs.String("out", "", "")
 
+	// -refresh-cmd is used to run the platform's refresh command (e.g. update-ca-certificates)
+	flagRefreshCmd = fs.Bool("refresh-cmd", false, "")
+
+	// -root is used to operate on certificate stores under an alternate filesystem root
+	flagRoot = fs.String("root", "", "")
+
+	// -usage is used by 'list' to show certificates trusted for a given usage (tls, email, code-signing)
+	flagUsage = fs.String("usage", "", "")
+
+	// -untrusted is used by 'list' to show distrusted certificates instead of trusted ones
+	flagUntrusted = fs.Bool("untrusted", false, "")
+
+	// -expired, -not-yet-valid and -revoked are used by 'list' to only show certificates
+	// which are expired, not valid yet or revoked. -crl adds CRL files to check.
+	flagExpired     = fs.Bool("expired", false, "")
+	flagNotYetValid = fs.Bool("not-yet-valid", false, "")
+	flagRevoked     = fs.Bool("revoked", false, "")
+	flagCRL         = fs.String("crl", "", "")
+
+	// -java-keystore, -java-storepass and -java-storepass-file pick the Java keystore and its password
+	flagJavaKeystore      = fs.String("java-keystore", "", "")
+	flagJavaStorePass     = fs.String("java-storepass", "", "")
+	flagJavaStorePassFile = fs.String("java-storepass-file", "", "")
+
+	// -dry-run is used by 'add', 'restore' and 'whitelist' to show what would change instead
+	flagDryRun = fs.Bool("dry-run", false, "")
+
+	// -explain is used by 'whitelist' to show why each certificate is kept or removed
+	flagExplain = fs.Bool("explain", false, "")
+
+	// -keep and -older-than are used by 'backups prune' to pick which backups are deleted
+	flagKeep      = fs.Int("keep", 0, "")
+	flagOlderThan = fs.String("older-than", "", "")
+
+	// -venvs is a comma separated list of directories searched for Python virtualenvs
+	flagVenvs = fs.String("venvs", "", "")
+
+	// -encrypt-to, -passphrase-file and -sign-key are used by 'backup -out' to encrypt and sign
+	// archives. -identity, -passphrase-file and -trusted-key are used by 'restore' to decrypt and
+	// verify them.
+	flagEncryptTo      = fs.String("encrypt-to", "", "")
+	flagPassphraseFile = fs.String("passphrase-file", "", "")
+	flagIdentity       = fs.String("identity", "", "")
+	flagSignKey        = fs.String("sign-key", "", "")
+	flagTrustedKey     = fs.String("trusted-key", "", "")
+
 	// Output
 	flagCount  = fs.Bool("count", false, "")
 	flagFormat = fs.String("format", ui.DefaultFormat(), "")
@@ -69,7 +116,9 @@ func init() {
 SUB-COMMANDS
   add           Add certificate(s) to a store
 
-  backup        Take a backup of the specified certificate store
+  backup        Take a backup of the specified certificate store, optionally into an archive with -out <path>
+
+  backups       List, show, verify and prune the backups which have been taken
 
   connect       Attempt to load a remote URL with the platform (or app) store
 
@@ -77,7 +126,7 @@ SUB-COMMANDS
 
   list          List the currently installed and trusted certificates
 
-  restore       Revert the certificate trust back to, optionally takes -file <path>
+  restore       Revert the certificate trust back to, optionally takes -file <path|id|archive>
 
   version       Show the version of cert-manage
 
@@ -88,11 +137,41 @@ APPS
 
 FLAGS
   -app <name>      The name of an application which to perform the given command on.
+                   Java installs, Firefox/Thunderbird profiles and shared NSS databases can be picked individually with
+                   java:<path> (e.g. java:/usr/lib/jvm/java-17), firefox:<profile> (e.g. firefox:default-release),
+                   thunderbird:<profile> and nssdb:<system|user>. CA bundles can be picked with python:<path>, node:<path>
+                   and curl:<path>, -app bundles operates on every bundle found for python, node and curl.
+                   Docker and containerd registries are picked with docker:<host> (e.g. docker:registry.example.com:5000)
+  -dry-run         Show the certificates add, restore or whitelist would change in each store, without changing them.
+                   Use -format json for a machine-readable plan, which is written to -out <path> if given
+  -explain         Show why each certificate would be kept or removed by a whitelist, without changing anything
   -file <path>     Local file path
   -from <type(s)>  Which sources to capture urls from. Comma separated list. (Options: browser, chrome, firefox, file)
   -help            Show this help dialog
+  -java-keystore <path>        Java keystore to use instead of each JDK's cacerts (default: javax.net.ssl.trustStore from JAVA_TOOL_OPTIONS)
+  -java-storepass <password>   Java keystore password (default: changeit)
+  -java-storepass-file <path>  File holding the Java keystore password
+  -refresh-cmd     Run the platform's refresh command (e.g. update-ca-certificates) rather than rebuilding bundles natively
+  -root <path>     Operate on certificate stores under an alternate filesystem root (e.g. a container image or chroot). Linux only
   -ui <type>       Method of adjusting certificates to be removed/untrusted. (default: %s, options: %s)
+  -usage <usage>   List certificates trusted for a usage, only NSS stores (e.g. firefox) track these. (default: tls, options: tls, email, code-signing)
+  -untrusted       List certificates which are explicitly distrusted, rather than trusted ones (e.g. -app p11kit)
   -url <where>     Remote URL to download and use in a command
+  -venvs <dir(s)>  Directories to search for Python virtualenvs, whose certifi bundles -app python includes. Comma separated list.
+
+FILTERS
+  -expired         Only list certificates which have expired
+  -not-yet-valid   Only list certificates which aren't valid yet
+  -revoked         Only list certificates which are revoked, by Chromium's blacklist or -crl
+  -crl <path(s)>   CRL files (PEM or DER) to check certificates against, implies -revoked. Comma separated list.
+
+ARCHIVES
+  -encrypt-to <recipient(s)>  age recipients (age1...) which backup -out encrypts the archive to. Comma separated list.
+  -passphrase-file <path>     File holding a passphrase the archive is encrypted (backup -out) or decrypted (restore) with
+  -sign-key <path>            Ed25519 private key (PEM) which backup -out signs the archive with, into <archive>.sig
+  -identity <path(s)>         age identity files which restore decrypts archives with. Comma separated list.
+  -trusted-key <path(s)>      Ed25519 public keys (PEM), restore refuses archives which aren't signed by one of them.
+                              Comma separated list. (default: $CERT_MANAGE_TRUSTED_KEYS)
 
 OUTPUT
   -count  Output the count of certificates instead of each certificate
@@ -102,6 +181,9 @@ DEBUGGING
   Alongside command line flags are two environmental varialbes read by cert-manage:
   - DEBUG=1        Enabled debug logging, GODEBUG=x509roots=1 also works and enabled Go's debugging
   - TRACE=<where>  Saves a binary trace file at <where> of the execution
+
+  The Java keystore flags can also be set with CERT_MANAGE_JAVA_KEYSTORE, CERT_MANAGE_JAVA_STOREPASS
+  and CERT_MANAGE_JAVA_STOREPASS_FILE.
 `,
 			getVersion(),
 			strings.Join(store.GetApps(), ", "),
@@ -160,6 +242,54 @@ func main() {
 	}
 	fs.Parse(os.Args[2:]) // reparse
 
+	if *flagRoot != "" && runtime.GOOS != "linux" {
+		fmt.Printf("ERROR: -root is only supported on linux, not %s\n", runtime.GOOS)
+		os.Exit(1)
+	}
+	usage, err := whitelist.ParseUsage(*flagUsage)
+	if err != nil {
+		fmt.Printf("ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	lopts := &store.ListOptions{
+		Trusted:     !*flagUntrusted,
+		Untrusted:   *flagUntrusted,
+		Usage:       usage,
+		Expired:     *flagExpired,
+		NotYetValid: *flagNotYetValid,
+		Revoked:     *flagRevoked || *flagCRL != "",
+	}
+	if *flagCRL != "" {
+		crls, err := store.CRLRevocation(strings.Split(*flagCRL, ",")...)
+		if err != nil {
+			fmt.Printf("ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		lopts.Revocation = store.RevocationSources{store.BlacklistRevocation(), crls}
+	}
+
+	opts := &store.Options{
+		Root:              *flagRoot,
+		ExecRefresh:       *flagRefreshCmd,
+		JavaKeystore:      *flagJavaKeystore,
+		JavaStorePass:     *flagJavaStorePass,
+		JavaStorePassFile: *flagJavaStorePassFile,
+	}
+	if *flagVenvs != "" {
+		opts.VenvDirs = strings.Split(*flagVenvs, ",")
+	}
+	opts.ArchivePassphraseFile = *flagPassphraseFile
+	opts.ArchiveSigningKeyFile = *flagSignKey
+	if *flagEncryptTo != "" {
+		opts.ArchiveRecipients = strings.Split(*flagEncryptTo, ",")
+	}
+	if *flagIdentity != "" {
+		opts.ArchiveIdentityFiles = strings.Split(*flagIdentity, ",")
+	}
+	if *flagTrustedKey != "" {
+		opts.ArchiveTrustedKeyFiles = strings.Split(*flagTrustedKey, ",")
+	}
+
 	// Lift config options into a higher-level
 	cfg := &ui.Config{
 		Count:   *flagCount,
@@ -176,16 +306,22 @@ func main() {
 				callForHelp = true
 				return nil
 			}
-			return cmd.AddCertsFromFile(*flagFile)
+			if *flagDryRun {
+				return cmd.PlanAddCertsFromFile(*flagFile, opts, cfg)
+			}
+			return cmd.AddCertsFromFile(*flagFile, opts)
 		},
 		appfn: func(a string) error {
 			if *flagFile == "" {
 				callForHelp = true
 				return nil
 			}
-			return cmd.AddCertsToAppFromFile(a, *flagFile)
+			if *flagDryRun {
+				return cmd.PlanAddCertsToAppFromFile(a, *flagFile, opts, cfg)
+			}
+			return cmd.AddCertsToAppFromFile(a, *flagFile, opts)
 		},
-		help: fmt.Sprintf(`Usage: cert-manage add -file <path> [-app <name>]
+		help: fmt.Sprintf(`Usage: cert-manage add -file <path> [-app <name>] [-dry-run]
 
   Add a certificate to the platform store
     cert-manage add -file <path>
@@ -193,22 +329,60 @@ func main() {
   Add a certificate to an application's store
     cert-manage add -file <path> -app <name>
 
+  Show which certificates would be added, without adding them
+    cert-manage add -file <path> -app <name> -dry-run
+
 APPS
   Supported apps: %s`, strings.Join(store.GetApps(), ", ")),
 	}
 	commands["backup"] = &command{
 		fn: func() error {
-			return cmd.BackupForPlatform()
+			return cmd.BackupForPlatform(*flagOutFile, opts)
 		},
 		appfn: func(a string) error {
-			return cmd.BackupForApp(a)
+			return cmd.BackupForApp(a, *flagOutFile, opts)
 		},
-		help: fmt.Sprintf(`Usage: cert-manage backup [-app <name>]
+		help: fmt.Sprintf(`Usage: cert-manage backup [-app <name>] [-out <archive>]
 
   Backup a certificate store. This can be done for the platform or a given app.
+    cert-manage backup -app java
+
+  Also save the backup in an archive, which can be restored on another host
+    cert-manage backup -app java -out state.tar.gz
+
+  Encrypt the archive to an age recipient (or with -passphrase-file <path>) and sign it
+    cert-manage backup -app java -out state.tar.gz.age -encrypt-to age1... -sign-key ed25519.pem
 
 APPS
   Supported apps: %s`, strings.Join(store.GetApps(), ", ")),
+	}
+	commands["backups"] = &command{
+		fn: func() error {
+			return backupsCommand(opts)
+		},
+		appfn: func(_ string) error {
+			return backupsCommand(opts)
+		},
+		help: `Usage: cert-manage backups <list|show|verify|prune> [options]
+
+  Backups are recorded in an index (~/.cert-manage/backups.json) with the store, host,
+  time, certificate count and SHA256 checksum of each file.
+
+  List every backup, or those of an app
+    cert-manage backups list
+    cert-manage backups list -app java
+
+  Show the details and files of a backup
+    cert-manage backups show <id>
+
+  Check the files of a backup (or every backup) still match their checksums
+    cert-manage backups verify <id>
+    cert-manage backups verify
+
+  Delete old backups, keeping the newest N of each store and/or those newer than an age
+    cert-manage backups prune -keep 3
+    cert-manage backups prune -keep 1 -older-than 30d
+    cert-manage backups prune -older-than 90d -dry-run`,
 	}
 	commands["connect"] = &command{
 		fn: func() error {
@@ -216,14 +390,14 @@ APPS
 			if err != nil {
 				return err
 			}
-			return cmd.ConnectWithPlatformStore(u)
+			return cmd.ConnectWithPlatformStore(u, opts)
 		},
 		appfn: func(a string) error {
 			u, err := parseConnectUrl(fs)
 			if err != nil {
 				return err
 			}
-			return cmd.ConnectWithAppStore(u, *flagApp)
+			return cmd.ConnectWithAppStore(u, *flagApp, opts)
 		},
 		help: fmt.Sprintf(`Usage: cert-manage connect [-app <name>] <url>
 
@@ -265,10 +439,10 @@ APPS
 			if *flagURL != "" {
 				return cmd.ListCertsFromURL(*flagURL, cfg)
 			}
-			return cmd.ListCertsForPlatform(cfg)
+			return cmd.ListCertsForPlatform(cfg, opts, lopts)
 		},
 		appfn: func(a string) error {
-			return cmd.ListCertsForApp(a, cfg)
+			return cmd.ListCertsForApp(a, cfg, opts, lopts)
 		},
 		help: fmt.Sprintf(`Usage: cert-manage list [options]
 
@@ -291,11 +465,19 @@ FORMATTING
   Only show the count of certificates found
     cert-manage list -count
     cert-manage list -app java -count
+    cert-manage list -app java:/usr/lib/jvm/java-17-openjdk-amd64 -count
     cert-manage list -file <path> -count
 
   Show the certificates on a local webpage (Default: %s, Options: %s)
     cert-manage list -ui web
 
+  Show the certificates trusted for S/MIME email
+    cert-manage list -app firefox -usage email
+
+  Show the trusted certificates which are expired or revoked
+    cert-manage list -expired
+    cert-manage list -app java -revoked -crl /etc/pki/crl/root.crl
+
 APPS
   Supported apps: %s`,
 			ui.DefaultFormat(),
@@ -306,22 +488,43 @@ APPS
 	}
 	commands["restore"] = &command{
 		fn: func() error {
-			return cmd.RestoreForPlatform(*flagFile)
+			if *flagDryRun {
+				return cmd.PlanRestoreForPlatform(*flagFile, opts, cfg)
+			}
+			return cmd.RestoreForPlatform(*flagFile, opts)
 		},
 		appfn: func(a string) error {
-			return cmd.RestoreForApp(a, *flagFile)
+			if *flagDryRun {
+				return cmd.PlanRestoreForApp(a, *flagFile, opts, cfg)
+			}
+			return cmd.RestoreForApp(a, *flagFile, opts)
 		},
-		help: fmt.Sprintf(`Usage: cert-manage restore [-app <name>] [-file <path>]
+		help: fmt.Sprintf(`Usage: cert-manage restore [-app <name>] [-file <path|id|archive>] [-dry-run]
 
   Restore certificates from the latest backup
     cert-manage restore
 
-  Restore certificates for the platform from a file
+  Restore certificates for the platform from a backup file or directory
     cert-manage restore -file <path>
 
+  Restore certificates from a backup in the catalog (see 'cert-manage backups list')
+    cert-manage restore -app java -file <id>
+
+  Restore certificates from an archive made with 'cert-manage backup -out'
+    cert-manage restore -app java -file state.tar.gz
+
+  Decrypt an archive with an age identity file and only restore it if signed by a trusted key
+    cert-manage restore -app java -file state.tar.gz.age -identity key.txt -trusted-key ed25519.pub
+
   Restore certificates for an application from the latest backup
     cert-manage restore -app java
 
+  Show which backup would be restored and the certificates it changes
+    cert-manage restore -app java -dry-run
+
+  Restore a snapshot kept after a failed change couldn't be rolled back
+    cert-manage restore -app java -file ~/.cert-manage/snapshots/<id>/0/<backup>
+
 APPS
   Supported apps: %s`, strings.Join(store.GetApps(), ", ")),
 	}
@@ -331,16 +534,34 @@ APPS
 				callForHelp = true
 				return nil
 			}
-			return cmd.WhitelistForPlatform(*flagFile)
+			if *flagExplain && fs.NArg() > 0 {
+				return cmd.ExplainWhitelistForFile(*flagFile, fs.Arg(0), usage)
+			}
+			if *flagExplain {
+				return cmd.ExplainWhitelistForPlatform(*flagFile, opts, usage)
+			}
+			if *flagDryRun {
+				return cmd.PlanWhitelistForPlatform(*flagFile, opts, cfg)
+			}
+			return cmd.WhitelistForPlatform(*flagFile, opts)
 		},
 		appfn: func(a string) error {
 			if *flagFile == "" {
 				callForHelp = true
 				return nil
 			}
-			return cmd.WhitelistForApp(a, *flagFile)
+			if *flagExplain && fs.NArg() > 0 {
+				return cmd.ExplainWhitelistForFile(*flagFile, fs.Arg(0), usage)
+			}
+			if *flagExplain {
+				return cmd.ExplainWhitelistForApp(a, *flagFile, opts, usage)
+			}
+			if *flagDryRun {
+				return cmd.PlanWhitelistForApp(a, *flagFile, opts, cfg)
+			}
+			return cmd.WhitelistForApp(a, *flagFile, opts)
 		},
-		help: fmt.Sprintf(`Usage: cert-manage whitelist [-app <name>] -file <path>
+		help: fmt.Sprintf(`Usage: cert-manage whitelist [-app <name>] -file <path> [-dry-run] [-explain [<certs>]]
 
   Remove untrusted certificates from a store for the platform
     cert-manage whitelist -file whitelist.json
@@ -348,6 +569,15 @@ APPS
   Remove untrusted certificates in an app
     cert-manage whitelist -file whitelist.json -app java
 
+  Show which certificates would be removed or distrusted, without changing the store
+    cert-manage whitelist -file whitelist.json -dry-run
+    cert-manage whitelist -file whitelist.json -app firefox -dry-run -format json
+
+  Show why each certificate would be kept or removed, for a store or a file of certificates
+    cert-manage whitelist -file whitelist.json -app java -explain
+    cert-manage whitelist -file whitelist.json -app firefox -usage email -explain
+    cert-manage whitelist -file whitelist.json -explain certs.pem
+
 APPS
   Supported apps: %s`, strings.Join(store.GetApps(), ", ")),
 	}
@@ -382,7 +612,7 @@ APPS
 		}
 		os.Exit(0)
 	}
-	err := c.fn()
+	err = c.fn()
 	if err != nil {
 		fmt.Printf("ERROR: %v\n", err)
 		os.Exit(1)
@@ -395,6 +625,46 @@ APPS
 	}
 }
 
+// backupsCommand runs the sub-command of 'backups' (e.g. list), whose flags can
+// be mixed in with the sub-command and its arguments.
+func backupsCommand(opts *store.Options) error {
+	var args []string
+	for rest := fs.Args(); len(rest) > 0; rest = fs.Args()[1:] {
+		if err := fs.Parse(rest); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		args = append(args, fs.Arg(0))
+	}
+	if len(args) == 0 {
+		callForHelp = true
+		return nil
+	}
+	opts.Root = *flagRoot
+
+	arg := ""
+	if len(args) > 1 {
+		arg = args[1]
+	}
+	switch strings.ToLower(args[0]) {
+	case "list":
+		return cmd.ListBackups(*flagApp, opts)
+	case "show":
+		return cmd.ShowBackup(arg, opts)
+	case "verify":
+		return cmd.VerifyBackups(arg, opts)
+	case "prune":
+		if *flagKeep == 0 && *flagOlderThan == "" {
+			callForHelp = true
+			return nil
+		}
+		return cmd.PruneBackups(*flagKeep, *flagOlderThan, *flagDryRun, opts)
+	}
+	return fmt.Errorf("unknown backups command %q", args[0])
+}
+
 func getVersion() string {
 	return fmt.Sprintf("%s (Go: %s)", Version, runtime.Version())
 }
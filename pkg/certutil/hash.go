@@ -0,0 +1,191 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certutil
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"sort"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ASN.1 string types which OpenSSL canonicalizes
+const (
+	tagUTF8String      = 12
+	tagPrintableString = 19
+	tagT61String       = 20
+	tagIA5String       = 22
+	tagVisibleString   = 26
+	tagUniversalString = 28
+	tagBMPString       = 30
+)
+
+type attributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// SubjectHash returns the OpenSSL subject name hash of a certificate. This is
+// the value used to name files in hashed certificate directories (e.g.
+// /etc/ssl/certs/<hash>.0) and matches `openssl x509 -hash -noout`.
+//
+// The hash is the first four bytes (little-endian) of the SHA1 digest over the
+// canonical encoding of the subject. See X509_NAME_hash in OpenSSL's
+// crypto/x509/x509_cmp.c and x509_name_canon in crypto/x509/x_name.c
+func SubjectHash(c x509.Certificate) (string, error) {
+	canon, err := canonicalName(c.RawSubject)
+	if err != nil {
+		return "", fmt.Errorf("unable to canonicalize subject of %s: %v", StringifyPKIXName(c.Subject), err)
+	}
+	sum := sha1.Sum(canon)
+	h := uint32(sum[0]) | uint32(sum[1])<<8 | uint32(sum[2])<<16 | uint32(sum[3])<<24
+	return fmt.Sprintf("%08x", h), nil
+}
+
+// canonicalName re-encodes a DER encoded Name in OpenSSL's canonical form. Each
+// RDN is encoded as a DER SET (without the outer SEQUENCE) where string values
+// are converted to lowercase UTF8String's with whitespace trimmed and collapsed.
+func canonicalName(raw []byte) ([]byte, error) {
+	var rdns []asn1.RawValue
+	rest, err := asn1.Unmarshal(raw, &rdns)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d trailing bytes after subject", len(rest))
+	}
+
+	var out bytes.Buffer
+	for i := range rdns {
+		var entries [][]byte
+		set := rdns[i].Bytes
+		for len(set) > 0 {
+			var atv attributeTypeAndValue
+			set, err = asn1.Unmarshal(set, &atv)
+			if err != nil {
+				return nil, err
+			}
+			value, err := canonicalValue(atv.Value)
+			if err != nil {
+				return nil, err
+			}
+			oid, err := asn1.Marshal(atv.Type)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, encodeTLV(asn1.TagSequence, true, append(oid, value...)))
+		}
+
+		// DER requires SET OF members to be sorted by their encoding
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i], entries[j]) < 0
+		})
+		out.Write(encodeTLV(asn1.TagSet, true, bytes.Join(entries, nil)))
+	}
+	return out.Bytes(), nil
+}
+
+// canonicalValue returns the DER encoding of an attribute's value after applying
+// OpenSSL's asn1_string_canon rules. Non-string values are returned as-is.
+func canonicalValue(v asn1.RawValue) ([]byte, error) {
+	if v.Class != asn1.ClassUniversal {
+		return v.FullBytes, nil
+	}
+
+	var s []byte
+	switch v.Tag {
+	case tagUTF8String, tagPrintableString, tagIA5String, tagVisibleString:
+		s = v.Bytes
+	case tagT61String:
+		// OpenSSL treats T61String as ISO-8859-1
+		for _, b := range v.Bytes {
+			s = utf8.AppendRune(s, rune(b))
+		}
+	case tagBMPString:
+		if len(v.Bytes)%2 != 0 {
+			return nil, fmt.Errorf("invalid BMPString length %d", len(v.Bytes))
+		}
+		u := make([]uint16, len(v.Bytes)/2)
+		for i := range u {
+			u[i] = uint16(v.Bytes[2*i])<<8 | uint16(v.Bytes[2*i+1])
+		}
+		s = []byte(string(utf16.Decode(u)))
+	case tagUniversalString:
+		if len(v.Bytes)%4 != 0 {
+			return nil, fmt.Errorf("invalid UniversalString length %d", len(v.Bytes))
+		}
+		for i := 0; i < len(v.Bytes); i += 4 {
+			r := rune(v.Bytes[i])<<24 | rune(v.Bytes[i+1])<<16 | rune(v.Bytes[i+2])<<8 | rune(v.Bytes[i+3])
+			s = utf8.AppendRune(s, r)
+		}
+	default:
+		return v.FullBytes, nil
+	}
+
+	// Trim leading and trailing spaces, collapse inner spaces and lowercase ASCII
+	s = bytes.TrimFunc(s, isCanonSpace)
+	canon := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] >= utf8.RuneSelf:
+			canon = append(canon, s[i])
+		case isCanonSpace(rune(s[i])):
+			canon = append(canon, ' ')
+			for i+1 < len(s) && isCanonSpace(rune(s[i+1])) {
+				i++
+			}
+		case 'A' <= s[i] && s[i] <= 'Z':
+			canon = append(canon, s[i]+('a'-'A'))
+		default:
+			canon = append(canon, s[i])
+		}
+	}
+	return encodeTLV(tagUTF8String, false, canon), nil
+}
+
+func isCanonSpace(r rune) bool {
+	switch r {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+// encodeTLV writes a DER tag, length and value for a universal class tag
+func encodeTLV(tag int, compound bool, value []byte) []byte {
+	b := byte(tag)
+	if compound {
+		b |= 0x20
+	}
+	out := []byte{b}
+
+	n := len(value)
+	switch {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, value...)
+}
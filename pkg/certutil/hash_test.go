@@ -0,0 +1,79 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certutil
+
+import (
+	"encoding/asn1"
+	"testing"
+)
+
+func TestCertutil__SubjectHash(t *testing.T) {
+	// Answers from `openssl x509 -hash -noout -in <cert>`
+	cases := map[string][]string{
+		"../../testdata/example.crt": {"ce100da1"},
+		"../../testdata/lots.crt":    {"aee5f10d", "02265526", "106f3e4d", "6b99d060", "128805a3"},
+	}
+	for path, answers := range cases {
+		certs, err := FromFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certs) != len(answers) {
+			t.Fatalf("%s: got %d certs", path, len(certs))
+		}
+		for i := range certs {
+			hash, err := SubjectHash(*certs[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != answers[i] {
+				t.Errorf("%s idx %d: got %s, expected %s", path, i, hash, answers[i])
+			}
+		}
+	}
+}
+
+func TestCertutil__canonicalValue(t *testing.T) {
+	cases := []struct {
+		tag      int
+		in, want string
+	}{
+		{tagPrintableString, "  Example   CA  ", "example ca"},
+		{tagUTF8String, "Tanúsítványkiadók\tROOT", "tanúsítványkiadók root"},
+		{tagIA5String, "Mixed\n\nCase", "mixed case"},
+		{tagT61String, "Caf\xe9", "café"},
+	}
+	for i := range cases {
+		out, err := canonicalValue(asn1RawString(cases[i].tag, cases[i].in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out[0] != tagUTF8String {
+			t.Errorf("idx %d: expected UTF8String, got tag %d", i, out[0])
+		}
+		if got := string(out[2:]); got != cases[i].want {
+			t.Errorf("idx %d: got %q, expected %q", i, got, cases[i].want)
+		}
+	}
+}
+
+func asn1RawString(tag int, s string) asn1.RawValue {
+	return asn1.RawValue{
+		Class:     asn1.ClassUniversal,
+		Tag:       tag,
+		Bytes:     []byte(s),
+		FullBytes: encodeTLV(tag, false, []byte(s)),
+	}
+}
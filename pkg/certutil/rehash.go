@@ -0,0 +1,105 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+var (
+	// hashLinkRegex matches the <hash>.<n> certificate links created by c_rehash
+	hashLinkRegex = regexp.MustCompile(`^[0-9a-f]{8}\.[0-9]+$`)
+
+	// rehashSuffixes are the file extensions c_rehash reads certificates from
+	rehashSuffixes = []string{".pem", ".crt", ".cer"}
+)
+
+// Rehash rebuilds the <hash>.<n> symlinks in dir which OpenSSL uses to look up
+// certificates by subject, the same as `c_rehash` or `openssl rehash`.
+//
+// Every existing hash link is removed (so stale links are dropped) and a link is
+// created for each *.pem, *.crt and *.cer file holding exactly one certificate.
+// Certificates whose subjects share a hash are numbered in order (.0, .1, ...)
+// and duplicate certificates are only linked once.
+//
+// Absolute symlinks in dir are followed under root, which is empty for "/".
+func Rehash(root, dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// Drop existing hash links, they're recreated below
+	var names []string
+	for i := range fis {
+		name := fis[i].Name()
+		if hashLinkRegex.MatchString(name) && fis[i].Mode()&os.ModeSymlink != 0 {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if !fis[i].IsDir() && hasRehashSuffix(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	seen := make(map[string]int)    // hash -> next suffix
+	linked := make(map[string]bool) // fingerprints already linked
+	for i := range names {
+		path, err := file.ResolveLinks(root, filepath.Join(dir, names[i]))
+		if err != nil {
+			continue // dangling link
+		}
+		certs, err := FromFile(path)
+		if err != nil || len(certs) != 1 {
+			continue // c_rehash skips files which aren't a single certificate
+		}
+		fp := GetHexSHA256Fingerprint(*certs[0])
+		if linked[fp] {
+			continue // c_rehash also skips duplicate certificates
+		}
+		linked[fp] = true
+
+		hash, err := SubjectHash(*certs[0])
+		if err != nil {
+			return err
+		}
+		link := filepath.Join(dir, fmt.Sprintf("%s.%d", hash, seen[hash]))
+		seen[hash]++
+		if err := os.Symlink(names[i], link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasRehashSuffix(name string) bool {
+	for i := range rehashSuffixes {
+		if strings.HasSuffix(name, rehashSuffixes[i]) {
+			return true
+		}
+	}
+	return false
+}
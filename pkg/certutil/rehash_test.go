@@ -0,0 +1,135 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCertutil__Rehash(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require extra permissions on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "cert-manage-certutil-rehash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lots, err := FromFile("../../testdata/lots.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two certificates with the same subject (and hash)
+	first, second := selfSigned(t, "Collision CA"), selfSigned(t, "Collision CA")
+
+	write := func(name string, certs ...*x509.Certificate) {
+		if err := ToFile(filepath.Join(dir, name), certs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.pem", lots[0])
+	write("b.crt", first)
+	write("c.cer", second)
+	write("d.pem", lots[0])           // duplicate of a.pem
+	write("bundle.pem", lots[1:3]...) // multiple certs are skipped
+	write("e.txt", lots[3])           // unknown extension
+
+	// Stale links are removed
+	if err := os.Symlink("missing.pem", filepath.Join(dir, "00000000.0")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Rehash("", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	collision, err := SubjectHash(*first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"aee5f10d.0":     "a.pem",
+		collision + ".0": "b.crt",
+		collision + ".1": "c.cer",
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := 0
+	for i := range fis {
+		if fis[i].Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		links++
+		target, err := os.Readlink(filepath.Join(dir, fis[i].Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected[fis[i].Name()] != target {
+			t.Errorf("unexpected link %s -> %s", fis[i].Name(), target)
+		}
+	}
+	if links != len(expected) {
+		t.Errorf("got %d links, expected %d", links, len(expected))
+	}
+
+	// Rehashing again is stable
+	if err := Rehash("", dir); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, collision+".1")); err != nil || target != "c.cer" {
+		t.Errorf("target=%q err=%v", target, err)
+	}
+}
+
+func selfSigned(t *testing.T, cn string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -18,26 +18,27 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"runtime"
 
 	"github.com/adamdecaf/cert-manage/pkg/certutil"
 	"github.com/adamdecaf/cert-manage/pkg/store"
 )
 
-func AddCertsFromFile(where string) error {
-	st := store.Platform()
-	return addCerts(st, where)
+func AddCertsFromFile(where string, opts *store.Options) error {
+	st := store.Platform(opts)
+	return addCerts(runtime.GOOS, st, where, opts)
 }
 
-func AddCertsToAppFromFile(app string, where string) error {
-	st, err := store.ForApp(app)
+func AddCertsToAppFromFile(app string, where string, opts *store.Options) error {
+	st, err := store.ForApp(app, opts)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	return addCerts(st, where)
+	return addCerts(app, st, where, opts)
 }
 
-func addCerts(st store.Store, where string) error {
+func addCerts(name string, st store.Store, where string, opts *store.Options) error {
 	bs, err := ioutil.ReadFile(where)
 	if err != nil {
 		fmt.Println(err)
@@ -48,5 +49,7 @@ func addCerts(st store.Store, where string) error {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	return st.Add(certs)
+	return transact(name, st, opts, func() error {
+		return st.Add(certs)
+	})
 }
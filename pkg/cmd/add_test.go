@@ -0,0 +1,53 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/store"
+)
+
+func TestCmdAdd__newDockerHost(t *testing.T) {
+	home, err := ioutil.TempDir("", "cert-manage-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	t.Setenv("HOME", home)
+
+	root, err := ioutil.TempDir("", "cert-manage-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// The host has no certs.d directory yet, so there's nothing to back up
+	opts := &store.Options{Root: root}
+	if err := AddCertsToAppFromFile("docker:new.example.com", "../../testdata/example.crt", opts); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := certutil.FromFile(filepath.Join(root, "etc/docker/certs.d/new.example.com/ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 {
+		t.Errorf("got %d certificates", len(certs))
+	}
+}
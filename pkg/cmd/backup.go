@@ -16,26 +16,83 @@ package cmd
 
 import (
 	"fmt"
+	"runtime"
 
 	"github.com/adamdecaf/cert-manage/pkg/store"
 )
 
-func BackupForApp(app string) error {
-	s, err := store.ForApp(app)
+// BackupForApp backs up each of an app's stores. When out is given the backups are
+// also saved in an archive at out, which can be restored on another host.
+func BackupForApp(app, out string, opts *store.Options) error {
+	s, err := store.ForApp(app, opts)
 	if err != nil {
 		return err
 	}
-	err = s.Backup()
+	if stores := namedStores(s); stores != nil {
+		err = eachStore(stores, func(ns store.NamedStore) error {
+			if err := ns.Backup(); err != nil {
+				return err
+			}
+			fmt.Printf("%s: backup saved\n", ns.Name)
+			return nil
+		})
+	} else {
+		err = s.Backup()
+	}
+	if err == nil {
+		err = recordBackups(separateStores(app, s), opts)
+	}
+	if err == nil && out != "" {
+		err = writeArchive(out, separateStores(app, s), opts)
+	}
 	if err == nil {
 		fmt.Println("Backup completed successfully")
 	}
 	return err
 }
 
-func BackupForPlatform() error {
-	err := store.Platform().Backup()
+// BackupForPlatform backs up the platform store. When out is given the backup is
+// also saved in an archive at out, which can be restored on another host.
+func BackupForPlatform(out string, opts *store.Options) error {
+	s := store.Platform(opts)
+	err := s.Backup()
+	if err == nil {
+		err = recordBackups(separateStores(runtime.GOOS, s), opts)
+	}
+	if err == nil && out != "" {
+		err = writeArchive(out, separateStores(runtime.GOOS, s), opts)
+	}
 	if err == nil {
 		fmt.Println("Backup completed successfully")
 	}
 	return err
 }
+
+// recordBackups adds the latest backup of each store to the backup index
+func recordBackups(stores []store.NamedStore, opts *store.Options) error {
+	idx, err := store.OpenBackupIndex(opts)
+	if err != nil {
+		return err
+	}
+	return eachStore(stores, func(ns store.NamedStore) error {
+		_, err := idx.Record(ns.Name, ns.Store)
+		if err == store.ErrNoBackupMade {
+			return nil // stores without backups (e.g. windows)
+		}
+		return err
+	})
+}
+
+// writeArchive saves the latest backup of each store into an archive at out, which
+// is encrypted and signed as opts asks
+func writeArchive(out string, stores []store.NamedStore, opts *store.Options) error {
+	m, err := store.WriteArchive(out, stores, opts)
+	if err != nil {
+		return err
+	}
+	for i := range m.Stores {
+		fmt.Printf("%s: archived %d certificates\n", m.Stores[i].Name, m.Stores[i].Certificates)
+	}
+	fmt.Printf("Archive saved to %s\n", out)
+	return nil
+}
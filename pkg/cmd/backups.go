@@ -0,0 +1,192 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/store"
+)
+
+var backupTimeFormat = "2006-01-02 15:04:05"
+
+// ListBackups shows each recorded backup, newest first. If app is non-empty only
+// backups of its stores are shown.
+func ListBackups(app string, opts *store.Options) error {
+	idx, err := store.OpenBackupIndex(opts)
+	if err != nil {
+		return err
+	}
+	var backups []store.Backup
+	for _, b := range idx.Backups() {
+		if app == "" || backupOfApp(b, app) {
+			backups = append(backups, b)
+		}
+	}
+	if len(backups) == 0 {
+		fmt.Println("No backups found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(w, "ID\tStore\tCreated\tCertificates\tSize\tHost")
+	for _, b := range backups {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", b.ID, b.Store, b.Created.Local().Format(backupTimeFormat), b.Certificates, formatSize(b.Size), b.Host)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("problem flushing output: %v", err)
+	}
+	return nil
+}
+
+// backupOfApp returns true if the backup is of app's store, or one of its installs
+func backupOfApp(b store.Backup, app string) bool {
+	return strings.EqualFold(b.Store, app) || strings.HasPrefix(strings.ToLower(b.Store), strings.ToLower(app)+":")
+}
+
+// ShowBackup prints the details of a backup and each of its files
+func ShowBackup(id string, opts *store.Options) error {
+	if id == "" {
+		return errNoBackupID
+	}
+	idx, err := store.OpenBackupIndex(opts)
+	if err != nil {
+		return err
+	}
+	b, err := idx.Find(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("ID:           %s\n", b.ID)
+	fmt.Printf("Store:        %s\n", b.Store)
+	fmt.Printf("Host:         %s\n", b.Host)
+	fmt.Printf("Created:      %s\n", b.Created.Local().Format(backupTimeFormat))
+	fmt.Printf("Location:     %s\n", idx.Location(b))
+	fmt.Printf("Certificates: %d\n", b.Certificates)
+	fmt.Printf("Size:         %s\n", formatSize(b.Size))
+	fmt.Println("Files:")
+
+	names := make([]string, 0, len(b.Files))
+	for name := range b.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\t%s\n", name, b.Files[name])
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("problem flushing output: %v", err)
+	}
+	return nil
+}
+
+// VerifyBackups checks the files of a backup (or every backup when id is empty)
+// against the checksums recorded when it was taken.
+func VerifyBackups(id string, opts *store.Options) error {
+	idx, err := store.OpenBackupIndex(opts)
+	if err != nil {
+		return err
+	}
+	backups := idx.Backups()
+	if id != "" {
+		b, err := idx.Find(id)
+		if err != nil {
+			return err
+		}
+		backups = []store.Backup{b}
+	}
+
+	failed := 0
+	for _, b := range backups {
+		if err := idx.Verify(b); err != nil {
+			fmt.Printf("%s (%s): %v\n", b.ID, b.Store, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s (%s): OK\n", b.ID, b.Store)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d backups failed verification", failed, len(backups))
+	}
+	return nil
+}
+
+// PruneBackups deletes the backups of each store older than olderThan (e.g. 30d),
+// other than the newest keep backups. With dryRun the backups are only shown.
+func PruneBackups(keep int, olderThan string, dryRun bool, opts *store.Options) error {
+	var age time.Duration
+	if olderThan != "" {
+		d, err := parseAge(olderThan)
+		if err != nil {
+			return err
+		}
+		age = d
+	}
+	idx, err := store.OpenBackupIndex(opts)
+	if err != nil {
+		return err
+	}
+	pruned, err := idx.Prune(keep, age, dryRun)
+	if err != nil {
+		return err
+	}
+
+	verb := "pruned"
+	if dryRun {
+		verb = "would be pruned"
+	}
+	for _, b := range pruned {
+		fmt.Printf("%s (%s, %s): %s\n", b.ID, b.Store, b.Created.Local().Format(backupTimeFormat), verb)
+	}
+	fmt.Printf("%d backups %s\n", len(pruned), verb)
+	return nil
+}
+
+// parseAge reads a duration such as 30d, 12h or 1h30m
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+var errNoBackupID = errors.New("no backup id given")
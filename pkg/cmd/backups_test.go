@@ -0,0 +1,51 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/store"
+)
+
+func TestCmdBackups__parseAge(t *testing.T) {
+	cases := map[string]time.Duration{
+		"30d":   30 * 24 * time.Hour,
+		"0d":    0,
+		"12h":   12 * time.Hour,
+		"1h30m": 90 * time.Minute,
+	}
+	for in, expected := range cases {
+		if d, err := parseAge(in); err != nil || d != expected {
+			t.Errorf("%s: got %v err=%v", in, d, err)
+		}
+	}
+	for _, in := range []string{"", "d", "-1d", "30", "tomorrow"} {
+		if _, err := parseAge(in); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestCmdBackups__backupOfApp(t *testing.T) {
+	b := store.Backup{Store: "java:/usr/lib/jvm/java-17"}
+	if !backupOfApp(b, "java") || !backupOfApp(b, "JAVA:/usr/lib/jvm/java-17") {
+		t.Error("expected backup of java")
+	}
+	if backupOfApp(b, "jav") || backupOfApp(b, "firefox") {
+		t.Error("expected only a backup of java")
+	}
+}
@@ -24,8 +24,8 @@ import (
 	"github.com/adamdecaf/cert-manage/pkg/store"
 )
 
-func ConnectWithPlatformStore(uri *url.URL) error {
-	st := store.Platform()
+func ConnectWithPlatformStore(uri *url.URL, opts *store.Options) error {
+	st := store.Platform(opts)
 	certs, err := st.List(&store.ListOptions{
 		Trusted: true,
 	})
@@ -35,8 +35,8 @@ func ConnectWithPlatformStore(uri *url.URL) error {
 	return connect(uri, certs)
 }
 
-func ConnectWithAppStore(uri *url.URL, app string) error {
-	st, err := store.ForApp(app)
+func ConnectWithAppStore(uri *url.URL, app string, opts *store.Options) error {
+	st, err := store.ForApp(app, opts)
 	if err != nil {
 		return fmt.Errorf("problem finding %s: %v", app, err)
 	}
@@ -44,7 +44,7 @@ func TestCmdConnect_platform(t *testing.T) {
 		t.Skip("windows isn't supported, yet")
 	}
 
-	if err := ConnectWithPlatformStore(connectExampleUrl); err != nil {
+	if err := ConnectWithPlatformStore(connectExampleUrl, nil); err != nil {
 		t.Fatalf("problem with -connect on platform store: %v", err)
 	}
 }
@@ -60,7 +60,7 @@ func TestCmdConnect_app(t *testing.T) {
 		t.Skip("can't quickly find java")
 	}
 
-	if err := ConnectWithAppStore(connectExampleUrl, connectExampleApp); err != nil {
+	if err := ConnectWithAppStore(connectExampleUrl, connectExampleApp, nil); err != nil {
 		t.Fatalf("problem with -connect on %s store: %v", connectExampleApp, err)
 	}
 }
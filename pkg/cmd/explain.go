@@ -0,0 +1,114 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"text/tabwriter"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/store"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+// ExplainWhitelistForApp shows if each certificate an app's stores trust for usage
+// would be kept or removed by the whitelist, along with the rule which decided it.
+func ExplainWhitelistForApp(app, whpath string, opts *store.Options, usage whitelist.Usage) error {
+	wh, err := whitelist.FromFile(whpath)
+	if err != nil {
+		return err
+	}
+	s, err := store.ForApp(app, opts)
+	if err != nil {
+		return err
+	}
+	return explainStores(separateStores(app, s), wh, usage)
+}
+
+// ExplainWhitelistForPlatform shows if each certificate the platform trusts for usage
+// would be kept or removed by the whitelist, along with the rule which decided it.
+func ExplainWhitelistForPlatform(whpath string, opts *store.Options, usage whitelist.Usage) error {
+	wh, err := whitelist.FromFile(whpath)
+	if err != nil {
+		return err
+	}
+	return explainStores(separateStores(runtime.GOOS, store.Platform(opts)), wh, usage)
+}
+
+// ExplainWhitelistForFile shows if each certificate in a file would be kept or
+// removed by the whitelist, along with the rule which decided it.
+func ExplainWhitelistForFile(whpath, where string, usage whitelist.Usage) error {
+	wh, err := whitelist.FromFile(whpath)
+	if err != nil {
+		return err
+	}
+	certs, err := certutil.FromFile(where)
+	if err != nil {
+		return err
+	}
+	return explain(os.Stdout, certs, wh, usage)
+}
+
+func explainStores(stores []store.NamedStore, wh whitelist.Whitelist, usage whitelist.Usage) error {
+	return eachStore(stores, func(ns store.NamedStore) error {
+		certs, err := ns.List(&store.ListOptions{
+			Trusted: true,
+			Usage:   usage,
+		})
+		if err != nil {
+			return err
+		}
+		if len(stores) > 1 {
+			fmt.Printf("%s:\n", ns.Name)
+		}
+		return explain(os.Stdout, certs, wh, usage)
+	})
+}
+
+// explain writes a table of each certificate, if it's kept or removed and why
+func explain(out io.Writer, certs []*x509.Certificate, wh whitelist.Whitelist, usage whitelist.Usage) error {
+	if len(certs) == 0 {
+		fmt.Fprintln(out, "No certificates")
+		return nil
+	}
+	certutil.Sort(certs)
+
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(w, "Subject\tSHA256 Fingerprint\tResult\tReason")
+	kept := 0
+	for i := range certs {
+		m := wh.Explain(certs[i], usage)
+		result := "remove"
+		if m.Whitelisted {
+			result = "keep"
+			kept++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			certutil.StringifyPKIXName(certs[i].Subject),
+			certutil.GetHexSHA256Fingerprint(*certs[i])[:16],
+			result,
+			m,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("problem flushing output: %v", err)
+	}
+	fmt.Fprintf(out, "%d kept, %d removed for %s\n", kept, len(certs)-kept, usage)
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+func TestCmdExplain(t *testing.T) {
+	t.Parallel()
+
+	certs, err := certutil.FromFile("../../testdata/lots.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh, err := whitelist.FromFile("../../testdata/us-whitelist.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := explain(&buf, certs, wh, whitelist.UsageTLS); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(certs)+2 {
+		t.Fatalf("got %d lines:\n%s", len(lines), buf.String())
+	}
+	if !strings.HasPrefix(lines[1], "EE Certification Centre Root CA") || !strings.HasSuffix(lines[1], "remove no rule") {
+		t.Errorf("got %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "keep   country US") {
+		t.Errorf("got %q", lines[2])
+	}
+	if lines[len(lines)-1] != "3 kept, 2 removed for tls" {
+		t.Errorf("got %q", lines[len(lines)-1])
+	}
+
+	// The whitelist command also explains certificates in files
+	if err := ExplainWhitelistForFile("../../testdata/us-whitelist.yaml", "../../testdata/example.crt", whitelist.UsageTLS); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -70,7 +70,7 @@ func GenerateWhitelist(output string, from, file string) error {
 				return gen.FromFile(file)
 			}, uacc, eacc)
 			list := func() ([]*x509.Certificate, error) {
-				return store.Platform().List(&store.ListOptions{
+				return store.Platform(nil).List(&store.ListOptions{
 					Trusted: true,
 				})
 			}
@@ -179,9 +179,9 @@ func addCertsToPool(pool *x509.CertPool, f func() ([]*x509.Certificate, error))
 }
 
 func addCertsToPoolForApp(pool *x509.CertPool, appName string) {
-	st, err := store.ForApp(appName)
+	st, err := store.ForApp(appName, nil)
 	if err != nil {
-		st = store.Platform() // try and give something as a root store
+		st = store.Platform(nil) // try and give something as a root store
 	}
 	list := func() ([]*x509.Certificate, error) {
 		return st.List(&store.ListOptions{
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/adamdecaf/cert-manage/pkg/certutil"
 	"github.com/adamdecaf/cert-manage/pkg/httputil"
@@ -84,11 +85,9 @@ func ListCertsFromURL(where string, cfg *ui.Config) (err error) {
 // ListCertsForPlatform finds certs for the given platform.
 // The supported platforms can be found in the readme. They're compiled in
 // with build flags in the `certs/find_*.go` files.
-func ListCertsForPlatform(cfg *ui.Config) error {
-	st := store.Platform()
-	certificates, err := st.List(&store.ListOptions{
-		Trusted: true,
-	})
+func ListCertsForPlatform(cfg *ui.Config, opts *store.Options, lopts *store.ListOptions) error {
+	st := store.Platform(opts)
+	certificates, err := st.List(listOptions(lopts))
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -100,16 +99,45 @@ func ListCertsForPlatform(cfg *ui.Config) error {
 // ListCertsForApp finds certs for the given app.
 // The supported applications are listed in the readme. This includes
 // non-traditional applications like NSS.
-func ListCertsForApp(app string, cfg *ui.Config) error {
-	st, err := store.ForApp(app)
+func ListCertsForApp(app string, cfg *ui.Config, opts *store.Options, lopts *store.ListOptions) error {
+	st, err := store.ForApp(app, opts)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	certificates, err := st.List(&store.ListOptions{
-		Trusted: true,
-	})
+	// Summarize each install (e.g. every JDK) or scope when only counting
+	stores := namedStores(st)
+	if stores == nil {
+		stores = scopedStores(st)
+	}
+	if cfg.Count && stores != nil {
+		return eachStore(stores, func(ns store.NamedStore) error {
+			certs, err := ns.List(listOptions(lopts))
+			if err == nil {
+				fmt.Printf("%s: %d\n", ns.Name, len(certs))
+			}
+			return err
+		})
+	}
+
+	// Show which scope (e.g. registry host) certificates are trusted for
+	if stores := scopedStores(st); !cfg.Count && stores != nil && strings.EqualFold(cfg.UI, ui.DefaultUI()) {
+		return eachStore(stores, func(ns store.NamedStore) error {
+			certs, err := ns.List(listOptions(lopts))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s (%s):\n", ns.Scope, ns.Name)
+			if len(certs) == 0 {
+				fmt.Println("  No certificates")
+				return nil
+			}
+			return ui.ListCertificates(certs, cfg)
+		})
+	}
+
+	certificates, err := st.List(listOptions(lopts))
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -126,6 +154,16 @@ func ListCertsForApp(app string, cfg *ui.Config) error {
 	return ui.ListCertificatesWithMeta(meta, certificates, cfg)
 }
 
+// listOptions returns lopts, defaulting to trusted certificates
+func listOptions(lopts *store.ListOptions) *store.ListOptions {
+	if lopts == nil {
+		return &store.ListOptions{
+			Trusted: true,
+		}
+	}
+	return lopts
+}
+
 func createMeta(st store.Store) ui.Meta {
 	info := st.GetInfo()
 	return ui.Meta{
@@ -0,0 +1,74 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/adamdecaf/cert-manage/pkg/store"
+)
+
+// namedStores returns the stores behind a store.MultiStore (e.g. each installed JDK)
+// when there's more than one of them, so commands can summarize each store. Otherwise
+// nil is returned and the store is treated as a whole, which is always the case for
+// scoped stores (see scopedStores) as they're backed up and restored together.
+func namedStores(s store.Store) []store.NamedStore {
+	ms, ok := s.(store.MultiStore)
+	if !ok || scopedStores(s) != nil {
+		return nil
+	}
+	stores := ms.Stores()
+	if len(stores) < 2 {
+		return nil
+	}
+	return stores
+}
+
+// scopedStores returns the stores behind a store.MultiStore when their certificates
+// are scoped (e.g. to a registry host), so they can be listed under each scope.
+func scopedStores(s store.Store) []store.NamedStore {
+	ms, ok := s.(store.MultiStore)
+	if !ok {
+		return nil
+	}
+	stores := ms.Stores()
+	if len(stores) == 0 {
+		return nil
+	}
+	for i := range stores {
+		if stores[i].Scope == "" {
+			return nil
+		}
+	}
+	return stores
+}
+
+// countTrusted returns how many certificates a store trusts
+func countTrusted(s store.Store) (int, error) {
+	certs, err := s.List(&store.ListOptions{
+		Trusted: true,
+	})
+	return len(certs), err
+}
+
+// eachStore runs fn against each store, prefixing any error with the store's name
+func eachStore(stores []store.NamedStore, fn func(store.NamedStore) error) error {
+	for i := range stores {
+		if err := fn(stores[i]); err != nil {
+			return fmt.Errorf("%s: %v", stores[i].Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/store"
+	"github.com/adamdecaf/cert-manage/pkg/ui"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+// PlanWhitelistForApp shows which certificates `whitelist` would remove or distrust
+// in an app's stores, without changing them.
+func PlanWhitelistForApp(app, whpath string, opts *store.Options, cfg *ui.Config) error {
+	wh, err := whitelist.FromFile(whpath)
+	if err != nil {
+		return err
+	}
+	s, err := store.ForApp(app, opts)
+	if err != nil {
+		return err
+	}
+	return planWhitelist(app, s, wh, cfg)
+}
+
+// PlanWhitelistForPlatform shows which certificates `whitelist` would remove or
+// distrust in the platform store, without changing it.
+func PlanWhitelistForPlatform(whpath string, opts *store.Options, cfg *ui.Config) error {
+	wh, err := whitelist.FromFile(whpath)
+	if err != nil {
+		return err
+	}
+	return planWhitelist(runtime.GOOS, store.Platform(opts), wh, cfg)
+}
+
+func planWhitelist(name string, s store.Store, wh whitelist.Whitelist, cfg *ui.Config) error {
+	stores := separateStores(name, s)
+
+	// whitelist fails without a backup, so the plan does too
+	err := eachStore(stores, func(ns store.NamedStore) error {
+		latest, err := ns.GetLatestBackup()
+		if err != nil {
+			return fmt.Errorf("can't get latest backup err=%v", err)
+		}
+		if latest == "" {
+			return errors.New("no backup found")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	plan, err := makePlan("whitelist", stores, func(ns store.NamedStore, sp *ui.StorePlan) error {
+		changes, err := store.PlanRemove(ns.Store, wh)
+		sp.Changes = planChanges(changes)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return ui.ShowPlan(plan, cfg)
+}
+
+// PlanAddCertsToAppFromFile shows which certificates `add` would add to an app's
+// stores, without changing them.
+func PlanAddCertsToAppFromFile(app, where string, opts *store.Options, cfg *ui.Config) error {
+	s, err := store.ForApp(app, opts)
+	if err != nil {
+		return err
+	}
+	return planAdd(app, s, where, cfg)
+}
+
+// PlanAddCertsFromFile shows which certificates `add` would add to the platform
+// store, without changing it.
+func PlanAddCertsFromFile(where string, opts *store.Options, cfg *ui.Config) error {
+	return planAdd(runtime.GOOS, store.Platform(opts), where, cfg)
+}
+
+func planAdd(name string, s store.Store, where string, cfg *ui.Config) error {
+	bs, err := ioutil.ReadFile(where)
+	if err != nil {
+		return err
+	}
+	certs, err := certutil.Decode(bs)
+	if err != nil {
+		return err
+	}
+
+	plan, err := makePlan("add", separateStores(name, s), func(ns store.NamedStore, sp *ui.StorePlan) error {
+		changes, err := store.PlanAdd(ns.Store, certs)
+		sp.Changes = planChanges(changes)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return ui.ShowPlan(plan, cfg)
+}
+
+// PlanRestoreForApp shows which backup `restore` would restore for each of an app's
+// stores and the certificates that would change, without changing them. where is
+// an optional backup path or ID.
+func PlanRestoreForApp(app, where string, opts *store.Options, cfg *ui.Config) error {
+	s, err := store.ForApp(app, opts)
+	if err != nil {
+		return err
+	}
+	return planRestore(app, where, s, opts, cfg)
+}
+
+// PlanRestoreForPlatform shows which backup `restore` would restore for the platform
+// store and the certificates that would change, without changing it. where is an
+// optional backup path or ID.
+func PlanRestoreForPlatform(where string, opts *store.Options, cfg *ui.Config) error {
+	return planRestore(runtime.GOOS, where, store.Platform(opts), opts, cfg)
+}
+
+func planRestore(name, where string, s store.Store, opts *store.Options, cfg *ui.Config) error {
+	where, cleanup, err := unpackArchive(where, opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	stores := separateStores(name, s)
+	if where != "" && len(stores) > 1 {
+		// Only the store where is a backup of would be restored
+		if matched := restoreStores(stores, where); len(matched) > 0 {
+			stores = matched
+		}
+	}
+	plan, err := makePlan("restore", stores, func(ns store.NamedStore, sp *ui.StorePlan) error {
+		backup, changes, err := store.PlanRestore(ns.Store, where)
+		sp.Backup = backup
+		sp.Changes = planChanges(changes)
+		if err == store.ErrRestoreNotPlanned {
+			sp.Note = fmt.Sprintf("Changes are unknown, %v", err)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return ui.ShowPlan(plan, cfg)
+}
+
+// separateStores returns the stores to plan changes for (or record backups of)
+// separately, which are the stores behind a store.MultiStore (e.g. each JDK).
+// Scoped stores are kept whole as that's how they're added to and restored.
+func separateStores(name string, s store.Store) []store.NamedStore {
+	if ms, ok := s.(store.MultiStore); ok && scopedStores(s) == nil {
+		if stores := ms.Stores(); len(stores) > 0 {
+			return stores
+		}
+	}
+	return []store.NamedStore{
+		{
+			Name:  name,
+			Store: s,
+		},
+	}
+}
+
+// makePlan runs fn to fill in the plan of each store
+func makePlan(command string, stores []store.NamedStore, fn func(store.NamedStore, *ui.StorePlan) error) (ui.Plan, error) {
+	plan := ui.Plan{
+		Command: command,
+		Stores:  make([]ui.StorePlan, len(stores)),
+	}
+	for i := range stores {
+		plan.Stores[i] = ui.StorePlan{
+			Name:     stores[i].Name,
+			Location: stores[i].GetInfo().Location,
+		}
+		if err := fn(stores[i], &plan.Stores[i]); err != nil {
+			return plan, fmt.Errorf("%s: %v", stores[i].Name, err)
+		}
+	}
+	return plan, nil
+}
+
+func planChanges(changes []store.Change) []ui.Change {
+	out := make([]ui.Change, len(changes))
+	for i := range changes {
+		out[i] = ui.Change{
+			Action:      string(changes[i].Action),
+			Usage:       string(changes[i].Usage),
+			Certificate: changes[i].Certificate,
+		}
+	}
+	return out
+}
@@ -16,26 +16,93 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"runtime"
 
 	"github.com/adamdecaf/cert-manage/pkg/store"
 )
 
-func RestoreForApp(app, path string) error {
-	s, err := store.ForApp(app)
+// RestoreForApp restores each of an app's stores from their latest backup, or only
+// the store path is a backup of. path is either a backup file or directory, or the
+// ID of a backup from the catalog.
+func RestoreForApp(app, path string, opts *store.Options) error {
+	s, err := store.ForApp(app, opts)
 	if err != nil {
 		return err
 	}
-	err = s.Restore(path)
+	path, cleanup, err := unpackArchive(path, opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	stores := namedStores(s)
+	if path != "" {
+		// Let the store find which of its stores path is a backup of
+		stores = nil
+	}
+	err = transact(app, s, opts, func() error {
+		if stores != nil {
+			return eachStore(stores, func(ns store.NamedStore) error {
+				if err := ns.Restore(path); err != nil {
+					return err
+				}
+				fmt.Printf("%s: restored\n", ns.Name)
+				return nil
+			})
+		}
+		return s.Restore(path)
+	})
 	if err == nil {
 		fmt.Println("Restore completed successfully")
 	}
 	return err
 }
 
-func RestoreForPlatform(path string) error {
-	err := store.Platform().Restore(path)
+// RestoreForPlatform restores the platform store from its latest backup, or the
+// backup file, directory or ID path refers to.
+func RestoreForPlatform(path string, opts *store.Options) error {
+	path, cleanup, err := unpackArchive(path, opts)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	s := store.Platform(opts)
+	err = transact(runtime.GOOS, s, opts, func() error {
+		return s.Restore(path)
+	})
 	if err == nil {
 		fmt.Println("Restore completed successfully")
 	}
 	return err
 }
+
+// restoreStores returns the stores where is a backup of
+func restoreStores(stores []store.NamedStore, where string) []store.NamedStore {
+	var out []store.NamedStore
+	for i := range stores {
+		if _, err := store.RestorePoint(stores[i].Store, where); err == nil {
+			out = append(out, stores[i])
+		}
+	}
+	return out
+}
+
+// unpackArchive returns where to restore from, which is a temporary directory the
+// archive at path is unpacked into (after its signature is checked and it's
+// decrypted). Other paths are returned as-is, unless they're an unpacked archive
+// which can't be verified. cleanup removes the directory.
+func unpackArchive(path string, opts *store.Options) (string, func(), error) {
+	if !store.IsArchive(path) {
+		if err := store.CheckArchiveDir(path, opts); err != nil {
+			return "", nil, err
+		}
+		return path, func() {}, nil
+	}
+	dir, _, err := store.UnpackArchive(path, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
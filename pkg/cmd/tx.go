@@ -0,0 +1,44 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/adamdecaf/cert-manage/pkg/store"
+)
+
+// transact runs change against s with store.Transact, printing what happened to
+// each store when change fails and it's rolled back.
+func transact(name string, s store.Store, opts *store.Options, change func() error) error {
+	err := store.Transact(name, s, opts, change)
+	txe, ok := err.(*store.TxError)
+	if !ok {
+		return err
+	}
+	for _, r := range txe.Rollbacks {
+		if r.Err == nil {
+			fmt.Printf("%s: rolled back\n", r.Store)
+			continue
+		}
+		fmt.Printf("%s: rollback failed: %v\n", r.Store, r.Err)
+		if r.Snapshot == "" {
+			fmt.Printf("  %s was created by the change, remove it to roll back\n", r.Created)
+			continue
+		}
+		fmt.Printf("  snapshot kept at %s, try: cert-manage restore -file %s\n", r.Snapshot, r.Snapshot)
+	}
+	return txe
+}
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 
@@ -22,7 +23,7 @@ import (
 	"github.com/adamdecaf/cert-manage/pkg/whitelist"
 )
 
-func WhitelistForApp(app, whpath string) error {
+func WhitelistForApp(app, whpath string, opts *store.Options) error {
 	// load whitelist
 	wh, err := whitelist.FromFile(whpath)
 	if err != nil {
@@ -30,10 +31,13 @@ func WhitelistForApp(app, whpath string) error {
 	}
 
 	// diff
-	s, err := store.ForApp(app)
+	s, err := store.ForApp(app, opts)
 	if err != nil {
 		return err
 	}
+	if stores := namedStores(s); stores != nil {
+		return whitelistStores(app, s, stores, wh, opts)
+	}
 
 	// check for a backup
 	latest, err := s.GetLatestBackup()
@@ -45,7 +49,53 @@ func WhitelistForApp(app, whpath string) error {
 	}
 
 	// perform whitelist
-	err = s.Remove(wh)
+	err = transact(app, s, opts, func() error {
+		return s.Remove(wh)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Whitelist completed successfully")
+	return nil
+}
+
+// whitelistStores applies the whitelist to each store, printing how many
+// certificates each one trusted before and after.
+func whitelistStores(name string, s store.Store, stores []store.NamedStore, wh whitelist.Whitelist, opts *store.Options) error {
+	// check every store has a backup before changing any of them
+	err := eachStore(stores, func(ns store.NamedStore) error {
+		latest, err := ns.GetLatestBackup()
+		if err != nil {
+			return fmt.Errorf("can't get latest backup err=%v", err)
+		}
+		if latest == "" {
+			return errors.New("no backup found")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// every store is rolled back if removing from any of them fails
+	err = transact(name, s, opts, func() error {
+		return eachStore(stores, func(ns store.NamedStore) error {
+			before, err := countTrusted(ns)
+			if err != nil {
+				return err
+			}
+			if err := ns.Remove(wh); err != nil {
+				return err
+			}
+			after, err := countTrusted(ns)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %d -> %d certificates\n", ns.Name, before, after)
+			return nil
+		})
+	})
 	if err != nil {
 		return err
 	}
@@ -54,7 +104,7 @@ func WhitelistForApp(app, whpath string) error {
 	return nil
 }
 
-func WhitelistForPlatform(whpath string) error {
+func WhitelistForPlatform(whpath string, opts *store.Options) error {
 	// load whitelist
 	wh, err := whitelist.FromFile(whpath)
 	if err != nil {
@@ -62,7 +112,7 @@ func WhitelistForPlatform(whpath string) error {
 	}
 
 	// diff
-	s := store.Platform()
+	s := store.Platform(opts)
 
 	// check for backup
 	latest, err := s.GetLatestBackup()
@@ -74,7 +124,9 @@ func WhitelistForPlatform(whpath string) error {
 	}
 
 	// perform whitelist
-	err = s.Remove(wh)
+	err = transact(runtime.GOOS, s, opts, func() error {
+		return s.Remove(wh)
+	})
 	if err != nil {
 		return err
 	}
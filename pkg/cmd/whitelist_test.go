@@ -0,0 +1,140 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/store"
+)
+
+// readTree returns the contents (or link target) of everything under root
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	out := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			out[rel] = "-> " + target
+			return err
+		case info.IsDir():
+			out[rel] = fmt.Sprintf("dir %v", info.Mode())
+		default:
+			bs, err := ioutil.ReadFile(path)
+			out[rel] = string(bs)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestCmdWhitelist__root(t *testing.T) {
+	home, err := ioutil.TempDir("", "cert-manage-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	t.Setenv("HOME", home)
+
+	root, err := ioutil.TempDir("", "cert-manage-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A minimal debian image, with the home directory in it as well
+	for _, d := range []string{"etc/ssl/certs", "usr/share/ca-certificates/mozilla", "usr/local/share/ca-certificates", filepath.Join(home, ".config")} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"etc/os-release":           "ID=debian\nNAME=\"Debian GNU/Linux\"\nVERSION_ID=\"12\"\n",
+		"etc/ca-certificates.conf": "mozilla/first.crt\nmozilla/second.crt\n",
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, name := range []string{"first.crt", "second.crt"} {
+		if err := certutil.ToFile(filepath.Join(root, "usr/share/ca-certificates/mozilla", name), certs[i:i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/ssl/certs/ca-certificates.crt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	whpath := filepath.Join(home, "whitelist.json")
+	wh := fmt.Sprintf(`{"Fingerprints": ["%s"]}`, certutil.GetHexSHA256Fingerprint(*certs[0]))
+	if err := ioutil.WriteFile(whpath, []byte(wh), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &store.Options{Root: root}
+	before := readTree(t, root)
+	if err := BackupForPlatform("", opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := WhitelistForPlatform(whpath, opts); err != nil {
+		t.Fatal(err)
+	}
+	after := readTree(t, root)
+
+	// Only the trust store is changed, cert-manage's backups are kept on the host
+	for path, body := range after {
+		if before[path] == body {
+			continue
+		}
+		if path == "usr/share/ca-certificates/mozilla/second.crt" || strings.HasPrefix(path, "etc/ssl/certs/") {
+			continue
+		}
+		t.Errorf("unexpected change to %s", path)
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			t.Errorf("%s was removed", path)
+		}
+	}
+	if after["usr/share/ca-certificates/mozilla/second.crt"] != "" {
+		t.Error("second.crt wasn't removed from the store")
+	}
+	if infos, err := ioutil.ReadDir(filepath.Join(home, ".cert-manage", "roots")); err != nil || len(infos) != 1 {
+		t.Errorf("expected backups on the host: %d err=%v", len(infos), err)
+	}
+}
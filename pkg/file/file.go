@@ -15,6 +15,8 @@
 package file
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -22,6 +24,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -32,6 +35,9 @@ const (
 
 var (
 	windowsExecutableSuffixes = []string{".exe", ".cmd", ".bat"}
+
+	// maxSymlinks is how many links ResolveLinks will follow, the same as Linux's MAXSYMLINKS
+	maxSymlinks = 40
 )
 
 // Exists returns true if the give path represents a file or directory
@@ -170,9 +176,77 @@ func CopyFile(src, dst string) error {
 	return nil
 }
 
+// SHA256 returns the hex encoded SHA256 checksum of the file at `path`
+func SHA256(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // SudoCopyFile attempts to copy a file (and wraps CopyFile), but if required will escalate to
 // higher permissions in order to copy a file.
 func SudoCopyFile(src, dst string) error {
+	src, dst, err := checkCopyPaths(src, dst)
+	if err != nil {
+		return err
+	}
+
+	// Drop down to platform specific file copy (with elevated permissions)
+	return execCopy(src, dst)
+}
+
+// SudoReplaceFile copies src next to dst and renames the copy over dst, so dst is never
+// partially written. Like SudoCopyFile it escalates permissions if dst is owned by root.
+// The permissions of an existing dst are kept.
+func SudoReplaceFile(src, dst string) error {
+	src, dst, err := checkCopyPaths(src, dst)
+	if err != nil {
+		return err
+	}
+
+	var perm os.FileMode
+	if sdst, err := os.Stat(dst); err == nil {
+		perm = sdst.Mode().Perm()
+	} else if os.IsNotExist(err) {
+		ssrc, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		perm = ssrc.Mode().Perm()
+	} else {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf(".%s.cert-manage-%d", filepath.Base(dst), time.Now().UnixNano()))
+	return execReplace(src, tmp, dst, perm)
+}
+
+// replaceFile copies src to tmp and renames it over dst
+func replaceFile(src, tmp, dst string, perm os.FileMode) error {
+	if err := CopyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// checkCopyPaths cleans src and dst, and quits if they look weird or src is empty
+func checkCopyPaths(src, dst string) (string, string, error) {
 	// Clean both paths
 	src = filepath.Clean(src)
 	dst = filepath.Clean(dst)
@@ -180,17 +254,184 @@ func SudoCopyFile(src, dst string) error {
 	// quit if the paths look weird, or src doesn't exist
 	ssrc, err := os.Stat(src)
 	if err != nil {
-		return err
+		return "", "", err
 	}
 	if ssrc.Size() == 0 {
-		return fmt.Errorf("%q appears to be an empty file", src)
+		return "", "", fmt.Errorf("%q appears to be an empty file", src)
 	}
 	// Paths of just / or C:\
 	// Clean(p) returns '.' if p is blank
 	if utf8.RuneCountInString(src) <= 3 || utf8.RuneCountInString(dst) <= 3 {
-		return fmt.Errorf("either src=%q and dst=%q doesn't seem like a valid path", src, dst)
+		return "", "", fmt.Errorf("either src=%q and dst=%q doesn't seem like a valid path", src, dst)
 	}
+	return src, dst, nil
+}
 
-	// Drop down to platform specific file copy (with elevated permissions)
-	return execCopy(src, dst)
+// ResolveLinks follows the symlink(s) at `path` and returns the final path. Absolute
+// link targets are resolved under `root`, which allows reading through symlinks inside
+// an unpacked container image or chroot. An empty `root` is treated as "/".
+//
+// With a `root` every directory leading up to path is resolved as well, so links are
+// never followed outside of it. Relative links which climb above `root` are an error.
+// Paths outside of `root`, such as backups of its files, are resolved on the host
+// until a link leads into `root`.
+func ResolveLinks(root, path string) (string, error) {
+	if root != "" {
+		return resolveUnder(root, path, false)
+	}
+	for i := 0; i < maxSymlinks; i++ {
+		s, err := os.Lstat(path)
+		if err != nil {
+			return "", err
+		}
+		if s.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			path = target
+		} else {
+			path = filepath.Join(filepath.Dir(path), target)
+		}
+	}
+	return "", fmt.Errorf("too many levels of symbolic links at %s", path)
+}
+
+// ResolvePath is like ResolveLinks, but every directory leading up to path is
+// resolved as well. The result is the real location of path under root.
+func ResolvePath(root, path string) (string, error) {
+	if root == "" {
+		return filepath.EvalSymlinks(path)
+	}
+	return resolveUnder(root, path, false)
+}
+
+// JoinRoot returns `path` (as seen from inside of `root`) under `root`, with any
+// symlinks leading up to it resolved under `root`. Unlike ResolvePath the path
+// doesn't need to exist, so it's used for files which will be written. An empty
+// `root` returns `path`.
+func JoinRoot(root, path string) (string, error) {
+	if root == "" {
+		return path, nil
+	}
+	return resolveUnder(root, filepath.Join(root, filepath.Clean(string(filepath.Separator)+path)), true)
+}
+
+// resolveUnder resolves each part of path, which must be under root, following links
+// under root. When missing is true the parts after one which doesn't exist are
+// appended as-is, otherwise the error is returned.
+func resolveUnder(root, path string, missing bool) (string, error) {
+	root = filepath.Clean(root)
+	rel, err := filepath.Rel(root, path)
+	if err != nil || !withinRoot(rel) {
+		if filepath.IsAbs(path) {
+			return resolveOutside(root, path, missing)
+		}
+		return "", fmt.Errorf("%s is outside of %s", path, root)
+	}
+	var parts []string
+	if rel != "." {
+		parts = strings.Split(rel, string(filepath.Separator))
+	}
+
+	cur, links := root, 0
+	for len(parts) > 0 {
+		next := filepath.Join(cur, parts[0])
+		parts = parts[1:]
+
+		s, err := os.Lstat(next)
+		if err != nil {
+			if missing && os.IsNotExist(err) {
+				return filepath.Join(append([]string{next}, parts...)...), nil
+			}
+			return "", err
+		}
+		if s.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links at %s", path)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			target = filepath.Join(root, target)
+		} else {
+			target = filepath.Join(cur, target)
+		}
+		rel, err := filepath.Rel(root, target)
+		if err != nil || !withinRoot(rel) {
+			return "", fmt.Errorf("symlink %s points outside of %s", next, root)
+		}
+
+		// resolve the link's target from root, followed by the rest of path
+		cur = root
+		if rel != "." {
+			parts = append(strings.Split(rel, string(filepath.Separator)), parts...)
+		}
+	}
+	return cur, nil
+}
+
+// resolveOutside resolves path, which isn't under root (e.g. a backup of root's files
+// kept on the host), following links as usual until one leads into root. Absolute
+// link targets are seen from inside of root, so they're resolved under it.
+func resolveOutside(root, path string, missing bool) (string, error) {
+	path = filepath.Clean(path)
+	vol := filepath.VolumeName(path)
+	cur := vol + string(filepath.Separator)
+	parts := strings.Split(strings.TrimPrefix(path[len(vol):], string(filepath.Separator)), string(filepath.Separator))
+
+	for links := 0; len(parts) > 0; {
+		if parts[0] == "" {
+			parts = parts[1:]
+			continue
+		}
+		next := filepath.Join(cur, parts[0])
+		parts = parts[1:]
+
+		s, err := os.Lstat(next)
+		if err != nil {
+			if missing && os.IsNotExist(err) {
+				return filepath.Join(append([]string{next}, parts...)...), nil
+			}
+			return "", err
+		}
+		if s.Mode()&os.ModeSymlink == 0 {
+			cur = next
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links at %s", path)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			return resolveUnder(root, filepath.Join(append([]string{root, target}, parts...)...), missing)
+		}
+		target = filepath.Join(cur, target)
+		if rel, err := filepath.Rel(root, target); err == nil && withinRoot(rel) {
+			return resolveUnder(root, filepath.Join(append([]string{target}, parts...)...), missing)
+		}
+
+		// resolve the link's target, followed by the rest of path
+		cur = vol + string(filepath.Separator)
+		parts = append(strings.Split(strings.TrimPrefix(target[len(filepath.VolumeName(target)):], string(filepath.Separator)), string(filepath.Separator)), parts...)
+	}
+	return cur, nil
+}
+
+// withinRoot returns false if the relative path rel climbs above where it's relative to
+func withinRoot(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
@@ -18,6 +18,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -203,6 +204,41 @@ func TestFile__CopyFile(t *testing.T) {
 	}
 }
 
+func TestFile__SudoReplaceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-file-SudoReplaceFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "cacerts")
+	if err := ioutil.WriteFile(src, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SudoReplaceFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if bs, _ := ioutil.ReadFile(dst); string(bs) != "new" {
+		t.Errorf("got %q", bs)
+	}
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0644 {
+			t.Errorf("expected dst to keep its permissions: %v", fi.Mode())
+		}
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil || len(infos) != 2 {
+		t.Errorf("expected nothing staged: %d err=%v", len(infos), err)
+	}
+}
+
 func TestFile__copyBrokenSymlink(t *testing.T) {
 	dir, err := ioutil.TempDir("", "cert-manage-file-copyBrokenSymlink")
 	if err != nil {
@@ -230,3 +266,187 @@ func TestFile__copyBrokenSymlink(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestFile__ResolveLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require extra permissions on windows")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-file-ResolveLinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// root/etc/bundle.crt -> /extracted/bundle.pem (absolute, inside root)
+	// root/extracted/bundle.pem -> real.pem (relative)
+	for _, d := range []string{"etc", "extracted"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	real := filepath.Join(root, "extracted", "real.pem")
+	if err := ioutil.WriteFile(real, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real.pem", filepath.Join(root, "extracted", "bundle.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/extracted/bundle.pem", filepath.Join(root, "etc", "bundle.crt")); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := ResolveLinks(root, filepath.Join(root, "etc", "bundle.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != real {
+		t.Errorf("got %s, expected %s", path, real)
+	}
+
+	// regular files are returned as-is
+	path, err = ResolveLinks(root, real)
+	if err != nil || path != real {
+		t.Errorf("got %s err=%v", path, err)
+	}
+
+	// broken links error
+	if err := os.Symlink("/missing", filepath.Join(root, "broken")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolveLinks(root, filepath.Join(root, "broken")); !os.IsNotExist(err) {
+		t.Errorf("expected IsNotExist, got %v", err)
+	}
+
+	// links are never followed outside of root
+	if err := os.Symlink("../../..", filepath.Join(root, "etc", "up")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolveLinks(root, filepath.Join(root, "etc", "up", "bundle.crt")); err == nil {
+		t.Error("expected error")
+	}
+	if err := os.Symlink("/", filepath.Join(root, "host")); err != nil {
+		t.Fatal(err)
+	}
+	path, err = ResolveLinks(root, filepath.Join(root, "host", "extracted", "real.pem"))
+	if err != nil || path != real {
+		t.Errorf("got %s err=%v", path, err)
+	}
+
+	// paths outside of root (e.g. backups) have absolute links resolved under root
+	backup, err := ioutil.TempDir("", "cert-manage-file-ResolveLinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(backup)
+	if err := os.Symlink("/etc/bundle.crt", filepath.Join(backup, "bundle.crt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bundle.crt", filepath.Join(backup, "copy.crt")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bundle.crt", "copy.crt"} {
+		path, err = ResolveLinks(root, filepath.Join(backup, name))
+		if err != nil || path != real {
+			t.Errorf("%s: got %s err=%v", name, path, err)
+		}
+	}
+	if err := os.Symlink("/etc/up/bundle.crt", filepath.Join(backup, "up.crt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolveLinks(root, filepath.Join(backup, "up.crt")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestFile__JoinRoot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require extra permissions on windows")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-file-JoinRoot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// root/etc/ssl -> /etc/pki/tls (absolute), which is under root and not the host
+	if err := os.MkdirAll(filepath.Join(root, "etc", "pki", "tls"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/pki/tls", filepath.Join(root, "etc", "ssl")); err != nil {
+		t.Fatal(err)
+	}
+	path, err := JoinRoot(root, "/etc/ssl/certs/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(root, "etc", "pki", "tls", "certs", "ca.pem"); path != expected {
+		t.Errorf("got %s, expected %s", path, expected)
+	}
+
+	// relative links above root are an error
+	if err := os.Symlink("../../../../tmp", filepath.Join(root, "etc", "tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if path, err := JoinRoot(root, "/etc/tmp/ca.pem"); err == nil {
+		t.Errorf("expected error, got %s", path)
+	}
+
+	// no root is the path as-is
+	if path, err := JoinRoot("", "/etc/ssl"); err != nil || path != "/etc/ssl" {
+		t.Errorf("got %s err=%v", path, err)
+	}
+}
+
+func TestFile__ResolvePath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require extra permissions on windows")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-file-ResolvePath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// root/jvm/default -> /jvm/java-17 (absolute, inside root)
+	real := filepath.Join(root, "jvm", "java-17", "lib", "cacerts")
+	if err := os.MkdirAll(filepath.Dir(real), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(real, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/jvm/java-17", filepath.Join(root, "jvm", "default")); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := ResolvePath(root, filepath.Join(root, "jvm", "default", "lib", "cacerts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != real {
+		t.Errorf("got %s, expected %s", path, real)
+	}
+}
+
+func TestFile__SHA256(t *testing.T) {
+	fd, err := ioutil.TempFile("", "cert-manage-file-SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fd.Name())
+	if _, err := fd.WriteString("abc"); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	sum, err := SHA256(fd.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("got %s", sum)
+	}
+}
@@ -42,6 +42,37 @@ func execCopy(src, dst string) error {
 	return CopyFile(src, dst)
 }
 
+// execReplace stages src at tmp and renames it over dst, escalating like execCopy
+// if dst is owned by root.
+func execReplace(src, tmp, dst string, perm os.FileMode) error {
+	sdst, err := os.Stat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if sdst == nil || sdst.Sys().(*syscall.Stat_t).Uid != 0 || os.Getuid() == 0 {
+		return replaceFile(src, tmp, dst, perm)
+	}
+
+	cmds := [][]string{
+		{"cp", src, tmp},
+		{"chmod", fmt.Sprintf("%o", perm), tmp},
+		{"mv", "-f", tmp, dst},
+	}
+	for i := range cmds {
+		var stderr bytes.Buffer
+		cmd := exec.Command("sudo", cmds[i]...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			exec.Command("sudo", "rm", "-f", tmp).Run()
+			if stderr.Len() > 0 {
+				return fmt.Errorf("error replacing %q with %q, err=%v, stderr=%s", dst, src, err, stderr.String())
+			}
+			return fmt.Errorf("error replacing %q with %q, err=%v", dst, src, err)
+		}
+	}
+	return nil
+}
+
 // execSudoCopy drops down to a shell in order to attempt a file copy.
 // This function assumes the paths are valid (and checked) by it's caller
 func execSudoCopy(src, dst string) error {
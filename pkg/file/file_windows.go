@@ -17,6 +17,12 @@
 
 package file
 
+import "os"
+
 func execCopy(src, dst string) error {
 	return CopyFile(src, dst)
 }
+
+func execReplace(src, tmp, dst string, perm os.FileMode) error {
+	return replaceFile(src, tmp, dst, perm)
+}
@@ -0,0 +1,297 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keystore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+// JKS is a big-endian stream written by Java's DataOutputStream, see
+// sun.security.provider.JavaKeyStore in the JDK.
+//
+//	magic (0xFEEDFEED), version, count
+//	entries, each: tag, alias, timestamp (ms) and then
+//	  tag=1 (private key): key, chain length, chain of (type, certificate)
+//	  tag=2 (trusted cert): type, certificate
+//	SHA1(password || "Mighty Aphrodite" || everything above)
+var (
+	jksMagic   = []byte{0xfe, 0xed, 0xfe, 0xed}
+	jceksMagic = []byte{0xce, 0xce, 0xce, 0xce}
+
+	jksWhitener = []byte("Mighty Aphrodite")
+)
+
+const (
+	jksVersion = 2
+
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+
+	jksCertType = "X.509"
+)
+
+// jksEntry is a JKS entry we don't modify, body is everything after the timestamp
+type jksEntry struct {
+	tag     uint32
+	alias   string
+	created time.Time
+	body    []byte
+}
+
+func decodeJKS(bs []byte, password string) (*Keystore, error) {
+	if len(bs) < 12+sha1.Size {
+		return nil, errors.New("keystore: JKS file is truncated")
+	}
+	data, digest := bs[:len(bs)-sha1.Size], bs[len(bs)-sha1.Size:]
+	if password != "" {
+		sum := jksDigest(password, data)
+		if subtle.ConstantTimeCompare(sum, digest) != 1 {
+			return nil, ErrIncorrectPassword
+		}
+	}
+
+	r := &jksReader{buf: data[4:]}
+	version := r.uint32()
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("%v: JKS version %d", ErrUnsupported, version)
+	}
+	count := r.uint32()
+
+	ks := New(JKS)
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		tag := r.uint32()
+		alias := r.utf()
+		created := time.Unix(0, r.int64()*int64(time.Millisecond))
+
+		switch tag {
+		case jksTrustedCertTag:
+			der := r.cert(version)
+			if r.err != nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, fmt.Errorf("keystore: unable to parse certificate %q: %v", alias, err)
+			}
+			ks.entries = append(ks.entries, &Entry{
+				Alias:       alias,
+				Created:     created,
+				Certificate: cert,
+			})
+
+		case jksPrivateKeyTag:
+			start := r.off
+			r.bytes() // encrypted key
+			chain := r.uint32()
+			for j := uint32(0); j < chain && r.err == nil; j++ {
+				r.cert(version)
+			}
+			if r.err != nil {
+				break
+			}
+			ks.jks = append(ks.jks, jksEntry{
+				tag:     tag,
+				alias:   alias,
+				created: created,
+				body:    append([]byte(nil), r.buf[start:r.off]...),
+			})
+
+		default:
+			return nil, fmt.Errorf("%v: JKS entry tag %d", ErrUnsupported, tag)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("keystore: invalid JKS file: %v", r.err)
+	}
+	return ks, nil
+}
+
+func (ks *Keystore) encodeJKS(password string) ([]byte, error) {
+	w := &jksWriter{}
+	w.buf.Write(jksMagic)
+	w.uint32(jksVersion)
+	w.uint32(uint32(len(ks.jks) + len(ks.entries)))
+
+	for i := range ks.entries {
+		w.uint32(jksTrustedCertTag)
+		w.utf(ks.entries[i].Alias)
+		w.int64(ks.entries[i].Created.UnixNano() / int64(time.Millisecond))
+		w.utf(jksCertType)
+		w.bytes(ks.entries[i].Certificate.Raw)
+	}
+	for i := range ks.jks {
+		w.uint32(ks.jks[i].tag)
+		w.utf(ks.jks[i].alias)
+		w.int64(ks.jks[i].created.UnixNano() / int64(time.Millisecond))
+		w.buf.Write(ks.jks[i].body)
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	w.buf.Write(jksDigest(password, w.buf.Bytes()))
+	return w.buf.Bytes(), nil
+}
+
+// jksDigest computes the keyed integrity hash, where the password's UTF-16
+// code units are written big-endian.
+func jksDigest(password string, data []byte) []byte {
+	h := sha1.New()
+	for _, c := range utf16.Encode([]rune(password)) {
+		h.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	h.Write(jksWhitener)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+type jksReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *jksReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf)-r.off < n {
+		r.err = errors.New("unexpected end of data")
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *jksReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *jksReader) int64() int64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (r *jksReader) bytes() []byte {
+	return r.next(int(r.uint32()))
+}
+
+func (r *jksReader) utf() string {
+	b := r.next(2)
+	if b == nil {
+		return ""
+	}
+	s, err := decodeModifiedUTF8(r.next(int(binary.BigEndian.Uint16(b))))
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return s
+}
+
+// cert reads a certificate, version 1 keystores don't include the type
+func (r *jksReader) cert(version uint32) []byte {
+	if version == 2 {
+		if typ := r.utf(); typ != jksCertType && r.err == nil {
+			r.err = fmt.Errorf("%v: certificate type %q", ErrUnsupported, typ)
+		}
+	}
+	return r.bytes()
+}
+
+type jksWriter struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (w *jksWriter) uint32(n uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	w.buf.Write(b[:])
+}
+
+func (w *jksWriter) int64(n int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(n))
+	w.buf.Write(b[:])
+}
+
+func (w *jksWriter) bytes(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.buf.Write(b)
+}
+
+func (w *jksWriter) utf(s string) {
+	b := encodeModifiedUTF8(s)
+	if len(b) > 0xffff {
+		w.err = fmt.Errorf("keystore: string %q is too long", s)
+		return
+	}
+	w.buf.Write([]byte{byte(len(b) >> 8), byte(len(b))})
+	w.buf.Write(b)
+}
+
+// encodeModifiedUTF8 encodes s like Java's DataOutput.writeUTF, where NUL is
+// written as two bytes and supplementary characters as surrogate pairs.
+func encodeModifiedUTF8(s string) []byte {
+	var out []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		switch {
+		case c != 0 && c < 0x80:
+			out = append(out, byte(c))
+		case c < 0x800:
+			out = append(out, byte(0xc0|c>>6), byte(0x80|c&0x3f))
+		default:
+			out = append(out, byte(0xe0|c>>12), byte(0x80|(c>>6)&0x3f), byte(0x80|c&0x3f))
+		}
+	}
+	return out
+}
+
+// decodeModifiedUTF8 is the inverse of encodeModifiedUTF8
+func decodeModifiedUTF8(b []byte) (string, error) {
+	units := make([]uint16, 0, len(b))
+	for i := 0; i < len(b); {
+		switch {
+		case b[i]&0x80 == 0:
+			units = append(units, uint16(b[i]))
+			i++
+		case b[i]&0xe0 == 0xc0 && i+1 < len(b):
+			units = append(units, uint16(b[i]&0x1f)<<6|uint16(b[i+1]&0x3f))
+			i += 2
+		case b[i]&0xf0 == 0xe0 && i+2 < len(b):
+			units = append(units, uint16(b[i]&0x0f)<<12|uint16(b[i+1]&0x3f)<<6|uint16(b[i+2]&0x3f))
+			i += 3
+		default:
+			return "", fmt.Errorf("invalid modified UTF-8 at byte %d", i)
+		}
+	}
+	return string(utf16.Decode(units)), nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keystore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+)
+
+func TestKeystoreJKS__decode(t *testing.T) {
+	bs, err := ioutil.ReadFile("../../testdata/truststore.jks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(bs, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+
+	// An empty password skips the integrity check
+	for _, pass := range []string{"", "changeit"} {
+		ks, err := Decode(bs, pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ks.Format != JKS {
+			t.Errorf("got format %v", ks.Format)
+		}
+		entries := ks.Entries()
+		if len(entries) != 1 {
+			t.Fatalf("got %d entries", len(entries))
+		}
+		if entries[0].Alias != "example" {
+			t.Errorf("got alias %q", entries[0].Alias)
+		}
+		if !entries[0].Created.Equal(time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("got created %v", entries[0].Created)
+		}
+		if len(ks.jks) != 1 || ks.jks[0].alias != "mykey" {
+			t.Errorf("expected private key entry to be kept, got %#v", ks.jks)
+		}
+	}
+}
+
+func TestKeystoreJKS__roundTrip(t *testing.T) {
+	bs, err := ioutil.ReadFile("../../testdata/truststore.jks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := Decode(bs, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-encoding without changes is byte for byte identical
+	out, err := ks.Encode("changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bs, out) {
+		t.Error("re-encoded keystore differs")
+	}
+
+	certs, err := certutil.FromFile("../../testdata/lots.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Add("MyKey", certs[0]); err == nil {
+		t.Error("expected duplicate alias error")
+	}
+	if err := ks.Add("lots", certs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !ks.Delete("EXAMPLE") {
+		t.Error("expected example to be deleted")
+	}
+
+	out, err = ks.Encode("new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err = Decode(out, "new password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := ks.Entries()
+	if len(entries) != 1 || entries[0].Alias != "lots" || !ks.Contains(certs[0]) {
+		t.Errorf("unexpected entries: %#v", entries)
+	}
+	if len(ks.jks) != 1 {
+		t.Errorf("expected private key entry to be kept")
+	}
+}
+
+func TestKeystoreJKS__modifiedUTF8(t *testing.T) {
+	cases := map[string][]byte{
+		"abc":    []byte("abc"),
+		"a\x00b": {'a', 0xc0, 0x80, 'b'},
+		"é":      {0xc3, 0xa9},
+		"😀":      {0xed, 0xa0, 0xbd, 0xed, 0xb8, 0x80},
+	}
+	for s, expected := range cases {
+		b := encodeModifiedUTF8(s)
+		if !bytes.Equal(b, expected) {
+			t.Errorf("%q: got %x, expected %x", s, b, expected)
+		}
+		out, err := decodeModifiedUTF8(b)
+		if err != nil || out != s {
+			t.Errorf("%q: got %q err=%v", s, out, err)
+		}
+	}
+}
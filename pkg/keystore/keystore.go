@@ -0,0 +1,185 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keystore reads and writes Java keystores (JKS and PKCS12) such as
+// the `cacerts` truststore shipped with each JDK.
+//
+// Only trusted certificate entries can be inspected or modified. Other entries
+// (e.g. private keys and their certificate chains) are kept as-is when the
+// keystore is encoded again.
+package keystore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+)
+
+// Format is the on-disk encoding of a keystore
+type Format int
+
+const (
+	JKS Format = iota
+	PKCS12
+)
+
+func (f Format) String() string {
+	switch f {
+	case JKS:
+		return "JKS"
+	case PKCS12:
+		return "PKCS12"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+var (
+	// ErrIncorrectPassword is returned when a keystore's integrity check fails
+	ErrIncorrectPassword = errors.New("keystore password was incorrect")
+
+	// ErrUnsupported is returned for keystores (or parts of them) which can't be read
+	ErrUnsupported = errors.New("unsupported keystore")
+)
+
+// Entry is a trusted certificate held in a keystore
+type Entry struct {
+	Alias       string
+	Created     time.Time
+	Certificate *x509.Certificate
+}
+
+// Keystore is a decoded Java keystore
+type Keystore struct {
+	Format Format
+
+	entries []*Entry
+
+	jks    []jksEntry    // non-certificate JKS entries
+	pkcs12 pkcs12Details // non-certificate PKCS12 bags and MAC settings
+}
+
+// New returns an empty keystore which will be encoded as `format`
+func New(format Format) *Keystore {
+	return &Keystore{
+		Format: format,
+	}
+}
+
+// Decode reads a JKS or PKCS12 keystore. If password is non-empty the keystore's
+// integrity check is verified with it, PKCS12 keystores with encrypted
+// certificates also require the password.
+func Decode(bs []byte, password string) (*Keystore, error) {
+	if bytes.HasPrefix(bs, jksMagic) {
+		return decodeJKS(bs, password)
+	}
+	if bytes.HasPrefix(bs, jceksMagic) {
+		return nil, fmt.Errorf("%v: JCEKS", ErrUnsupported)
+	}
+	if len(bs) > 0 && bs[0] == 0x30 { // DER SEQUENCE
+		return decodePKCS12(bs, password)
+	}
+	return nil, fmt.Errorf("%v: unknown format", ErrUnsupported)
+}
+
+// Encode writes the keystore back out in its Format, protecting it with password.
+func (ks *Keystore) Encode(password string) ([]byte, error) {
+	switch ks.Format {
+	case JKS:
+		return ks.encodeJKS(password)
+	case PKCS12:
+		return ks.encodePKCS12(password)
+	}
+	return nil, fmt.Errorf("%v: %v", ErrUnsupported, ks.Format)
+}
+
+// Entries returns the trusted certificate entries in the keystore
+func (ks *Keystore) Entries() []*Entry {
+	out := make([]*Entry, len(ks.entries))
+	copy(out, ks.entries)
+	return out
+}
+
+// Certificates returns each trusted certificate in the keystore
+func (ks *Keystore) Certificates() []*x509.Certificate {
+	out := make([]*x509.Certificate, len(ks.entries))
+	for i := range ks.entries {
+		out[i] = ks.entries[i].Certificate
+	}
+	return out
+}
+
+// Contains returns true if the certificate is already trusted by the keystore
+func (ks *Keystore) Contains(cert *x509.Certificate) bool {
+	fp := certutil.GetHexSHA256Fingerprint(*cert)
+	for i := range ks.entries {
+		if certutil.GetHexSHA256Fingerprint(*ks.entries[i].Certificate) == fp {
+			return true
+		}
+	}
+	return false
+}
+
+// Add inserts a trusted certificate entry. Aliases are case-insensitive and
+// must be unique within the keystore.
+func (ks *Keystore) Add(alias string, cert *x509.Certificate) error {
+	if alias == "" || cert == nil {
+		return errors.New("keystore: alias and certificate are required")
+	}
+	if ks.HasAlias(alias) {
+		return fmt.Errorf("keystore: alias %q already exists", alias)
+	}
+	ks.entries = append(ks.entries, &Entry{
+		Alias:       alias,
+		Created:     time.Now(),
+		Certificate: cert,
+	})
+	return nil
+}
+
+// Delete removes the trusted certificate entry with the given alias, returning
+// true if an entry was removed.
+func (ks *Keystore) Delete(alias string) bool {
+	for i := range ks.entries {
+		if strings.EqualFold(ks.entries[i].Alias, alias) {
+			ks.entries = append(ks.entries[:i], ks.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// HasAlias returns true if any entry (including private keys) uses alias
+func (ks *Keystore) HasAlias(alias string) bool {
+	for i := range ks.entries {
+		if strings.EqualFold(ks.entries[i].Alias, alias) {
+			return true
+		}
+	}
+	for i := range ks.jks {
+		if strings.EqualFold(ks.jks[i].alias, alias) {
+			return true
+		}
+	}
+	for i := range ks.pkcs12.aliases {
+		if strings.EqualFold(ks.pkcs12.aliases[i], alias) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Password based encryption and MAC keys for PKCS12, see RFC 7292 appendix B
+// and RFC 8018 (PBES2).
+var (
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	// macHashes are the digests supported for the PKCS12 MAC
+	macHashes = map[string]func() hash.Hash{
+		oidSHA1.String():   sha1.New,
+		oidSHA256.String(): sha256.New,
+		oidSHA384.String(): sha512.New384,
+		oidSHA512.String(): sha512.New,
+	}
+
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd40BitRC2CBC      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBES2                         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2                        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	pbkdf2PRFs = map[string]func() hash.Hash{
+		asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}.String():  sha1.New,
+		asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}.String():  sha256.New,
+		asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}.String(): sha512.New384,
+		asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}.String(): sha512.New,
+	}
+
+	// AES-CBC key sizes by OID
+	aesCBCKeySizes = map[string]int{
+		asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}.String():  16,
+		asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}.String(): 24,
+		asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}.String(): 32,
+	}
+)
+
+// PKCS12 KDF purposes
+const (
+	pkcs12KeyID = 1
+	pkcs12IVID  = 2
+	pkcs12MacID = 3
+)
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       asn1.RawValue
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptContent returns the decrypted SafeContents of an encryptedData ContentInfo
+func decryptContent(info contentInfo, password string) ([]byte, error) {
+	var ed encryptedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &ed); err != nil {
+		return nil, fmt.Errorf("keystore: invalid PKCS12 encryptedData: %v", err)
+	}
+	if password == "" {
+		return nil, errors.New("keystore: password required to read encrypted certificates")
+	}
+	alg := ed.EncryptedContentInfo.ContentEncryptionAlgorithm
+
+	var block cipher.Block
+	var iv []byte
+	switch {
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC):
+		var params pbeParams
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		pass := bmpPassword(password)
+		key := pkcs12KDF(sha1.New, pass, params.Salt, pkcs12KeyID, params.Iterations, 24)
+		iv = pkcs12KDF(sha1.New, pass, params.Salt, pkcs12IVID, params.Iterations, 8)
+		var err error
+		if block, err = des.NewTripleDESCipher(key); err != nil {
+			return nil, err
+		}
+
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
+		var params pbeParams
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		pass := bmpPassword(password)
+		key := pkcs12KDF(sha1.New, pass, params.Salt, pkcs12KeyID, params.Iterations, 5)
+		iv = pkcs12KDF(sha1.New, pass, params.Salt, pkcs12IVID, params.Iterations, 8)
+		var err error
+		if block, err = newRC2Cipher(key, 40); err != nil {
+			return nil, err
+		}
+
+	case alg.Algorithm.Equal(oidPBES2):
+		var err error
+		if block, iv, err = pbes2Cipher(alg.Parameters.FullBytes, password); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("%v: PKCS12 encryption algorithm %v", ErrUnsupported, alg.Algorithm)
+	}
+
+	data := append([]byte(nil), ed.EncryptedContentInfo.EncryptedContent...)
+	if len(data) == 0 || len(data)%block.BlockSize() != 0 || len(iv) != block.BlockSize() {
+		return nil, errors.New("keystore: invalid PKCS12 encrypted content")
+	}
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	// Strip PKCS#7 padding, bad padding almost always means a wrong password
+	n := int(data[len(data)-1])
+	if n == 0 || n > block.BlockSize() {
+		return nil, ErrIncorrectPassword
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, ErrIncorrectPassword
+		}
+	}
+	return data[:len(data)-n], nil
+}
+
+// pbes2Cipher returns the AES-CBC cipher and IV from PBES2 parameters
+func pbes2Cipher(raw []byte, password string) (cipher.Block, []byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(raw, &params); err != nil {
+		return nil, nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, fmt.Errorf("%v: PBES2 key derivation %v", ErrUnsupported, params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, nil, err
+	}
+	prf := sha1.New // RFC 8018 default
+	if kdf.PRF.Algorithm != nil {
+		var ok bool
+		if prf, ok = pbkdf2PRFs[kdf.PRF.Algorithm.String()]; !ok {
+			return nil, nil, fmt.Errorf("%v: PBKDF2 PRF %v", ErrUnsupported, kdf.PRF.Algorithm)
+		}
+	}
+	size, ok := aesCBCKeySizes[params.EncryptionScheme.Algorithm.String()]
+	if !ok {
+		return nil, nil, fmt.Errorf("%v: PBES2 encryption scheme %v", ErrUnsupported, params.EncryptionScheme.Algorithm)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, err
+	}
+
+	key := pbkdf2.Key([]byte(password), kdf.Salt.Bytes, kdf.Iterations, size, prf)
+	block, err := aes.NewCipher(key)
+	return block, iv, err
+}
+
+// pkcs12KDF derives `size` bytes of key material following RFC 7292 appendix B.2
+func pkcs12KDF(h func() hash.Hash, password, salt []byte, id byte, iterations, size int) []byte {
+	v := h().BlockSize()
+
+	D := make([]byte, v)
+	for i := range D {
+		D[i] = id
+	}
+	I := append(fill(salt, v), fill(password, v)...)
+
+	var out []byte
+	for len(out) < size {
+		A := append(append([]byte(nil), D...), I...)
+		for i := 0; i < iterations; i++ {
+			d := h()
+			d.Write(A)
+			A = d.Sum(nil)
+		}
+		out = append(out, A...)
+
+		// I_j = (I_j + B + 1) mod 2^(8v) for each v-byte block of I
+		B := fill(A, v)[:v]
+		for j := 0; j < len(I); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(I[j+k]) + int(B[k]) + carry
+				I[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// fill repeats b to a multiple of v bytes, empty input stays empty
+func fill(b []byte, v int) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	n := v * ((len(b) + v - 1) / v)
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = b[i%len(b)]
+	}
+	return out
+}
@@ -0,0 +1,426 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keystore
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+)
+
+// PKCS12 (RFC 7292) keystores as written by keytool, which is also the format
+// of `cacerts` since JDK 18.
+//
+// Trusted certificates are certBag's carrying Oracle's trustedKeyUsage attribute
+// (or any certBag not paired with a key). When encoding, every certificate is
+// written into one unencrypted SafeContents (like the JDK's cacerts) and other
+// bags are copied as-is.
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidCertBag   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidSecretBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 5}
+
+	oidCertTypeX509Certificate = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidFriendlyName    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKey  = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+)
+
+const (
+	pkcs12Version = 3
+
+	defaultMacIterations = 10000
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+// pkcs12Details holds what's needed to re-encode a PKCS12 keystore
+type pkcs12Details struct {
+	bags    [][]byte // DER of each bag which isn't a trusted certificate
+	aliases []string // friendlyName's of the above bags
+
+	// sealed is set when unencrypted keys were read from encrypted content,
+	// which we refuse to write back out in the clear.
+	sealed bool
+
+	macDigest  asn1.ObjectIdentifier
+	iterations int
+
+	// noMac is set when the file had no MAC (e.g. a password-less JDK 18+ cacerts),
+	// so one isn't added when it's written back.
+	noMac bool
+}
+
+func decodePKCS12(bs []byte, password string) (*Keystore, error) {
+	var pfx pfxPdu
+	rest, err := asn1.Unmarshal(bs, &pfx)
+	if err != nil {
+		return nil, fmt.Errorf("keystore: invalid PKCS12 file: %v", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("keystore: trailing data after PKCS12 file")
+	}
+	if pfx.Version != pkcs12Version {
+		return nil, fmt.Errorf("%v: PKCS12 version %d", ErrUnsupported, pfx.Version)
+	}
+	authSafe, err := dataContent(pfx.AuthSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	ks := New(PKCS12)
+	ks.pkcs12.noMac = pfx.MacData.Mac.Algorithm.Algorithm == nil
+	if !ks.pkcs12.noMac {
+		ks.pkcs12.macDigest = pfx.MacData.Mac.Algorithm.Algorithm
+		ks.pkcs12.iterations = pfx.MacData.Iterations
+		if password != "" {
+			if err := verifyMac(&pfx.MacData, authSafe, password); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var infos []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &infos); err != nil {
+		return nil, fmt.Errorf("keystore: invalid PKCS12 AuthenticatedSafe: %v", err)
+	}
+	for i := range infos {
+		var contents []byte
+		encrypted := false
+		switch {
+		case infos[i].ContentType.Equal(oidDataContentType):
+			contents, err = dataContent(infos[i])
+		case infos[i].ContentType.Equal(oidEncryptedDataContentType):
+			contents, err = decryptContent(infos[i], password)
+			encrypted = true
+		default:
+			err = fmt.Errorf("%v: PKCS12 content type %v", ErrUnsupported, infos[i].ContentType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := ks.readSafeContents(contents, encrypted); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+func (ks *Keystore) readSafeContents(contents []byte, encrypted bool) error {
+	var bags []asn1.RawValue
+	if _, err := asn1.Unmarshal(contents, &bags); err != nil {
+		return fmt.Errorf("keystore: invalid PKCS12 SafeContents: %v", err)
+	}
+	for i := range bags {
+		var bag safeBag
+		if _, err := asn1.Unmarshal(bags[i].FullBytes, &bag); err != nil {
+			return fmt.Errorf("keystore: invalid PKCS12 SafeBag: %v", err)
+		}
+		alias, keyID, trusted := readAttributes(bag.Attributes)
+
+		if bag.ID.Equal(oidCertBag) && (trusted || !keyID) {
+			var cb certBag
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+				return fmt.Errorf("keystore: invalid PKCS12 certBag: %v", err)
+			}
+			if cb.ID.Equal(oidCertTypeX509Certificate) {
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return fmt.Errorf("keystore: unable to parse certificate %q: %v", alias, err)
+				}
+				if alias == "" {
+					alias = certutil.GetHexSHA256Fingerprint(*cert)
+				}
+				ks.entries = append(ks.entries, &Entry{
+					Alias:       alias,
+					Certificate: cert,
+				})
+				continue
+			}
+		}
+
+		if encrypted && (bag.ID.Equal(oidKeyBag) || bag.ID.Equal(oidSecretBag)) {
+			ks.pkcs12.sealed = true
+		}
+		ks.pkcs12.bags = append(ks.pkcs12.bags, bags[i].FullBytes)
+		if alias != "" {
+			ks.pkcs12.aliases = append(ks.pkcs12.aliases, alias)
+		}
+	}
+	return nil
+}
+
+// readAttributes returns the friendlyName and if localKeyId or Oracle's
+// trustedKeyUsage attributes are present.
+func readAttributes(attrs []pkcs12Attribute) (alias string, keyID bool, trusted bool) {
+	for i := range attrs {
+		switch {
+		case attrs[i].ID.Equal(oidFriendlyName):
+			var v asn1.RawValue
+			if _, err := asn1.Unmarshal(attrs[i].Value.Bytes, &v); err == nil {
+				alias = decodeBMPString(v.Bytes)
+			}
+		case attrs[i].ID.Equal(oidLocalKeyID):
+			keyID = true
+		case attrs[i].ID.Equal(oidTrustedKeyUsage):
+			trusted = true
+		}
+	}
+	return alias, keyID, trusted
+}
+
+func (ks *Keystore) encodePKCS12(password string) ([]byte, error) {
+	if ks.pkcs12.sealed {
+		return nil, fmt.Errorf("%v: PKCS12 keystore with encrypted keys can't be rewritten", ErrUnsupported)
+	}
+
+	bags := make([]asn1.RawValue, 0, len(ks.pkcs12.bags)+len(ks.entries))
+	for i := range ks.pkcs12.bags {
+		bags = append(bags, asn1.RawValue{FullBytes: ks.pkcs12.bags[i]})
+	}
+	for i := range ks.entries {
+		bag, err := trustedCertBag(ks.entries[i])
+		if err != nil {
+			return nil, err
+		}
+		bags = append(bags, asn1.RawValue{FullBytes: bag})
+	}
+	contents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	info, err := dataContentInfo(contents)
+	if err != nil {
+		return nil, err
+	}
+	authSafe, err := asn1.Marshal([]contentInfo{info})
+	if err != nil {
+		return nil, err
+	}
+
+	pfx := pfxPdu{
+		Version: pkcs12Version,
+	}
+	if pfx.AuthSafe, err = dataContentInfo(authSafe); err != nil {
+		return nil, err
+	}
+	if password != "" && !ks.pkcs12.noMac {
+		digest, iterations := ks.pkcs12.macDigest, ks.pkcs12.iterations
+		if _, ok := macHashes[digest.String()]; !ok {
+			digest = oidSHA256
+		}
+		if iterations <= 0 {
+			iterations = defaultMacIterations
+		}
+		salt := make([]byte, 20)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		pfx.MacData, err = computeMac(authSafe, password, digest, iterations, salt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return asn1.Marshal(pfx)
+}
+
+// trustedCertBag encodes an entry the same way keytool does
+func trustedCertBag(entry *Entry) ([]byte, error) {
+	cb, err := asn1.Marshal(certBag{
+		ID:   oidCertTypeX509Certificate,
+		Data: entry.Certificate.Raw,
+	})
+	if err != nil {
+		return nil, err
+	}
+	name, err := asn1.Marshal(asn1.RawValue{
+		Tag:   asn1.TagBMPString,
+		Bytes: encodeBMPString(entry.Alias),
+	})
+	if err != nil {
+		return nil, err
+	}
+	usage, err := asn1.Marshal(oidAnyExtendedKey)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(safeBag{
+		ID:    oidCertBag,
+		Value: explicitTag(cb),
+		Attributes: []pkcs12Attribute{
+			{ID: oidFriendlyName, Value: setOf(name)},
+			{ID: oidTrustedKeyUsage, Value: setOf(usage)},
+		},
+	})
+}
+
+// dataContent returns the octets of a data ContentInfo
+func dataContent(info contentInfo) ([]byte, error) {
+	if !info.ContentType.Equal(oidDataContentType) {
+		return nil, fmt.Errorf("%v: PKCS12 content type %v", ErrUnsupported, info.ContentType)
+	}
+	var octets []byte
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &octets); err != nil {
+		return nil, fmt.Errorf("keystore: invalid PKCS12 data: %v", err)
+	}
+	return octets, nil
+}
+
+func dataContentInfo(octets []byte) (contentInfo, error) {
+	inner, err := asn1.Marshal(octets)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{
+		ContentType: oidDataContentType,
+		Content:     explicitTag(inner),
+	}, nil
+}
+
+// explicitTag wraps DER in a [0] EXPLICIT tag. encoding/asn1 ignores struct tags
+// when marshaling a RawValue so this is done by hand.
+func explicitTag(der []byte) asn1.RawValue {
+	return asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        0,
+		IsCompound: true,
+		Bytes:      der,
+	}
+}
+
+func setOf(der []byte) asn1.RawValue {
+	return asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      der,
+	}
+}
+
+func verifyMac(md *macData, authSafe []byte, password string) error {
+	expected, err := computeMac(authSafe, password, md.Mac.Algorithm.Algorithm, md.Iterations, md.MacSalt)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected.Mac.Digest, md.Mac.Digest) {
+		return ErrIncorrectPassword
+	}
+	return nil
+}
+
+// computeMac returns the HMAC over authSafe keyed with the PKCS12 KDF
+func computeMac(authSafe []byte, password string, digest asn1.ObjectIdentifier, iterations int, salt []byte) (macData, error) {
+	h, ok := macHashes[digest.String()]
+	if !ok {
+		return macData{}, fmt.Errorf("%v: PKCS12 MAC algorithm %v", ErrUnsupported, digest)
+	}
+	key := pkcs12KDF(h, bmpPassword(password), salt, pkcs12MacID, iterations, h().Size())
+	mac := hmac.New(h, key)
+	mac.Write(authSafe)
+	return macData{
+		Mac: digestInfo{
+			Algorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  digest,
+				Parameters: asn1.NullRawValue,
+			},
+			Digest: mac.Sum(nil),
+		},
+		MacSalt:    salt,
+		Iterations: iterations,
+	}, nil
+}
+
+// encodeBMPString returns s as big-endian UTF-16
+func encodeBMPString(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, 0, 2*len(units))
+	for _, c := range units {
+		out = append(out, byte(c>>8), byte(c))
+	}
+	return out
+}
+
+func decodeBMPString(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// bmpPassword is the password format used by the PKCS12 KDF, a BMPString with
+// a trailing NUL.
+func bmpPassword(password string) []byte {
+	return append(encodeBMPString(password), 0, 0)
+}
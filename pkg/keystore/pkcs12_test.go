@@ -0,0 +1,194 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keystore
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+)
+
+// Files created with `openssl pkcs12 -export -nokeys -in testdata/lots.crt`
+// using the default (AES-256), PBE-SHA1-3DES, PBE-SHA1-RC2-40 (with -legacy, keytool's
+// default before JDK 18) and NONE certificate encryption.
+func TestKeystorePKCS12__decode(t *testing.T) {
+	lots, err := certutil.FromFile("../../testdata/lots.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]string{
+		"../../testdata/truststore-aes.p12":   {"one", "two", "three", "four", "five"},
+		"../../testdata/truststore-3des.p12":  nil,
+		"../../testdata/truststore-rc2.p12":   nil,
+		"../../testdata/truststore-plain.p12": nil,
+	}
+	for path, aliases := range cases {
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decode(bs, "wrong"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected ErrIncorrectPassword, got %v", path, err)
+		}
+
+		ks, err := Decode(bs, "changeit")
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if ks.Format != PKCS12 {
+			t.Errorf("%s: got format %v", path, ks.Format)
+		}
+		entries := ks.Entries()
+		if len(entries) != len(lots) {
+			t.Fatalf("%s: got %d entries", path, len(entries))
+		}
+		for i := range lots {
+			if !ks.Contains(lots[i]) {
+				t.Errorf("%s: missing certificate %d", path, i)
+			}
+		}
+		for i := range aliases {
+			if entries[i].Alias != aliases[i] {
+				t.Errorf("%s: got alias %q, expected %q", path, entries[i].Alias, aliases[i])
+			}
+		}
+		if aliases == nil && entries[0].Alias != certutil.GetHexSHA256Fingerprint(*lots[0]) {
+			t.Errorf("%s: expected fingerprint alias, got %q", path, entries[0].Alias)
+		}
+	}
+
+	// Unencrypted certificates can be read without a password
+	bs, err := ioutil.ReadFile("../../testdata/truststore-plain.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks, err := Decode(bs, ""); err != nil || len(ks.Entries()) != len(lots) {
+		t.Errorf("err=%v", err)
+	}
+}
+
+func TestKeystorePKCS12__roundTrip(t *testing.T) {
+	bs, err := ioutil.ReadFile("../../testdata/truststore-aes.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := Decode(bs, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ks.Delete("two") {
+		t.Error("expected alias two to be deleted")
+	}
+	example, err := certutil.FromFile("../../testdata/example.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Add("example", example[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := ks.Encode("changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(out, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+	ks, err = Decode(out, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var aliases []string
+	for _, e := range ks.Entries() {
+		aliases = append(aliases, e.Alias)
+	}
+	if len(aliases) != 5 || aliases[1] != "three" || aliases[4] != "example" || !ks.Contains(example[0]) {
+		t.Errorf("unexpected aliases: %v", aliases)
+	}
+}
+
+// truststore-nomac.p12 has no MAC, like a password-less JDK 18+ cacerts, which
+// is kept when written back.
+func TestKeystorePKCS12__noMac(t *testing.T) {
+	bs, err := ioutil.ReadFile("../../testdata/truststore-nomac.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := Decode(bs, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ks.pkcs12.noMac {
+		t.Error("expected no MAC")
+	}
+	out, err := ks.Encode("changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks, err = Decode(out, "changeit"); err != nil || !ks.pkcs12.noMac {
+		t.Errorf("expected no MAC, err=%v", err)
+	}
+
+	// Keystores with a MAC keep one
+	bs, err = ioutil.ReadFile("../../testdata/truststore-aes.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks, err = Decode(bs, "changeit"); err != nil || ks.pkcs12.noMac {
+		t.Fatalf("expected a MAC, err=%v", err)
+	}
+	if out, err = ks.Encode("changeit"); err != nil {
+		t.Fatal(err)
+	}
+	if ks, err = Decode(out, "changeit"); err != nil || ks.pkcs12.noMac {
+		t.Errorf("expected a MAC, err=%v", err)
+	}
+}
+
+// keystore-withkey.p12 holds a private key (and its certificate) with example.crt
+func TestKeystorePKCS12__keysKept(t *testing.T) {
+	bs, err := ioutil.ReadFile("../../testdata/keystore-withkey.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := Decode(bs, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := ks.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	if len(ks.pkcs12.bags) != 2 {
+		t.Errorf("expected key and certificate bags to be kept, got %d", len(ks.pkcs12.bags))
+	}
+	if err := ks.Add("mykey", entries[0].Certificate); err == nil {
+		t.Error("expected duplicate alias error")
+	}
+
+	out, err := ks.Encode("changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err = Decode(out, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.Entries()) != 1 || len(ks.pkcs12.bags) != 2 {
+		t.Errorf("got %d entries and %d bags", len(ks.Entries()), len(ks.pkcs12.bags))
+	}
+}
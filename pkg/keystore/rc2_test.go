@@ -0,0 +1,57 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keystore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors from RFC 2268 section 5
+func TestKeystoreRC2(t *testing.T) {
+	cases := []struct {
+		key, plain, cipher string
+		bits               int
+	}{
+		{"0000000000000000", "0000000000000000", "ebb773f993278eff", 63},
+		{"ffffffffffffffff", "ffffffffffffffff", "278b27e42e2f0d49", 64},
+		{"3000000000000000", "1000000000000001", "30649edf9be7d2c2", 64},
+		{"88", "0000000000000000", "61a8a244adacccf0", 64},
+		{"88bca90e90875a", "0000000000000000", "6ccf4308974c267f", 64},
+		{"88bca90e90875a7f0f79c384627bafb2", "0000000000000000", "1a807d272bbe5db1", 64},
+		{"88bca90e90875a7f0f79c384627bafb2", "0000000000000000", "2269552ab0f85ca6", 128},
+		{"88bca90e90875a7f0f79c384627bafb216f80a6f85920584c42fceb0be255daf1e", "0000000000000000", "5b78d3a43dfff1f1", 129},
+	}
+	for i := range cases {
+		key, _ := hex.DecodeString(cases[i].key)
+		plain, _ := hex.DecodeString(cases[i].plain)
+		ciphertext, _ := hex.DecodeString(cases[i].cipher)
+
+		block, err := newRC2Cipher(key, cases[i].bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]byte, rc2BlockSize)
+		block.Encrypt(out, plain)
+		if !bytes.Equal(out, ciphertext) {
+			t.Errorf("%d: encrypt got %x", i, out)
+		}
+		block.Decrypt(out, ciphertext)
+		if !bytes.Equal(out, plain) {
+			t.Errorf("%d: decrypt got %x", i, out)
+		}
+	}
+}
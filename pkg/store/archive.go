@@ -0,0 +1,536 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+const (
+	archiveManifestFilename = "manifest.json"
+	archiveVersion          = 1
+)
+
+// archiver is implemented by stores whose backups can be archived and restored on
+// another host. kind is the same for the store on every host, e.g. "java".
+type archiver interface {
+	kind() string
+}
+
+// ArchiveManifest describes a backup archive (a .tar.gz file), which holds the
+// latest backup of one or more stores so they can be restored on another host.
+//
+// Each store's files are under stores/<n>/ in the archive, along with a PEM
+// bundle (certs.pem) of the certificates it trusted.
+type ArchiveManifest struct {
+	Version  int       `json:"version"`
+	Created  time.Time `json:"created"`
+	Host     string    `json:"host"`
+	Platform string    `json:"platform"`
+
+	Stores []ArchivedStore `json:"stores"`
+
+	// Checksums maps each file in the archive, other than the manifest, to its
+	// hex encoded SHA256 checksum. Symlinks are recorded as "-> <target>".
+	Checksums map[string]string `json:"checksums"`
+}
+
+// ArchivedStore is the backup of one store in an archive
+type ArchivedStore struct {
+	// Name is the name of the store, e.g. java:/usr/lib/jvm/java-17
+	Name string `json:"name"`
+
+	// Kind is the type of store, which must match the store restored from the
+	// backup, e.g. java or linux
+	Kind string `json:"kind"`
+
+	// Title, Version and Location are from the store's Info, where Title is the
+	// distribution or app (e.g. Alpine Linux or Java)
+	Title    string `json:"title"`
+	Version  string `json:"version,omitempty"`
+	Location string `json:"location,omitempty"`
+
+	// Backup is the path in the archive of the backup's file or directory
+	Backup string `json:"backup"`
+
+	// Bundle is the path in the archive of the PEM encoded certificates
+	// trusted by the store
+	Bundle       string `json:"bundle"`
+	Certificates int    `json:"certificates"`
+}
+
+// IsArchive returns true if path is named like a backup archive, which are
+// encrypted when named like .age
+func IsArchive(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") || strings.HasSuffix(path, ".age")
+}
+
+// WriteArchive saves the latest backup of each store into an archive at path. The
+// archive is encrypted when opts has recipients or a passphrase, in which case path
+// must end with .age, and signed (into path.sig) when opts has a signing key.
+func WriteArchive(path string, stores []NamedStore, opts *Options) (*ArchiveManifest, error) {
+	recipients, err := opts.archiveRecipients()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := opts.archiveSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(recipients) > 0 && !strings.HasSuffix(path, ".age"):
+		return nil, fmt.Errorf("%s must end with .age as it's encrypted, e.g. state.tar.gz.age", path)
+	case len(recipients) == 0 && !IsArchive(path):
+		return nil, fmt.Errorf("%s must end with .tar.gz or .tgz", path)
+	case len(recipients) == 0 && strings.HasSuffix(path, ".age"):
+		return nil, fmt.Errorf("%s ends with .age but no recipients or passphrase were given", path)
+	}
+
+	staging, err := ioutil.TempDir("", "cert-manage-archive")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	host, _ := os.Hostname()
+	m := &ArchiveManifest{
+		Version:  archiveVersion,
+		Created:  time.Now().UTC(),
+		Host:     host,
+		Platform: runtime.GOOS,
+	}
+	for i := range stores {
+		as, err := stageArchivedStore(opts.root(), staging, strconv.Itoa(i), stores[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", stores[i].Name, err)
+		}
+		m.Stores = append(m.Stores, as)
+	}
+	if m.Checksums, _, err = checksumBackup(staging); err != nil {
+		return nil, err
+	}
+
+	bs, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(staging, archiveManifestFilename), bs, file.TempFilePermissions); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeTarGz(&buf, staging); err != nil {
+		return nil, err
+	}
+	out := buf.Bytes()
+	if len(recipients) > 0 {
+		if out, err = encryptArchive(out, recipients...); err != nil {
+			return nil, err
+		}
+	}
+	if err := ioutil.WriteFile(path, out, file.TempFilePermissions); err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		return m, writeSignature(path, signer, out)
+	}
+	return m, nil
+}
+
+// stageArchivedStore copies the latest backup of a store, and a bundle of the
+// certificates it trusts, into stores/<n>/ under staging. Symlinks out of the
+// backup (e.g. to /usr/share/ca-certificates) are replaced by what they point to,
+// as those paths won't exist on another host.
+func stageArchivedStore(root, staging, n string, ns NamedStore) (ArchivedStore, error) {
+	a, ok := ns.Store.(archiver)
+	if !ok {
+		return ArchivedStore{}, fmt.Errorf("backups can't be archived")
+	}
+	latest, err := ns.Store.GetLatestBackup()
+	if err != nil {
+		return ArchivedStore{}, err
+	}
+	if latest == "" {
+		return ArchivedStore{}, fmt.Errorf("no backup found")
+	}
+	certs, err := ns.Store.List(&ListOptions{
+		Trusted: true,
+	})
+	if err != nil {
+		return ArchivedStore{}, err
+	}
+
+	dir := filepath.Join(staging, "stores", n)
+	if err := os.MkdirAll(dir, file.TempDirPermissions); err != nil {
+		return ArchivedStore{}, err
+	}
+	fi, err := os.Lstat(latest)
+	if err != nil {
+		return ArchivedStore{}, err
+	}
+	backup := filepath.Join(dir, filepath.Base(latest))
+	if fi.IsDir() {
+		err = file.MirrorDir(latest, backup)
+	} else {
+		err = copyEntry(latest, backup)
+	}
+	if err != nil {
+		return ArchivedStore{}, err
+	}
+	if err := resolveArchivedLinks(root, latest, backup); err != nil {
+		return ArchivedStore{}, err
+	}
+	if err := certutil.ToFile(filepath.Join(dir, "certs.pem"), certs); err != nil {
+		return ArchivedStore{}, err
+	}
+
+	info := ns.Store.GetInfo()
+	return ArchivedStore{
+		Name:         ns.Name,
+		Kind:         a.kind(),
+		Title:        info.Name,
+		Version:      info.Version,
+		Location:     info.Location,
+		Backup:       "stores/" + n + "/" + filepath.Base(latest),
+		Bundle:       "stores/" + n + "/certs.pem",
+		Certificates: len(certs),
+	}, nil
+}
+
+// resolveArchivedLinks replaces each symlink in the copy of a backup (at dst) which
+// points outside of the backup (at src) with a copy of the file or directory it
+// points to. Links are followed under root. Links within the backup, like the
+// hashed links of an OpenSSL certs/ directory, are kept.
+func resolveArchivedLinks(root, src, dst string) error {
+	return filepath.Walk(src, func(where string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := os.Readlink(where)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) && within(src, filepath.Join(filepath.Dir(where), target)) && where != src {
+			return nil
+		}
+
+		// The link leaves the backup, copy what it points to
+		real, err := file.ResolvePath(root, where)
+		if err != nil {
+			return fmt.Errorf("%s: %v", where, err)
+		}
+		rel, err := filepath.Rel(src, where)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(dst, rel)
+		if err := os.Remove(out); err != nil {
+			return err
+		}
+		fi, err := os.Stat(real)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return file.MirrorDir(real, out)
+		}
+		return file.CopyFile(real, out)
+	})
+}
+
+// UnpackArchive checks an archive's signature against opts' trusted keys (if any),
+// decrypts it if it's encrypted and unpacks it into a new temporary directory.
+// The directory, which the caller must remove, is returned along with the manifest.
+//
+// The directory can be restored from, like the archive itself.
+func UnpackArchive(path string, opts *Options) (string, *ArchiveManifest, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := opts.verifyArchive(path, bs); err != nil {
+		return "", nil, err
+	}
+	if isEncrypted(bs) {
+		identities, err := opts.archiveIdentities()
+		if err != nil {
+			return "", nil, err
+		}
+		if len(identities) == 0 {
+			return "", nil, fmt.Errorf("%s is encrypted, an identity or passphrase is needed to decrypt it", path)
+		}
+		if bs, err = decryptArchive(bs, identities...); err != nil {
+			return "", nil, fmt.Errorf("problem decrypting %s: %v", path, err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "cert-manage-archive")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := extractTarGz(bytes.NewReader(bs), dir); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("problem unpacking %s: %v", path, err)
+	}
+	m, err := readArchiveDir(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return dir, m, nil
+}
+
+// readArchiveDir reads the manifest of an unpacked archive and checks every
+// file is as it was archived
+func readArchiveDir(dir string) (*ArchiveManifest, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(dir, archiveManifestFilename))
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive, %v", err)
+	}
+	var m ArchiveManifest
+	if err := json.Unmarshal(bs, &m); err != nil {
+		return nil, fmt.Errorf("problem reading manifest: %v", err)
+	}
+	if m.Version != archiveVersion {
+		return nil, fmt.Errorf("version %d archives aren't supported, only version %d", m.Version, archiveVersion)
+	}
+
+	files, _, err := checksumBackup(dir)
+	if err != nil {
+		return nil, err
+	}
+	delete(files, archiveManifestFilename)
+	for name, sum := range m.Checksums {
+		if files[name] != sum {
+			return nil, fmt.Errorf("archive is corrupt, %s doesn't match its checksum", name)
+		}
+	}
+	for name := range files {
+		if _, ok := m.Checksums[name]; !ok {
+			return nil, fmt.Errorf("archive is corrupt, %s isn't in the manifest", name)
+		}
+	}
+	return &m, nil
+}
+
+// isArchiveDir returns true if dir is an unpacked archive
+func isArchiveDir(dir string) bool {
+	bs, err := ioutil.ReadFile(filepath.Join(dir, archiveManifestFilename))
+	if err != nil {
+		return false
+	}
+	var m ArchiveManifest
+	return json.Unmarshal(bs, &m) == nil && m.Version > 0 && m.Stores != nil
+}
+
+// archiveRestorePoint returns the backup in an unpacked archive of the same kind of
+// store as s. When the archive has several, the one with the same location is used.
+func archiveRestorePoint(s Store, dir string) (string, error) {
+	a, ok := s.(archiver)
+	if !ok {
+		return "", fmt.Errorf("restoring from an archive isn't supported")
+	}
+	m, err := readArchiveDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var found []ArchivedStore
+	for i := range m.Stores {
+		if m.Stores[i].Kind == a.kind() {
+			found = append(found, m.Stores[i])
+		}
+	}
+	if len(found) > 1 {
+		location := s.GetInfo().Location
+		for i := range found {
+			if found[i].Location == location {
+				found = found[i : i+1]
+				break
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("%s has no %s backup", dir, a.kind())
+	case 1:
+		return filepath.Join(dir, filepath.FromSlash(found[0].Backup)), nil
+	}
+	return "", fmt.Errorf("%s has %d %s backups and none are of %s", dir, len(found), a.kind(), s.GetInfo().Location)
+}
+
+// fromArchive returns true if path is a backup in an unpacked archive, which are
+// kept in stores/<n>/
+func fromArchive(path string) bool {
+	return isArchiveDir(filepath.Dir(filepath.Dir(filepath.Dir(path))))
+}
+
+// writeTarGz writes each file, symlink and directory under dir as a gzip
+// compressed tar file
+func writeTarGz(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(where string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, where)
+		if err != nil || name == "." {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(where); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(where)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extractTarGz unpacks a gzip compressed tar file into dir. Entries outside of
+// dir, or under a symlink, are refused.
+func extractTarGz(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, file.TempDirPermissions); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s is outside of the archive", hdr.Name)
+		}
+		if underSymlink(dir, name) {
+			return fmt.Errorf("%s is under a symlink", hdr.Name)
+		}
+		where := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(where), file.TempDirPermissions); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(where, hdr.FileInfo().Mode().Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			f, err := os.OpenFile(where, os.O_WRONLY|os.O_CREATE|os.O_EXCL, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := checkArchivedLink(name, hdr.Linkname); err != nil {
+				return fmt.Errorf("%s: %v", hdr.Name, err)
+			}
+			if err := os.Symlink(hdr.Linkname, where); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s has an unsupported type", hdr.Name)
+		}
+	}
+}
+
+// checkArchivedLink returns an error unless the symlink at name (relative to the
+// unpacked archive) points within the backup it's in, stores/<n>/<backup>/. Links
+// out of a backup are replaced when archiving, so any others would only lead a
+// restore to somewhere on the host.
+func checkArchivedLink(name, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("symlink to %s is absolute", target)
+	}
+	parts := strings.SplitN(name, string(filepath.Separator), 4)
+	if len(parts) < 4 || parts[0] != "stores" {
+		return fmt.Errorf("symlink isn't in a backup")
+	}
+	backup := filepath.Join(parts[:3]...)
+	if !within(backup, filepath.Join(filepath.Dir(name), target)) {
+		return fmt.Errorf("symlink to %s leaves its backup", target)
+	}
+	return nil
+}
+
+// underSymlink returns true if any parent of name (relative to dir) is a symlink
+func underSymlink(dir, name string) bool {
+	for p := filepath.Dir(name); p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		if fi, err := os.Lstat(filepath.Join(dir, p)); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,267 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+// signatureSuffix is added to an archive's path for its signature file, which
+// holds the base64 encoded Ed25519 signature of the archive
+const signatureSuffix = ".sig"
+
+// archiveRecipients returns who archives are encrypted to, which is nobody when
+// archives aren't encrypted
+func (o *Options) archiveRecipients() ([]age.Recipient, error) {
+	if o == nil {
+		return nil, nil
+	}
+	var out []age.Recipient
+	for _, s := range o.ArchiveRecipients {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	if o.ArchivePassphraseFile != "" {
+		if len(out) > 0 {
+			return nil, errors.New("archives can be encrypted to recipients or with a passphrase, not both")
+		}
+		pass, err := readPassphrase(o.ArchivePassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		r, err := age.NewScryptRecipient(pass)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// archiveIdentities returns the identities archives are decrypted with
+func (o *Options) archiveIdentities() ([]age.Identity, error) {
+	if o == nil {
+		return nil, nil
+	}
+	var out []age.Identity
+	for _, path := range o.ArchiveIdentityFiles {
+		fd, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := age.ParseIdentities(fd)
+		fd.Close()
+		if err != nil {
+			return nil, fmt.Errorf("problem reading identities from %s: %v", path, err)
+		}
+		out = append(out, ids...)
+	}
+	if o.ArchivePassphraseFile != "" {
+		pass, err := readPassphrase(o.ArchivePassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		id, err := age.NewScryptIdentity(pass)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// ageHeader starts every (unarmored) age encrypted file
+const ageHeader = "age-encryption.org/v1\n"
+
+// isEncrypted returns true if bs is an age encrypted file, which may be armored
+// (age -a)
+func isEncrypted(bs []byte) bool {
+	return bytes.HasPrefix(bs, []byte(ageHeader)) || bytes.HasPrefix(bytes.TrimSpace(bs), []byte(armor.Header))
+}
+
+// encryptArchive encrypts an archive to recipients with age
+func encryptArchive(bs []byte, recipients ...age.Recipient) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(bs); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptArchive decrypts an age encrypted (and possibly armored) archive with
+// the first identity which matches
+func decryptArchive(bs []byte, identities ...age.Identity) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(bs)
+	if !bytes.HasPrefix(bs, []byte(ageHeader)) {
+		r = armor.NewReader(bytes.NewReader(bytes.TrimSpace(bs)))
+	}
+	r, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func readPassphrase(path string) (string, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("problem reading passphrase: %v", err)
+	}
+	return strings.TrimRight(string(bs), "\r\n"), nil
+}
+
+// archiveSigningKey returns the key archives are signed with, if any
+func (o *Options) archiveSigningKey() (ed25519.PrivateKey, error) {
+	if o == nil || o.ArchiveSigningKeyFile == "" {
+		return nil, nil
+	}
+	block, err := readPEM(o.ArchiveSigningKeyFile, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading %s: %v", o.ArchiveSigningKeyFile, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't an Ed25519 private key", o.ArchiveSigningKeyFile)
+	}
+	return priv, nil
+}
+
+// archiveTrustedKeys returns the keys an archive must be signed by, none means
+// archives don't need to be signed
+func (o *Options) archiveTrustedKeys() ([]ed25519.PublicKey, error) {
+	var paths []string
+	if o != nil {
+		paths = o.ArchiveTrustedKeyFiles
+	}
+	if len(paths) == 0 {
+		if v := os.Getenv("CERT_MANAGE_TRUSTED_KEYS"); v != "" {
+			paths = strings.Split(v, ",")
+		}
+	}
+	var out []ed25519.PublicKey
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		block, err := readPEM(path, "PUBLIC KEY")
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("problem reading %s: %v", path, err)
+		}
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s isn't an Ed25519 public key", path)
+		}
+		out = append(out, pub)
+	}
+	return out, nil
+}
+
+// readPEM returns the first PEM block of kind in a file
+func readPEM(path, kind string) (*pem.Block, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, bs = pem.Decode(bs)
+		if block == nil {
+			return nil, fmt.Errorf("no %s found in %s", kind, path)
+		}
+		if block.Type == kind {
+			return block, nil
+		}
+	}
+}
+
+// writeSignature signs an archive's contents into path.sig
+func writeSignature(path string, key ed25519.PrivateKey, contents []byte) error {
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, contents))
+	return ioutil.WriteFile(path+signatureSuffix, []byte(sig+"\n"), file.TempFilePermissions)
+}
+
+// CheckArchiveDir returns an error if dir is an unpacked archive and opts has
+// trusted keys. Only archive files are signed, so the files of an unpacked archive
+// can't be verified and anyone could have written them.
+func CheckArchiveDir(dir string, opts *Options) error {
+	if !isArchiveDir(dir) {
+		return nil
+	}
+	keys, err := opts.archiveTrustedKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return fmt.Errorf("%s is an unpacked archive, which can't be verified against the trusted keys, restore from the signed archive instead", dir)
+	}
+	return nil
+}
+
+// verifyArchive checks the archive at path is signed by one of the trusted keys,
+// when there are any
+func (o *Options) verifyArchive(path string, contents []byte) error {
+	keys, err := o.archiveTrustedKeys()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	bs, err := ioutil.ReadFile(path + signatureSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s isn't signed, %s%s not found", path, path, signatureSuffix)
+		}
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(bs)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature in %s%s", path, signatureSuffix)
+	}
+	for i := range keys {
+		if ed25519.Verify(keys[i], contents, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature of %s doesn't verify with any trusted key", path)
+}
@@ -0,0 +1,394 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+func TestStore__Archive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Archive the backup of a bundle on one host
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(src, "ca.pem")
+	if err := certutil.ToFile(path, certs); err != nil {
+		t.Fatal(err)
+	}
+	st := python.bundle(src, "", path)
+	if err := st.Backup(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "state.tar.gz")
+	if _, err := WriteArchive(filepath.Join(dir, "state.zip"), []NamedStore{{Name: "python", Store: st}}, nil); err == nil {
+		t.Error("expected error")
+	}
+	m, err := WriteArchive(archive, []NamedStore{{Name: "python:/ca.pem", Store: st}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Stores) != 1 || m.Stores[0].Kind != "python" || m.Stores[0].Certificates != len(certs) || len(m.Checksums) != 2 {
+		t.Fatalf("got %#v", m)
+	}
+
+	// and restore it to a bundle elsewhere on another host
+	dst := filepath.Join(dir, "dst")
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dst, "certifi.pem")
+	if err := certutil.ToFile(other, certs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := python.bundle(dst, "", other).Restore(archive); err == nil || !strings.Contains(err.Error(), "UnpackArchive") {
+		t.Errorf("expected error, got %v", err)
+	}
+	unpacked, _, err := UnpackArchive(archive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(unpacked)
+	if err := python.bundle(dst, "", other).Restore(unpacked); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := certutil.FromFile(other); err != nil || len(found) != len(certs) {
+		t.Errorf("got %d certs, err=%v", len(found), err)
+	}
+
+	// Archives without a backup of the same kind of store aren't restored
+	if _, err := RestorePoint(node.bundle(dst, "", other), unpacked); err == nil || !strings.Contains(err.Error(), "no node backup") {
+		t.Errorf("expected error, got %v", err)
+	}
+
+	// Files changed after unpacking are found
+	if err := ioutil.WriteFile(filepath.Join(unpacked, "stores", "0", "certs.pem"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestorePoint(python.bundle(dst, "", other), unpacked); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("expected error, got %v", err)
+	}
+}
+
+func TestStore__ArchiveLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require extra permissions on windows")
+	}
+	dir, err := ioutil.TempDir("", "cert-manage-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A backup with a link to a file outside of it, and a link within it
+	shared := filepath.Join(dir, "usr", "share", "ca.crt")
+	if err := os.MkdirAll(filepath.Dir(shared), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.CopyFile(filepath.Join("..", "..", "testdata", "example.crt"), shared); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "backup")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/usr/share/ca.crt", filepath.Join(src, "ca.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("ca.pem", filepath.Join(src, "abcd1234.0")); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(dir, "staged")
+	if err := file.MirrorDir(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resolveArchivedLinks(dir, src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(filepath.Join(dst, "ca.pem")); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("expected a copy of the link's target, err=%v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "abcd1234.0")); err != nil || target != "ca.pem" {
+		t.Errorf("expected the link to be kept, got %q err=%v", target, err)
+	}
+}
+
+func TestStore__ArchiveEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ca.pem")
+	if err := certutil.ToFile(path, certs); err != nil {
+		t.Fatal(err)
+	}
+	st := python.bundle(dir, "", path)
+	if err := st.Backup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// age identity and Ed25519 keys
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := filepath.Join(dir, "key.txt")
+	if err := ioutil.WriteFile(identity, []byte(id.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeKey := func(name string) (string, string) {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubDER, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privPath, pubPath := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".pub")
+		if err := ioutil.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return privPath, pubPath
+	}
+	signer, trusted := writeKey("signer")
+	_, other := writeKey("other")
+
+	stores := []NamedStore{{Name: "python:/ca.pem", Store: st}}
+	opts := &Options{
+		ArchiveRecipients:     []string{id.Recipient().String()},
+		ArchiveSigningKeyFile: signer,
+	}
+	if _, err := WriteArchive(filepath.Join(dir, "state.tar.gz"), stores, opts); err == nil {
+		t.Error("expected error")
+	}
+	archive := filepath.Join(dir, "state.tar.gz.age")
+	if _, err := WriteArchive(archive, stores, opts); err != nil {
+		t.Fatal(err)
+	}
+	if bs, err := ioutil.ReadFile(archive); err != nil || !isEncrypted(bs) {
+		t.Fatalf("archive isn't encrypted, err=%v", err)
+	}
+
+	// Archives are decrypted with the identity and must be signed by a trusted key
+	if _, _, err := UnpackArchive(archive, nil); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("expected error, got %v", err)
+	}
+	if _, err := RestorePoint(st, archive); err == nil {
+		t.Error("expected encrypted archive to not be read")
+	}
+	if _, _, err := UnpackArchive(archive, &Options{ArchiveIdentityFiles: []string{identity}, ArchiveTrustedKeyFiles: []string{other}}); err == nil || !strings.Contains(err.Error(), "doesn't verify") {
+		t.Errorf("expected error, got %v", err)
+	}
+	unpacked, m, err := UnpackArchive(archive, &Options{ArchiveIdentityFiles: []string{identity}, ArchiveTrustedKeyFiles: []string{other, trusted}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(unpacked)
+	if len(m.Stores) != 1 || m.Stores[0].Certificates != len(certs) {
+		t.Errorf("got %#v", m)
+	}
+
+	// The unpacked archive is restored from
+	if err := certutil.ToFile(path, certs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Restore(unpacked); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := certutil.FromFile(path); err != nil || len(found) != len(certs) {
+		t.Errorf("got %d certs, err=%v", len(found), err)
+	}
+
+	// Unsigned archives aren't unpacked when there are trusted keys
+	if err := os.Remove(archive + ".sig"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := UnpackArchive(archive, &Options{ArchiveIdentityFiles: []string{identity}, ArchiveTrustedKeyFiles: []string{trusted}}); err == nil || !strings.Contains(err.Error(), "isn't signed") {
+		t.Errorf("expected error, got %v", err)
+	}
+
+	// and unpacked archives can't be verified, so they're refused
+	if err := CheckArchiveDir(unpacked, &Options{ArchiveTrustedKeyFiles: []string{trusted}}); err == nil {
+		t.Error("expected error")
+	}
+	if err := CheckArchiveDir(unpacked, nil); err != nil {
+		t.Error(err)
+	}
+	if err := CheckArchiveDir(dir, &Options{ArchiveTrustedKeyFiles: []string{trusted}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStore__extractTarGz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(hdrs ...*tar.Header) string {
+		fd, err := ioutil.TempFile(dir, "archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fd.Close()
+		gz := gzip.NewWriter(fd)
+		tw := tar.NewWriter(gz)
+		for i := range hdrs {
+			if err := tw.WriteHeader(hdrs[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tw.Close()
+		gz.Close()
+		return fd.Name()
+	}
+
+	cases := map[string]string{
+		write(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0600}): "outside of the archive",
+		write(&tar.Header{Name: "/evil", Typeflag: tar.TypeReg, Mode: 0600}):   "outside of the archive",
+		write(&tar.Header{Name: "stores/0/b/link", Typeflag: tar.TypeSymlink, Linkname: "certs"},
+			&tar.Header{Name: "stores/0/b/link/evil", Typeflag: tar.TypeReg, Mode: 0600}): "under a symlink",
+		write(&tar.Header{Name: "stores/0/b/ca.pem", Typeflag: tar.TypeSymlink, Linkname: "/etc/shadow"}):     "is absolute",
+		write(&tar.Header{Name: "stores/0/b/ca.pem", Typeflag: tar.TypeSymlink, Linkname: "../../../../etc"}): "leaves its backup",
+		write(&tar.Header{Name: "stores/0/b/ca.pem", Typeflag: tar.TypeSymlink, Linkname: "../c/ca.pem"}):     "leaves its backup",
+		write(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "manifest.json"}):                "isn't in a backup",
+	}
+	for path, problem := range cases {
+		fd, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = extractTarGz(fd, filepath.Join(dir, "out-"+filepath.Base(path)))
+		fd.Close()
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("%s: expected %q, got %v", path, problem, err)
+		}
+	}
+
+	// Links within a backup, like hashed links, are kept
+	path := write(&tar.Header{Name: "stores/0/b/certs/ca.pem", Typeflag: tar.TypeReg, Mode: 0600},
+		&tar.Header{Name: "stores/0/b/certs/abcd1234.0", Typeflag: tar.TypeSymlink, Linkname: "ca.pem"},
+		&tar.Header{Name: "stores/0/b/cert.pem", Typeflag: tar.TypeSymlink, Linkname: "certs/ca.pem"})
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	if err := extractTarGz(fd, filepath.Join(dir, "out")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Vectors from the age testkit (c2sp.org/CCTV/age) check archives made by other age
+// implementations are decrypted
+func TestStore__decryptArchive(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("..", "..", "testdata", "age", "*"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no vectors found, err=%v", err)
+	}
+	for _, path := range paths {
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx := bytes.Index(bs, []byte("\n\n"))
+		if idx < 0 {
+			t.Fatalf("%s: invalid vector", path)
+		}
+		header, body := map[string]string{}, bs[idx+2:]
+		for _, line := range strings.Split(string(bs[:idx]), "\n") {
+			parts := strings.SplitN(line, ": ", 2)
+			header[parts[0]] = parts[1]
+		}
+
+		var identities []age.Identity
+		if id := header["identity"]; id != "" {
+			x, err := age.ParseX25519Identity(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			identities = append(identities, x)
+		}
+		if pass := header["passphrase"]; pass != "" {
+			s, err := age.NewScryptIdentity(pass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			identities = append(identities, s)
+		}
+
+		if !isEncrypted(body) {
+			t.Errorf("%s: expected to be encrypted", path)
+		}
+		out, err := decryptArchive(body, identities...)
+		switch header["expect"] {
+		case "success":
+			sum := sha256.Sum256(out)
+			if err != nil || hex.EncodeToString(sum[:]) != header["payload"] {
+				t.Errorf("%s: payload doesn't match, err=%v", path, err)
+			}
+		default:
+			if err == nil {
+				t.Errorf("%s: expected %s", path, header["expect"])
+			}
+		}
+	}
+}
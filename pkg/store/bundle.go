@@ -0,0 +1,307 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+// bundleApp is a language runtime or tool which trusts the certificates in PEM
+// bundle files rather than the platform store, e.g. Python's certifi.
+type bundleApp struct {
+	// name is used for -app and the backup directory, e.g. python
+	name  string
+	title string
+
+	// find returns the bundle files in use, which may not be deduplicated
+	find func(opts *Options) []string
+
+	// version returns the installed version, root is empty for "/"
+	version func(root string) string
+}
+
+// bundleApps are each app whose bundles are included in `-app bundles`
+var bundleApps = []bundleApp{python, node, curl}
+
+// bundleStore is one PEM file of certificates
+type bundleStore struct {
+	// root is the filesystem root path is under, empty for "/"
+	root string
+
+	app     string
+	title   string
+	version string
+
+	path string
+}
+
+// BundlesStore returns a Store for every CA bundle file found for Python, Node.js
+// and curl. Each bundle is available from Stores().
+func BundlesStore(opts *Options) Store {
+	var stores []NamedStore
+	for _, app := range bundleApps {
+		stores = append(stores, app.stores(opts)...)
+	}
+	return multiStore{
+		app:  "bundles",
+		root: opts.root(),
+		info: Info{
+			Name: "CA bundles",
+		},
+		stores: dedupBundles(opts.root(), stores),
+		none:   errors.New("store/bundles: no CA bundles found"),
+	}
+}
+
+// store returns a Store for each of the app's bundles
+func (a bundleApp) store(opts *Options) Store {
+	return multiStore{
+		app:  a.name,
+		root: opts.root(),
+		info: Info{
+			Name:    a.title,
+			Version: a.version(opts.root()),
+		},
+		stores: a.stores(opts),
+		none:   fmt.Errorf("store/%s: no %s CA bundles found", a.name, a.title),
+	}
+}
+
+// storeAt returns the store for one bundle file, addressed as `-app <name>:<path>`.
+// The file doesn't need to have been found by the app.
+func (a bundleApp) storeAt(opts *Options, where string) (Store, error) {
+	path, err := file.JoinRoot(opts.root(), where)
+	if err != nil {
+		return nil, err
+	}
+	if s, err := os.Stat(path); err != nil || !s.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s CA bundle %q not found", a.title, where)
+	}
+	return a.bundle(opts.root(), a.version(opts.root()), path), nil
+}
+
+func (a bundleApp) stores(opts *Options) []NamedStore {
+	root := opts.root()
+	version := a.version(root)
+
+	var out []NamedStore
+	paths := a.find(opts)
+	for i := range paths {
+		if s, err := os.Stat(paths[i]); err != nil || !s.Mode().IsRegular() {
+			continue
+		}
+		st := a.bundle(root, version, paths[i])
+		out = append(out, NamedStore{
+			Name:  fmt.Sprintf("%s:%s", a.name, unroot(root, paths[i])),
+			Store: st,
+		})
+	}
+	return dedupBundles(root, out)
+}
+
+func (a bundleApp) bundle(root, version, path string) bundleStore {
+	return bundleStore{
+		root:    root,
+		app:     a.name,
+		title:   a.title,
+		version: version,
+		path:    path,
+	}
+}
+
+// dedupBundles drops stores which are the same file as an earlier store, such
+// as a symlink or a bundle found from more than one place.
+func dedupBundles(root string, stores []NamedStore) []NamedStore {
+	var out []NamedStore
+	seen := make(map[string]bool)
+	for i := range stores {
+		path := stores[i].Store.(bundleStore).path
+		if real, err := file.ResolvePath(root, path); err == nil {
+			path = real
+		}
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		out = append(out, stores[i])
+	}
+	return out
+}
+
+// backupDir returns the directory (under ~/.cert-manage) backups of this bundle
+// are kept in. Symlinks aren't resolved as Remove replaces them.
+func (s bundleStore) backupDir() string {
+	return filepath.Join(s.app, backupID(s.root, s.path))
+}
+
+// Add appends each certificate which isn't already in the bundle
+func (s bundleStore) Add(certs []*x509.Certificate) error {
+	existing, err := s.read()
+	if err != nil {
+		return err
+	}
+	pool := certutil.Pool{}
+	pool.AddCertificates(existing)
+	before := len(pool.GetCertificates())
+
+	pool.AddCertificates(certs)
+	if kept := pool.GetCertificates(); len(kept) > before {
+		return replaceFile(s.path, kept)
+	}
+	return nil
+}
+
+// Backup copies the bundle (or symlink) into ~/.cert-manage/<app>/<path>/
+func (s bundleStore) Backup() error {
+	dir, err := getCertManageDir(s.root, s.backupDir())
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, fmt.Sprintf("%s-%d.bck", filepath.Base(s.path), time.Now().Unix()))
+	return copyEntry(s.path, dst)
+}
+
+func (s bundleStore) kind() string {
+	return s.app
+}
+
+func (s bundleStore) GetLatestBackup() (string, error) {
+	dir, err := certManageDir(s.root, s.backupDir())
+	if err != nil {
+		return "", fmt.Errorf("GetLatestBackup: error getting %s backup directory, err=%v", s.app, err)
+	}
+	return findLatestBackup(dir)
+}
+
+func (s bundleStore) GetInfo() *Info {
+	return &Info{
+		Name:     s.title,
+		Version:  s.version,
+		Location: unroot(s.root, s.path),
+	}
+}
+
+func (s bundleStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
+	certs, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return opts.filter(certs)
+}
+
+// Remove rewrites the bundle with only the whitelisted certificates. If the
+// bundle is a symlink (e.g. to the Linux bundle) the link is replaced rather
+// than its target.
+func (s bundleStore) Remove(wh whitelist.Whitelist) error {
+	certs, err := s.read()
+	if err != nil {
+		return err
+	}
+	var kept []*x509.Certificate
+	for i := range certs {
+		if wh.Matches(certs[i]) {
+			kept = append(kept, certs[i])
+		}
+	}
+	if len(kept) < len(certs) {
+		return replaceFile(s.path, kept)
+	}
+	return nil
+}
+
+// restorePoint returns the backup to restore, which must be a file of certificates
+func (s bundleStore) restorePoint(where string) (string, error) {
+	dir, err := certManageDir(s.root, s.backupDir())
+	if err != nil {
+		return "", err
+	}
+	src, err := findRestorePoint(s, s.root, dir, where)
+	if err != nil || src == "" {
+		return src, err
+	}
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return src, nil // bundles which are links are backed up as the link
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("%s isn't a backup of %s, it must be a file", src, s.path)
+	}
+	if certs, err := certutil.FromFile(src); err != nil || len(certs) == 0 {
+		return "", fmt.Errorf("%s isn't a backup of %s, no certificates found", src, s.path)
+	}
+	return src, nil
+}
+
+// Restore replaces the bundle with the latest backup
+func (s bundleStore) Restore(where string) error {
+	src, err := s.restorePoint(where)
+	if err != nil {
+		return err
+	}
+	if src == "" {
+		return fmt.Errorf("no backup of %s found", s.path)
+	}
+	if debug {
+		fmt.Printf("store/%s: restoring %s from %s\n", s.app, s.path, src)
+	}
+	return replaceEntry(src, s.path)
+}
+
+// listBackup reads the certificates of a backup of the bundle
+func (s bundleStore) listBackup(backup string, opts *ListOptions) ([]*x509.Certificate, error) {
+	s.path = backup
+	return s.List(opts)
+}
+
+// read returns the certificates in the bundle, following symlinks under root
+func (s bundleStore) read() ([]*x509.Certificate, error) {
+	path, err := file.ResolveLinks(s.root, s.path)
+	if err != nil {
+		return nil, err
+	}
+	return certutil.FromFile(path)
+}
+
+// globAll returns the matches of each pattern joined onto dir
+func globAll(dir string, patterns []string) []string {
+	var out []string
+	for i := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, patterns[i]))
+		out = append(out, matches...)
+	}
+	return out
+}
+
+// envPath returns the path in the environment variable name, which is only
+// read when not operating under an alternate root
+func envPath(root, name string) string {
+	if root != "" {
+		return ""
+	}
+	return strings.TrimSpace(os.Getenv(name))
+}
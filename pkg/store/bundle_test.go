@@ -0,0 +1,114 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+func TestStoreBundle__operations(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are unix specific")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Like Debian's python3-certifi, the bundle links to the system bundle
+	system := filepath.Join(root, "etc", "ssl", "certs", "ca-certificates.crt")
+	if err := os.MkdirAll(filepath.Dir(system), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := certutil.ToFile(system, certs[:3]); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "usr", "lib", "python3", "dist-packages", "certifi", "cacert.pem")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/ssl/certs/ca-certificates.crt", path); err != nil {
+		t.Fatal(err)
+	}
+
+	st := python.bundle(root, "", path)
+	if err := st.Backup(); err != nil {
+		t.Fatal(err)
+	}
+	if base := filepath.Base(st.backupDir()); base != "usr_lib_python3_dist-packages_certifi_cacert.pem" {
+		t.Errorf("got backup dir %s", base)
+	}
+
+	// Whitelist and then add a certificate
+	if err := st.Remove(whitelist.FromCertificates([]*x509.Certificate{certs[0]})); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Add(certs[3:5]); err != nil {
+		t.Fatal(err)
+	}
+	found, err := st.List(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 {
+		t.Errorf("got %d certificates", len(found))
+	}
+	if bundle, err := certutil.FromFile(system); err != nil || len(bundle) != 3 {
+		t.Errorf("expected system bundle to be unchanged, got %d certs (err=%v)", len(bundle), err)
+	}
+
+	// The symlink comes back
+	if err := st.Restore(""); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(path); err != nil || target != "/etc/ssl/certs/ca-certificates.crt" {
+		t.Errorf("expected symlink to be restored, target=%q err=%v", target, err)
+	}
+}
+
+func TestStoreBundle__dedup(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "ca.pem")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stores := []NamedStore{
+		{Name: "python:/ca.pem", Store: python.bundle(root, "", path)},
+		{Name: "curl:/ca.pem", Store: curl.bundle(root, "", path)},
+	}
+	if out := dedupBundles(root, stores); len(out) != 1 || out[0].Name != "python:/ca.pem" {
+		t.Errorf("got %v", out)
+	}
+}
@@ -0,0 +1,477 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+var (
+	docker = certsdApp{
+		name:  "docker",
+		title: "Docker",
+		dir:   "/etc/docker/certs.d",
+	}
+	containerd = certsdApp{
+		name:  "containerd",
+		title: "containerd",
+		dir:   "/etc/containerd/certs.d",
+	}
+
+	// certsdCAFilename is written to when adding CAs for a registry
+	certsdCAFilename = "ca.crt"
+)
+
+// certsdApp is a container runtime which trusts CAs for each registry from a
+// certs.d directory, laid out as <dir>/<host>/*.crt
+//
+// Docs:
+// - https://docs.docker.com/engine/security/certificates/
+// - https://github.com/containerd/containerd/blob/main/docs/hosts.md
+type certsdApp struct {
+	// name is used for -app and the backup directory, e.g. docker
+	name  string
+	title string
+
+	dir string
+}
+
+// certsdStore is every registry's CAs in a certs.d directory. Backups are of the
+// whole directory.
+type certsdStore struct {
+	// root is the filesystem root dir is under, empty for "/"
+	root string
+
+	app   string
+	title string
+
+	dir string
+
+	// err is set when dir leads outside of root
+	err error
+}
+
+// certsdHostStore is the CAs of one registry host in a certs.d directory
+type certsdHostStore struct {
+	certsd certsdStore
+	host   string
+}
+
+// DockerStore returns a Store for the registry CAs in /etc/docker/certs.d. The CAs
+// of each registry are available from Stores().
+func DockerStore(opts *Options) Store {
+	return docker.store(opts)
+}
+
+// ContainerdStore returns a Store for the registry CAs in /etc/containerd/certs.d.
+// The CAs of each registry are available from Stores().
+func ContainerdStore(opts *Options) Store {
+	return containerd.store(opts)
+}
+
+func (a certsdApp) store(opts *Options) certsdStore {
+	s := certsdStore{
+		root:  opts.root(),
+		app:   a.name,
+		title: a.title,
+	}
+	s.dir, s.err = file.JoinRoot(s.root, a.dir)
+	if s.err != nil {
+		s.dir = filepath.Join(s.root, a.dir)
+	}
+	return s
+}
+
+// storeAt returns the store of one registry host, addressed as `-app <name>:<host>`.
+// The host doesn't need to have any CAs yet.
+func (a certsdApp) storeAt(opts *Options, host string) (Store, error) {
+	if host == "." || host == ".." || strings.ContainsAny(host, `/\`) {
+		return nil, fmt.Errorf("invalid %s registry host %q", a.title, host)
+	}
+	certsd := a.store(opts)
+	if certsd.err != nil {
+		return nil, certsd.err
+	}
+	return certsdHostStore{
+		certsd: certsd,
+		host:   host,
+	}, nil
+}
+
+// Stores returns the store of each registry host, which are scoped to the host
+func (s certsdStore) Stores() []NamedStore {
+	fis, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil
+	}
+	var out []NamedStore
+	for i := range fis {
+		if !fis[i].IsDir() {
+			continue
+		}
+		host := fis[i].Name()
+		out = append(out, NamedStore{
+			Name:  fmt.Sprintf("%s:%s", s.app, host),
+			Scope: host,
+			Store: certsdHostStore{
+				certsd: s,
+				host:   host,
+			},
+		})
+	}
+	return out
+}
+
+func (s certsdStore) Add(certs []*x509.Certificate) error {
+	return fmt.Errorf("%s CAs are added for a registry, pick one with -app %s:<host>", s.title, s.app)
+}
+
+func (s certsdStore) planAdd(certs []*x509.Certificate) ([]Change, error) {
+	return nil, s.Add(certs)
+}
+
+// Backup copies the certs.d directory into ~/.cert-manage/<app>/certs.d/<timestamp>/
+func (s certsdStore) Backup() error {
+	if s.err != nil {
+		return s.err
+	}
+	if !file.Exists(s.dir) {
+		return fmt.Errorf("%s not found", s.dir)
+	}
+	dir, err := getCertManageDir(s.root, filepath.Join(s.app, "certs.d", fmt.Sprintf("%d", time.Now().Unix())))
+	if err != nil {
+		return err
+	}
+	return file.MirrorDir(s.dir, dir)
+}
+
+func (s certsdStore) kind() string {
+	return s.app + "/certs.d"
+}
+
+func (s certsdStore) GetLatestBackup() (string, error) {
+	dir, err := certManageDir(s.root, filepath.Join(s.app, "certs.d"))
+	if err != nil {
+		return "", fmt.Errorf("GetLatestBackup: error getting %s backup directory, err=%v", s.app, err)
+	}
+	return findLatestBackup(dir)
+}
+
+func (s certsdStore) GetInfo() *Info {
+	return &Info{
+		Name:     s.title,
+		Location: unroot(s.root, s.dir),
+	}
+}
+
+// List returns the CAs of every registry
+func (s certsdStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
+	pool := certutil.Pool{}
+	err := s.each(func(st Store) error {
+		certs, err := st.List(opts)
+		pool.AddCertificates(certs)
+		return err
+	})
+	return pool.GetCertificates(), err
+}
+
+func (s certsdStore) Remove(wh whitelist.Whitelist) error {
+	return s.each(func(st Store) error {
+		return st.Remove(wh)
+	})
+}
+
+// restorePoint returns the backup of certs.d to restore, which must be a directory
+func (s certsdStore) restorePoint(where string) (string, error) {
+	dir, err := certManageDir(s.root, filepath.Join(s.app, "certs.d"))
+	if err != nil {
+		return "", err
+	}
+	src, err := findRestorePoint(s, s.root, dir, where)
+	if err != nil || src == "" {
+		return src, err
+	}
+	return src, checkBackupDir(s.root, src, certutil.FromFile, "*.key")
+}
+
+// Restore replaces certs.d with a backup of it, or a host's directory when where
+// is a backup of one host.
+func (s certsdStore) Restore(where string) error {
+	if s.err != nil {
+		return s.err
+	}
+	src, err := s.restorePoint(where)
+	if err != nil {
+		if where == "" {
+			return err
+		}
+		// where might be the backup of a host
+		stores, herr := matchRestore(s.Stores(), where)
+		if herr != nil {
+			return err
+		}
+		return stores[0].Restore(where)
+	}
+	if src == "" {
+		return fmt.Errorf("no %s backup found", s.app)
+	}
+	return mirrorBackup(src, s.dir)
+}
+
+// listBackup reads the CAs of every registry in a backup of certs.d
+func (s certsdStore) listBackup(backup string, opts *ListOptions) ([]*x509.Certificate, error) {
+	s.dir = backup
+	return s.List(opts)
+}
+
+func (s certsdStore) each(fn func(Store) error) error {
+	stores := s.Stores()
+	for i := range stores {
+		if err := fn(stores[i].Store); err != nil {
+			return fmt.Errorf("%s: %v", stores[i].Name, err)
+		}
+	}
+	return nil
+}
+
+func (s certsdHostStore) dir() string {
+	return filepath.Join(s.certsd.dir, s.host)
+}
+
+// backupDir returns the directory under ~/.cert-manage backups of the host are kept in
+func (s certsdHostStore) backupDir() string {
+	return filepath.Join(s.certsd.app, "hosts", s.host)
+}
+
+// Add writes each certificate the host doesn't trust into its ca.crt
+func (s certsdHostStore) Add(certs []*x509.Certificate) error {
+	if s.certsd.err != nil {
+		return s.certsd.err
+	}
+	existing, err := s.List(nil)
+	if err != nil {
+		return err
+	}
+	pool := certutil.Pool{}
+	pool.AddCertificates(existing)
+	before := len(pool.GetCertificates())
+	pool.AddCertificates(certs)
+	if len(pool.GetCertificates()) == before {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.dir(), 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir(), certsdCAFilename)
+	kept, err := s.read(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	created := os.IsNotExist(err)
+
+	kept = append(kept, pool.GetCertificates()[before:]...)
+	if err := certutil.ToFile(path, kept); err != nil {
+		return err
+	}
+	if created {
+		// certs.d is readable by everyone, like the system's CA bundles
+		return os.Chmod(path, 0644)
+	}
+	return nil
+}
+
+// Backup copies the host's directory into ~/.cert-manage/<app>/hosts/<host>/<timestamp>/
+func (s certsdHostStore) Backup() error {
+	if s.certsd.err != nil {
+		return s.certsd.err
+	}
+	if !file.Exists(s.dir()) {
+		return fmt.Errorf("%s not found", s.dir())
+	}
+	dir, err := getCertManageDir(s.certsd.root, filepath.Join(s.backupDir(), fmt.Sprintf("%d", time.Now().Unix())))
+	if err != nil {
+		return err
+	}
+	return file.MirrorDir(s.dir(), dir)
+}
+
+// creates returns the host's directory (or its certs.d) when it doesn't exist yet,
+// which Add creates
+func (s certsdHostStore) creates() string {
+	if s.certsd.err != nil {
+		return ""
+	}
+	return missingParent(s.dir())
+}
+
+func (s certsdHostStore) kind() string {
+	return s.backupDir()
+}
+
+func (s certsdHostStore) GetLatestBackup() (string, error) {
+	dir, err := certManageDir(s.certsd.root, s.backupDir())
+	if err != nil {
+		return "", fmt.Errorf("GetLatestBackup: error getting %s backup directory, err=%v", s.certsd.app, err)
+	}
+	return findLatestBackup(dir)
+}
+
+func (s certsdHostStore) GetInfo() *Info {
+	return &Info{
+		Name:     s.certsd.title,
+		Location: unroot(s.certsd.root, s.dir()),
+	}
+}
+
+// List returns the CAs in each *.crt file of the host
+func (s certsdHostStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
+	files, err := s.caFiles()
+	if err != nil {
+		return nil, err
+	}
+	pool := certutil.Pool{}
+	for i := range files {
+		certs, err := s.read(files[i])
+		if err != nil {
+			return nil, err
+		}
+		pool.AddCertificates(certs)
+	}
+	return opts.filter(pool.GetCertificates())
+}
+
+// Remove rewrites each *.crt file with only its whitelisted certificates, files
+// left without any are deleted. Client certificates (*.cert) are left alone.
+func (s certsdHostStore) Remove(wh whitelist.Whitelist) error {
+	if s.certsd.err != nil {
+		return s.certsd.err
+	}
+	files, err := s.caFiles()
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		certs, err := s.read(files[i])
+		if err != nil {
+			return err
+		}
+		var kept []*x509.Certificate
+		for j := range certs {
+			if wh.Matches(certs[j]) {
+				kept = append(kept, certs[j])
+			}
+		}
+		switch {
+		case len(kept) == len(certs):
+			continue
+		case len(kept) == 0:
+			err = os.Remove(files[i])
+		default:
+			err = replaceFile(files[i], kept)
+		}
+		if err != nil {
+			return err
+		}
+		if debug {
+			fmt.Printf("store/%s: removed %d certificates from %s\n", s.certsd.app, len(certs)-len(kept), files[i])
+		}
+	}
+	return nil
+}
+
+// restorePoint returns the backup of the host's directory to restore, which must
+// be a directory
+func (s certsdHostStore) restorePoint(where string) (string, error) {
+	dir, err := certManageDir(s.certsd.root, s.backupDir())
+	if err != nil {
+		return "", err
+	}
+	src, err := findRestorePoint(s, s.certsd.root, dir, where)
+	if err != nil || src == "" {
+		return src, err
+	}
+	return src, checkBackupDir(s.certsd.root, src, certutil.FromFile, "*.key")
+}
+
+// Restore replaces the host's directory with the latest backup
+func (s certsdHostStore) Restore(where string) error {
+	if s.certsd.err != nil {
+		return s.certsd.err
+	}
+	src, err := s.restorePoint(where)
+	if err != nil {
+		return err
+	}
+	if src == "" {
+		return fmt.Errorf("no %s backup of %s found", s.certsd.app, s.host)
+	}
+	return mirrorBackup(src, s.dir())
+}
+
+// listBackup reads the CAs in a backup of the host's directory
+func (s certsdHostStore) listBackup(backup string, opts *ListOptions) ([]*x509.Certificate, error) {
+	s.certsd.dir, s.host = filepath.Dir(backup), filepath.Base(backup)
+	return s.List(opts)
+}
+
+// caFiles returns the CA files (*.crt) in the host's directory
+func (s certsdHostStore) caFiles() ([]string, error) {
+	fis, err := ioutil.ReadDir(s.dir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []string
+	for i := range fis {
+		if !fis[i].IsDir() && strings.HasSuffix(fis[i].Name(), ".crt") {
+			out = append(out, filepath.Join(s.dir(), fis[i].Name()))
+		}
+	}
+	return out, nil
+}
+
+// read returns the certificates in path, following symlinks under root
+func (s certsdHostStore) read(path string) ([]*x509.Certificate, error) {
+	path, err := file.ResolveLinks(s.certsd.root, path)
+	if err != nil {
+		return nil, err
+	}
+	return certutil.FromFile(path)
+}
+
+// mirrorBackup replaces the directory dst with the backup directory src
+func mirrorBackup(src, dst string) error {
+	if s, err := os.Stat(src); err != nil || !s.IsDir() {
+		return errors.New("backup must be a directory")
+	}
+	if debug {
+		fmt.Printf("store: restoring %s from %s\n", dst, src)
+	}
+	return replaceDir(src, dst)
+}
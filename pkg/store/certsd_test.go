@@ -0,0 +1,125 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+func TestStoreCertsd__docker(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("certs.d is unix specific")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	opts := &Options{Root: root}
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// registry.example.com has a CA bundle and a client certificate, which isn't a CA
+	certsd := filepath.Join(root, "etc", "docker", "certs.d")
+	registry := filepath.Join(certsd, "registry.example.com")
+	if err := os.MkdirAll(registry, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := certutil.ToFile(filepath.Join(registry, "ca.crt"), certs[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := certutil.ToFile(filepath.Join(registry, "client.cert"), certs[2:3]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Add a CA for a new registry
+	st, err := ForApp("docker:localhost:5000", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Add(certs[3:4]); err != nil {
+		t.Fatal(err)
+	}
+	if !file.Exists(filepath.Join(certsd, "localhost:5000", "ca.crt")) {
+		t.Fatal("expected ca.crt to be written")
+	}
+	if _, err := ForApp("docker:../etc", opts); err == nil {
+		t.Error("expected error")
+	}
+
+	ms, ok := DockerStore(opts).(MultiStore)
+	if !ok {
+		t.Fatal("expected a MultiStore")
+	}
+	stores := ms.Stores()
+	if len(stores) != 2 {
+		t.Fatalf("got %d stores", len(stores))
+	}
+	if stores[0].Name != "docker:localhost:5000" || stores[0].Scope != "localhost:5000" {
+		t.Errorf("got %#v", stores[0])
+	}
+	found, err := stores[1].List(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Errorf("got %d certificates for registry.example.com", len(found))
+	}
+
+	// Backup the whole tree, then whitelist
+	if err := ms.Backup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Remove(whitelist.FromCertificates([]*x509.Certificate{certs[0]})); err != nil {
+		t.Fatal(err)
+	}
+	found, err = ms.List(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Errorf("got %d certificates after whitelist", len(found))
+	}
+	if file.Exists(filepath.Join(certsd, "localhost:5000", "ca.crt")) {
+		t.Error("expected empty ca.crt to be removed")
+	}
+	if !file.Exists(filepath.Join(registry, "client.cert")) {
+		t.Error("client certificate was removed")
+	}
+
+	if err := ms.Restore(""); err != nil {
+		t.Fatal(err)
+	}
+	found, err = ms.List(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 3 {
+		t.Errorf("got %d certificates after restore", len(found))
+	}
+}
@@ -35,18 +35,19 @@ var (
 // Docs:
 //   - https://www.chromium.org/Home/chromium-security/root-ca-policy
 //   - https://chromium.googlesource.com/chromium/src/+/master/docs/linux_cert_management.md
-func ChromeStore() Store {
+func ChromeStore(opts *Options) Store {
 	switch runtime.GOOS {
 	case "darwin", "windows":
 		// we need to wrap the platform store and override GetInfo() for
 		// chrome's name/version
 		return chromeStore{
-			Platform(),
+			Platform(opts),
 		}
 	case "linux":
-		where := filepath.Join(file.HomeDir(), ".pki/nssdb")
+		root := opts.root()
+		where := filepath.Join(root, file.HomeDir(), ".pki/nssdb")
 		if _, err := os.Stat(where); !os.IsNotExist(err) {
-			return NssStore("chrome", chromeVersion(), where)
+			return newNssStore(root, "chrome", chromeVersion(root), where)
 		}
 	}
 	return emptyStore{}
@@ -59,11 +60,16 @@ type chromeStore struct {
 func (s chromeStore) GetInfo() *Info {
 	return &Info{
 		Name:    "Chrome",
-		Version: chromeVersion(),
+		Version: chromeVersion(""),
 	}
 }
 
-func chromeVersion() string {
+// chromeVersion returns the installed version of Chrome. Binaries aren't ran
+// from an alternate root, so nothing is returned in that case.
+func chromeVersion(root string) string {
+	if root != "" {
+		return ""
+	}
 	for i := range chromeBinaryPaths {
 		path := chromeBinaryPaths[i]
 		if file.Exists(path) {
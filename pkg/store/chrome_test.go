@@ -22,7 +22,7 @@ import (
 )
 
 func TestStoreChrome__info(t *testing.T) {
-	ver := chromeVersion()
+	ver := chromeVersion("")
 
 	// OSX
 	if file.Exists(`/Applications/Google Chrome.app`) {
@@ -0,0 +1,104 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+var (
+	curl = bundleApp{
+		name:    "curl",
+		title:   "curl",
+		find:    curlBundles,
+		version: curlVersion,
+	}
+
+	curlBinaryPaths = []string{
+		"/usr/bin/curl",
+		"/usr/local/bin/curl",
+		"/opt/homebrew/opt/curl/bin/curl",
+	}
+)
+
+// CurlStore returns a Store for the CA bundles curl is given, from CURL_CA_BUNDLE,
+// `cacert` in ~/.curlrc and the default bundle curl was built with (`curl-config --ca`).
+func CurlStore(opts *Options) Store {
+	return curl.store(opts)
+}
+
+func curlBundles(opts *Options) []string {
+	root := opts.root()
+
+	var out []string
+	if env := envPath(root, "CURL_CA_BUNDLE"); env != "" {
+		out = append(out, env)
+	}
+	for _, name := range []string{".curlrc", "_curlrc"} {
+		fd, err := os.Open(filepath.Join(root, file.HomeDir(), name))
+		if err != nil {
+			continue
+		}
+		if cacert := parseCurlrcCacert(fd); cacert != "" {
+			out = append(out, filepath.Join(root, cacert))
+		}
+		fd.Close()
+	}
+	if root == "" {
+		if bs, err := exec.Command("curl-config", "--ca").Output(); err == nil {
+			if ca := strings.TrimSpace(string(bs)); ca != "" {
+				out = append(out, ca)
+			}
+		}
+	}
+	return out
+}
+
+// parseCurlrcCacert reads the `cacert` option from a .curlrc file. Options are
+// written without their leading dashes (which are allowed) and are separated from
+// their value by whitespace, = or :, for example:
+//
+//	cacert = "/etc/ssl/corp-ca.pem"
+//	--cacert /etc/ssl/corp-ca.pem
+func parseCurlrcCacert(r io.Reader) string {
+	cacert := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "--")
+		value := strings.TrimPrefix(line, "cacert")
+		if value == line || value == "" || !strings.ContainsRune(" \t=:", rune(value[0])) {
+			continue
+		}
+		value = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(value), "=:"))
+		cacert = strings.Trim(value, `"'`)
+	}
+	return cacert
+}
+
+// curlVersion returns the installed version of curl
+func curlVersion(root string) string {
+	// returns "curl 8.4.0 (x86_64-pc-linux-gnu) libcurl/8.4.0 ..."
+	if parts := strings.Fields(binaryVersion(root, curlBinaryPaths, "--version", "curl")); len(parts) > 0 {
+		return parts[0]
+	}
+	return ""
+}
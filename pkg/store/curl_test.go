@@ -0,0 +1,35 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStoreCurl__parseCurlrcCacert(t *testing.T) {
+	cases := map[string]string{
+		"silent\ncacert = \"/etc/ssl/corp-ca.pem\"\n": "/etc/ssl/corp-ca.pem",
+		"--cacert /etc/ssl/corp-ca.pem":               "/etc/ssl/corp-ca.pem",
+		"cacert:/etc/ssl/corp-ca.pem":                 "/etc/ssl/corp-ca.pem",
+		"capath /etc/ssl/certs":                       "",
+		"cacertx /etc/ssl/corp-ca.pem":                "",
+	}
+	for curlrc, expected := range cases {
+		if cacert := parseCurlrcCacert(strings.NewReader(curlrc)); cacert != expected {
+			t.Errorf("got %q, expected %q (from %q)", cacert, expected, curlrc)
+		}
+	}
+}
@@ -73,7 +73,8 @@ var (
 // https://developer.apple.com/legacy/library/documentation/Darwin/Reference/ManPages/man1/security.1.html
 type darwinStore struct{}
 
-func platform() Store {
+// platform returns the system store, alternate roots aren't supported on darwin
+func platform(_ *Options) Store {
 	return darwinStore{}
 }
 
@@ -111,7 +112,7 @@ func (s darwinStore) Add(certs []*x509.Certificate) error {
 
 func (s darwinStore) Backup() error {
 	// setup (and create) backup (parent) dir
-	parent, err := getCertManageDir(fmt.Sprintf("%s/%d", darwinBackupDir, time.Now().Unix()))
+	parent, err := getCertManageDir("", fmt.Sprintf("%s/%d", darwinBackupDir, time.Now().Unix()))
 	if err != nil {
 		return fmt.Errorf("Backup: error getting cert-manage dir, err=%v", err)
 	}
@@ -131,7 +132,7 @@ func (s darwinStore) Backup() error {
 	if err != nil {
 		return fmt.Errorf("Backup: error reading installed certs from %s, err=%v", loginKeychain, err)
 	}
-	dir, err := getCertManageDir(filepath.Join(parent, fname))
+	dir, err := getCertManageDir("", filepath.Join(parent, fname))
 	if err != nil {
 		return fmt.Errorf("Backup: error getting cert-manage dir, err=%v", err)
 	}
@@ -150,12 +151,30 @@ func (s darwinStore) Backup() error {
 	return nil
 }
 
+// restorePoint returns the backup to restore, which must be a directory of exported
+// keychains like darwin/$time/login.keychain/
+func (s darwinStore) restorePoint(where string) (string, error) {
+	dir, err := certManageDir("", darwinBackupDir)
+	if err != nil {
+		return "", err
+	}
+	src, err := findRestorePoint(s, "", dir, where)
+	if err != nil || src == "" {
+		return src, err
+	}
+	return src, checkBackupDir("", src, certutil.FromFile)
+}
+
+func (s darwinStore) kind() string {
+	return darwinBackupDir
+}
+
 func (s darwinStore) GetLatestBackup() (string, error) {
-	dir, err := getCertManageDir(darwinBackupDir)
+	dir, err := certManageDir("", darwinBackupDir)
 	if err != nil {
 		return "", fmt.Errorf("Restore: error reading backup dir, err=%v", err)
 	}
-	return getLatestBackup(dir)
+	return findLatestBackup(dir)
 }
 
 func (s darwinStore) GetInfo() *Info {
@@ -194,7 +213,6 @@ func (s darwinStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
 				pool.Add(installed[i])
 			}
 		} else {
-			// TODO(adam): support opts.Revoked and opts.Expired
 			if opts.Untrusted {
 				pool.Add(installed[i])
 			}
@@ -203,7 +221,7 @@ func (s darwinStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
 			fmt.Printf("store/darwin: %s trust status after verify-cert: %v\n", certutil.GetHexSHA256Fingerprint(*installed[i]), trusted)
 		}
 	}
-	return pool.GetCertificates(), nil
+	return opts.filter(pool.GetCertificates())
 }
 
 // certTrustedWithSystem calls out to `verify-cert` of the `security` cli tool to check
@@ -329,6 +347,11 @@ func (s darwinStore) Remove(wh whitelist.Whitelist) error {
 	return nil
 }
 
+// distrusts is true as Remove marks certificates as 'Never Trust'
+func (s darwinStore) distrusts() bool {
+	return true
+}
+
 // defaultCertTrustPolicy removes any extra trust policies from a cert and
 // deletes it from the System keychain, which we use as an override.
 func defaultCertTrustPolicy(certPath string, cert *x509.Certificate) error {
@@ -414,10 +437,14 @@ func getAppleRootCertificates() []*appleRoot {
 // Restore operates mostly on the system keychain and removes certificates which we've
 // explicitly marked "Never Trust". This re-enables them for use by apps.
 //
-// Afterwords, the login keychain is restored from its most recent backup.
-//
-// TODO(adam): `where` needs to be a directory with properly exported certs from keychain files
+// Afterwords, the login keychain is restored from its most recent backup, or the
+// backup `where` refers to.
 func (s darwinStore) Restore(where string) error {
+	backup, err := s.restorePoint(where)
+	if err != nil {
+		return fmt.Errorf("Restore: %v", err)
+	}
+
 	// Grab apple provided system root, this is our baseline
 	roots, err := readInstalledCerts(systemRootCertificates)
 	if err != nil {
@@ -482,11 +509,13 @@ func (s darwinStore) Restore(where string) error {
 	//
 	// Grab the filenames under our backup directory (e.g. login.keychain/$sha1.crt), read the cert
 	// and verify it's matching the sha1 filename and compare against the already installed certs.
-	dir, err := s.GetLatestBackup()
-	if err != nil {
-		return fmt.Errorf("Restore: error getting latest backup, err=%v", err)
+	if backup == "" {
+		if debug {
+			fmt.Println("store/darwin: no backup found, skipping login keychain")
+		}
+		return nil
 	}
-	dir = filepath.Join(dir, "login.keychain")
+	dir := filepath.Join(backup, "login.keychain")
 	if debug {
 		fmt.Printf("store/darwin: Found backup dir at %s\n", dir)
 	}
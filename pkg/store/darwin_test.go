@@ -46,7 +46,7 @@ func TestStoreDarwin__SystemCertPool(t *testing.T) {
 func TestStoreDarwin__Backup(t *testing.T) {
 	t.Skip("darwin support is wip")
 
-	dir, err := getCertManageDir(darwinBackupDir)
+	dir, err := getCertManageDir("", darwinBackupDir)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,7 +55,7 @@ func TestStoreDarwin__Backup(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	s := platform()
+	s := platform(nil)
 	err = s.Backup()
 	if err != nil && !os.IsNotExist(err) {
 		t.Fatal(err)
@@ -0,0 +1,142 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+// RevocationSource reports if certificates have been revoked or distrusted, it's
+// used by ListOptions.Revoked.
+type RevocationSource interface {
+	Revoked(cert *x509.Certificate) (bool, error)
+}
+
+// RevocationSources reports a certificate as revoked if any of its sources do
+type RevocationSources []RevocationSource
+
+func (rs RevocationSources) Revoked(cert *x509.Certificate) (bool, error) {
+	for i := range rs {
+		revoked, err := rs[i].Revoked(cert)
+		if revoked || err != nil {
+			return revoked, err
+		}
+	}
+	return false, nil
+}
+
+// BlacklistRevocation returns a RevocationSource of the certificates Chromium
+// distrusts, which are never whitelisted. It's the default revocation source.
+func BlacklistRevocation() RevocationSource {
+	return blacklistRevocation{}
+}
+
+type blacklistRevocation struct{}
+
+func (blacklistRevocation) Revoked(cert *x509.Certificate) (bool, error) {
+	return whitelist.Blacklisted(cert), nil
+}
+
+// CRLRevocation returns a RevocationSource of the certificates listed in the CRL
+// files at paths, which are PEM or DER encoded. A certificate is revoked if it's
+// listed by a CRL from its issuer.
+//
+// CRL signatures aren't checked, the files are trusted as given.
+func CRLRevocation(paths ...string) (RevocationSource, error) {
+	var out crlRevocation
+	for i := range paths {
+		bs, err := ioutil.ReadFile(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		ders := [][]byte{bs}
+		if block, _ := pem.Decode(bs); block != nil {
+			ders = nil
+			for rest := bs; ; {
+				block, rest = pem.Decode(rest)
+				if block == nil {
+					break
+				}
+				if block.Type == "X509 CRL" {
+					ders = append(ders, block.Bytes)
+				}
+			}
+		}
+		for j := range ders {
+			crl, err := x509.ParseRevocationList(ders[j])
+			if err != nil {
+				return nil, fmt.Errorf("problem reading CRL %s: %v", paths[i], err)
+			}
+			out = append(out, crl)
+		}
+	}
+	return out, nil
+}
+
+type crlRevocation []*x509.RevocationList
+
+func (crls crlRevocation) Revoked(cert *x509.Certificate) (bool, error) {
+	for _, crl := range crls {
+		if string(crl.RawIssuer) != string(cert.RawIssuer) {
+			continue
+		}
+		for _, entry := range crl.RevokedCertificates {
+			if entry.SerialNumber != nil && entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// filter returns the certificates matching any of the Expired, NotYetValid or
+// Revoked options, or certs as is when none are set. Every store applies it to
+// what it lists.
+func (o *ListOptions) filter(certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	if o == nil || (!o.Expired && !o.NotYetValid && !o.Revoked) {
+		return certs, nil
+	}
+	revocation := o.Revocation
+	if revocation == nil {
+		revocation = BlacklistRevocation()
+	}
+
+	now := time.Now()
+	out := make([]*x509.Certificate, 0)
+	for i := range certs {
+		switch {
+		case o.Expired && now.After(certs[i].NotAfter):
+		case o.NotYetValid && now.Before(certs[i].NotBefore):
+		case o.Revoked:
+			revoked, err := revocation.Revoked(certs[i])
+			if err != nil {
+				return nil, err
+			}
+			if !revoked {
+				continue
+			}
+		default:
+			continue
+		}
+		out = append(out, certs[i])
+	}
+	return out, nil
+}
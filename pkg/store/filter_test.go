@@ -0,0 +1,150 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createCert returns a certificate valid between notBefore and notAfter, signed
+// by parent (or itself when nil)
+func createCert(t *testing.T, serial int64, notBefore, notAfter time.Time, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("cert-manage test %d", serial)},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestStore__filter(t *testing.T) {
+	now := time.Now()
+	valid, _ := createCert(t, 1, now.Add(-time.Hour), now.Add(time.Hour), nil, nil)
+	expired, _ := createCert(t, 2, now.Add(-2*time.Hour), now.Add(-time.Hour), nil, nil)
+	future, _ := createCert(t, 3, now.Add(time.Hour), now.Add(2*time.Hour), nil, nil)
+	certs := []*x509.Certificate{valid, expired, future}
+
+	cases := []struct {
+		opts     *ListOptions
+		expected []*x509.Certificate
+	}{
+		{nil, certs},
+		{&ListOptions{Trusted: true}, certs},
+		{&ListOptions{Expired: true}, []*x509.Certificate{expired}},
+		{&ListOptions{NotYetValid: true}, []*x509.Certificate{future}},
+		{&ListOptions{Expired: true, NotYetValid: true}, []*x509.Certificate{expired, future}},
+		{&ListOptions{Revoked: true}, []*x509.Certificate{}},
+	}
+	for i := range cases {
+		out, err := cases[i].opts.filter(certs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != len(cases[i].expected) {
+			t.Errorf("%d: got %d certificates, expected %d", i, len(out), len(cases[i].expected))
+			continue
+		}
+		for j := range out {
+			if !out[j].Equal(cases[i].expected[j]) {
+				t.Errorf("%d: unexpected certificate %d", i, j)
+			}
+		}
+	}
+}
+
+func TestStore__CRLRevocation(t *testing.T) {
+	now := time.Now()
+	ca, caKey := createCert(t, 1, now.Add(-time.Hour), now.Add(time.Hour), nil, nil)
+	revoked, _ := createCert(t, 2, now.Add(-time.Hour), now.Add(time.Hour), ca, caKey)
+	kept, _ := createCert(t, 3, now.Add(-time.Hour), now.Add(time.Hour), ca, caKey)
+
+	// An unrelated CA's certificate with the revoked serial isn't revoked
+	other, _ := createCert(t, 2, now.Add(-time.Hour), now.Add(time.Hour), nil, nil)
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: now.Add(-time.Hour),
+		NextUpdate: now.Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: revoked.SerialNumber, RevocationTime: now},
+		},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "cert-manage-crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.crl")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := CRLRevocation(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &ListOptions{
+		Revoked:    true,
+		Revocation: RevocationSources{BlacklistRevocation(), src},
+	}
+	out, err := opts.filter([]*x509.Certificate{ca, revoked, kept, other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || !out[0].Equal(revoked) {
+		t.Errorf("got %d revoked certificates", len(out))
+	}
+
+	if _, err := CRLRevocation(filepath.Join(dir, "missing.crl")); err == nil {
+		t.Error("expected error")
+	}
+}
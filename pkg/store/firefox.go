@@ -14,50 +14,41 @@
 
 package store
 
-import (
-	"os/exec"
-	"path/filepath"
-	"strings"
-
-	"github.com/adamdecaf/cert-manage/pkg/file"
-)
-
 var (
-	firefoxProfileSuggestions = []string{
-		filepath.Join(file.HomeDir(), ".mozilla/firefox/*.default"),                              // Ubuntu
-		filepath.Join(file.HomeDir(), "/Library/Application Support/Firefox/Profiles/*.default"), // Darwin
+	firefox = mozillaApp{
+		name:  "firefox",
+		title: "Firefox",
+		profileRoots: []string{
+			".mozilla/firefox",                              // Linux
+			"snap/firefox/common/.mozilla/firefox",          // Ubuntu (Snap)
+			".var/app/org.mozilla.firefox/.mozilla/firefox", // Flatpak
+			"Library/Application Support/Firefox",           // Darwin
+			"AppData/Roaming/Mozilla/Firefox",               // Windows
+		},
+		version: firefoxVersion,
 	}
 
 	firefoxBinaryPaths = []string{
-		"/usr/bin/firefox", // Ubuntu
+		"/usr/bin/firefox",  // Ubuntu
+		"/snap/bin/firefox", // Ubuntu (Snap)
 		`/Applications/Firefox.app/Contents/MacOS/firefox`, // Darwin
 	}
 )
 
-// FirefoxStore returns a Mozilla Firefox implementation of Store
-func FirefoxStore() Store {
-	for i := range firefoxProfileSuggestions {
-		matches, _ := filepath.Glob(firefoxProfileSuggestions[i])
-		for j := range matches {
-			if containsCertdb(matches[j]) {
-				return NssStore("firefox", firefoxVersion(), matches[j])
-			}
-		}
-	}
-	return emptyStore{}
+// FirefoxStore returns a Mozilla Firefox implementation of Store. Every profile
+// with an NSS database is operated on, each is available from Stores().
+func FirefoxStore(opts *Options) Store {
+	return firefox.store(opts)
 }
 
-func firefoxVersion() string {
-	for i := range firefoxBinaryPaths {
-		path := firefoxBinaryPaths[i]
-		if file.Exists(path) {
-			// returns "Mozilla Firefox 57.0.3"
-			out, err := exec.Command(path, "-v").CombinedOutput()
-			if err == nil && len(out) > 0 {
-				r := strings.NewReplacer("Mozilla Firefox", "")
-				return strings.TrimSpace(r.Replace(string(out)))
-			}
-		}
-	}
-	return ""
+// FirefoxProfiles returns every Firefox profile, from profiles.ini files (including
+// Snap and Flatpak installs) or by searching for *.default directories.
+func FirefoxProfiles(opts *Options) []MozillaProfile {
+	return firefox.profiles(opts)
+}
+
+// firefoxVersion returns the installed version of Firefox
+func firefoxVersion(root string) string {
+	// returns "Mozilla Firefox 57.0.3"
+	return binaryVersion(root, firefoxBinaryPaths, "-v", "Mozilla Firefox")
 }
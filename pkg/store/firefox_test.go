@@ -15,13 +15,18 @@
 package store
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/adamdecaf/cert-manage/pkg/file"
 )
 
 func TestStoreFirefix__info(t *testing.T) {
-	info := FirefoxStore().GetInfo()
+	info := FirefoxStore(nil).GetInfo()
 
 	// OSX
 	if file.Exists("/Applications/Firefox.app") {
@@ -49,3 +54,119 @@ func TestStoreFirefix__info(t *testing.T) {
 		}
 	}
 }
+
+func TestStoreFirefox__parseMozillaProfiles(t *testing.T) {
+	ini := `[Install4F96D1932A9F858E]
+Default=x8b3kuo3.default-release
+Locked=1
+
+[Profile1]
+Name=default
+IsRelative=1
+Path=qj1sm3fm.default
+Default=1
+
+[Profile0]
+Name=work
+IsRelative=0
+Path=/home/user/firefox-work
+
+[General]
+StartWithLastProfile=1
+Version=2
+`
+	profiles, err := parseMozillaProfiles("/mnt", "/mnt/home/user/.mozilla/firefox", strings.NewReader(ini))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("got %d profiles: %v", len(profiles), profiles)
+	}
+	if p := profiles[0]; p.Name != "default" || p.Dir != filepath.Join("/mnt/home/user/.mozilla/firefox", "qj1sm3fm.default") {
+		t.Errorf("got %#v", p)
+	}
+	if p := profiles[1]; p.Name != "work" || p.Dir != filepath.Join("/mnt", "home", "user", "firefox-work") {
+		t.Errorf("got %#v", p)
+	}
+}
+
+func TestStoreFirefox__profiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("profile paths are unix specific")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-firefox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// A regular install with profiles.ini and a Snap install without one, both
+	// have the same "default-release" profile directory
+	home := filepath.Join(root, file.HomeDir())
+	dirs := []string{
+		filepath.Join(home, ".mozilla", "firefox", "abcd1234.default-release"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox", "abcd1234.default-release"),
+	}
+	for i := range dirs {
+		if err := os.MkdirAll(dirs[i], 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.CopyFile(filepath.Join("..", "..", "testdata", "cert9.db"), filepath.Join(dirs[i], "cert9.db")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ini := "[Profile0]\nName=default-release\nIsRelative=1\nPath=abcd1234.default-release\n"
+	if err := ioutil.WriteFile(filepath.Join(home, ".mozilla", "firefox", "profiles.ini"), []byte(ini), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{Root: root}
+	ms, ok := FirefoxStore(opts).(MultiStore)
+	if !ok {
+		t.Fatal("expected a MultiStore")
+	}
+	stores := ms.Stores()
+	if len(stores) != 2 {
+		t.Fatalf("got %d stores", len(stores))
+	}
+	if stores[0].Name != "firefox:default-release" {
+		t.Errorf("got %s", stores[0].Name)
+	}
+	if expected := "firefox:" + unroot(root, dirs[1]); stores[1].Name != expected {
+		t.Errorf("got %s, expected %s", stores[1].Name, expected)
+	}
+
+	// Each profile has its own backups
+	for i := range stores {
+		if dir := stores[i].Store.(nssStore).backupDir; dir != filepath.Join("firefox", backupID(root, dirs[i])) {
+			t.Errorf("got backup dir %s", dir)
+		}
+	}
+	if stores[0].Store.(nssStore).backupDir == stores[1].Store.(nssStore).backupDir {
+		t.Error("profiles share a backup dir")
+	}
+
+	// Certificates from every profile are listed
+	certs, err := ms.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 5 {
+		t.Errorf("got %d certificates", len(certs))
+	}
+
+	// Pick a profile by name or directory
+	for _, where := range []string{"default-release", unroot(root, dirs[1])} {
+		st, err := ForApp("firefox:"+where, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", where, err)
+		}
+		if _, ok := st.(nssStore); !ok {
+			t.Errorf("%s: got %T", where, st)
+		}
+	}
+	if _, err := ForApp("firefox:missing", opts); err == nil {
+		t.Error("expected error")
+	}
+}
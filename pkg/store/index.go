@@ -0,0 +1,298 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+const backupIndexFilename = "backups.json"
+
+// BackupIndex is the catalog of backups taken with cert-manage, which is kept
+// in ~/.cert-manage/backups.json (or ~/.cert-manage/roots/<root>/backups.json for
+// an Options.Root).
+type BackupIndex struct {
+	root string
+	path string
+
+	backups []Backup
+}
+
+// Backup is a backup of one store recorded in the BackupIndex
+type Backup struct {
+	// ID is a short hex identifier for the backup
+	ID string `json:"id"`
+
+	// Store is the name of the store which was backed up, e.g. java:/usr/lib/jvm/java-17
+	Store string `json:"store"`
+
+	// Host is the hostname of the machine the backup was taken on
+	Host    string    `json:"host"`
+	Created time.Time `json:"created"`
+
+	// Path is the backup file or directory, relative to ~/.cert-manage
+	Path string `json:"path"`
+
+	// Certificates is how many certificates the store trusted when backed up
+	Certificates int `json:"certificates"`
+
+	// Size is the total size (in bytes) of the backup's files
+	Size int64 `json:"size"`
+
+	// Files maps each file (relative to Path) to its hex encoded SHA256 checksum.
+	// Symlinks are recorded as "-> <target>".
+	Files map[string]string `json:"files"`
+}
+
+// OpenBackupIndex reads the backup index, which is empty if no backups have been
+// recorded yet.
+func OpenBackupIndex(opts *Options) (*BackupIndex, error) {
+	root := opts.root()
+	dir, err := certManageDir(root, "")
+	if err != nil {
+		return nil, err
+	}
+	idx := &BackupIndex{
+		root: root,
+		path: filepath.Join(dir, backupIndexFilename),
+	}
+	bs, err := ioutil.ReadFile(idx.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &idx.backups); err != nil {
+		return nil, fmt.Errorf("problem reading %s: %v", idx.path, err)
+	}
+	return idx, nil
+}
+
+// Backups returns every recorded backup, newest first
+func (x *BackupIndex) Backups() []Backup {
+	out := make([]Backup, len(x.backups))
+	copy(out, x.backups)
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Created.After(out[j].Created)
+	})
+	return out
+}
+
+// Location returns where the backup's file or directory is
+func (x *BackupIndex) Location(b Backup) string {
+	return filepath.Join(filepath.Dir(x.path), b.Path)
+}
+
+// Find returns the backup whose ID is, or starts with, id
+func (x *BackupIndex) Find(id string) (Backup, error) {
+	var found []Backup
+	for i := range x.backups {
+		if x.backups[i].ID == id {
+			return x.backups[i], nil
+		}
+		if id != "" && strings.HasPrefix(x.backups[i].ID, id) {
+			found = append(found, x.backups[i])
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Backup{}, fmt.Errorf("backup %q not found", id)
+	case 1:
+		return found[0], nil
+	}
+	return Backup{}, fmt.Errorf("backup %q is ambiguous, it matches %d backups", id, len(found))
+}
+
+// Record adds the latest backup of a store to the index, along with how many
+// certificates the store trusts and a checksum of each file.
+func (x *BackupIndex) Record(name string, s Store) (Backup, error) {
+	latest, err := s.GetLatestBackup()
+	if err != nil {
+		return Backup{}, err
+	}
+	if latest == "" {
+		return Backup{}, ErrNoBackupMade
+	}
+	rel, err := filepath.Rel(filepath.Dir(x.path), latest)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return Backup{}, fmt.Errorf("backup %s isn't under %s", latest, filepath.Dir(x.path))
+	}
+
+	certs, err := s.List(&ListOptions{
+		Trusted: true,
+	})
+	if err != nil {
+		return Backup{}, err
+	}
+	files, size, err := checksumBackup(latest)
+	if err != nil {
+		return Backup{}, err
+	}
+	host, _ := os.Hostname()
+
+	id := sha256.Sum256([]byte(name + "\x00" + rel))
+	b := Backup{
+		ID:           hex.EncodeToString(id[:])[:8],
+		Store:        name,
+		Host:         host,
+		Created:      time.Now().UTC(),
+		Path:         rel,
+		Certificates: len(certs),
+		Size:         size,
+		Files:        files,
+	}
+
+	// A backup taken again within the same second replaces the earlier one
+	kept := x.backups[:0]
+	for i := range x.backups {
+		if x.backups[i].ID != b.ID {
+			kept = append(kept, x.backups[i])
+		}
+	}
+	x.backups = append(kept, b)
+	return b, x.write()
+}
+
+// Verify checks the backup's files still match their checksums, the returned
+// error describes each file which is missing, changed or was added.
+func (x *BackupIndex) Verify(b Backup) error {
+	files, _, err := checksumBackup(x.Location(b))
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for name, sum := range b.Files {
+		found, ok := files[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is missing", name))
+		case found != sum:
+			problems = append(problems, fmt.Sprintf("%s has changed", name))
+		}
+	}
+	for name := range files {
+		if _, ok := b.Files[name]; !ok {
+			problems = append(problems, fmt.Sprintf("%s was added", name))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("backup %s failed verification: %s", b.ID, strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// Prune deletes the backups of each store which are older than olderThan (when
+// non-zero), other than the newest keep backups. The pruned backups are returned,
+// and with dryRun they're only returned.
+func (x *BackupIndex) Prune(keep int, olderThan time.Duration, dryRun bool) ([]Backup, error) {
+	if keep < 0 {
+		return nil, errors.New("the number of backups to keep can't be negative")
+	}
+	if keep == 0 && olderThan <= 0 {
+		return nil, errors.New("refusing to prune every backup, keep some or only prune older backups")
+	}
+	now := time.Now()
+	seen := make(map[string]int)
+
+	var pruned []Backup
+	for _, b := range x.Backups() {
+		seen[b.Store]++
+		if seen[b.Store] <= keep || olderThan > 0 && now.Sub(b.Created) < olderThan {
+			continue
+		}
+		pruned = append(pruned, b)
+	}
+	if dryRun || len(pruned) == 0 {
+		return pruned, nil
+	}
+
+	for i := range pruned {
+		if err := os.RemoveAll(x.Location(pruned[i])); err != nil {
+			return nil, err
+		}
+		for j := range x.backups {
+			if x.backups[j].ID == pruned[i].ID {
+				x.backups = append(x.backups[:j], x.backups[j+1:]...)
+				break
+			}
+		}
+	}
+	return pruned, x.write()
+}
+
+func (x *BackupIndex) write() error {
+	if _, err := getCertManageDir(x.root, ""); err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(x.backups, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(x.path, bs, file.TempFilePermissions)
+}
+
+// checksumBackup returns the checksum of each file in a backup (file or directory)
+// by its path relative to the backup, along with their total size.
+func checksumBackup(path string) (map[string]string, int64, error) {
+	out := make(map[string]string)
+	var size int64
+	err := filepath.Walk(path, func(where string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(path, where)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			name = filepath.Base(path) // the backup is a file
+		}
+		name = filepath.ToSlash(name)
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(where)
+			if err != nil {
+				return err
+			}
+			out[name] = "-> " + target
+			return nil
+		}
+		sum, err := file.SHA256(where)
+		if err != nil {
+			return err
+		}
+		out[name] = sum
+		size += info.Size()
+		return nil
+	})
+	return out, size, err
+}
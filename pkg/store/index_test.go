@@ -0,0 +1,133 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+func TestStore__BackupIndex(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "ca.pem")
+	if err := certutil.ToFile(path, certs); err != nil {
+		t.Fatal(err)
+	}
+	st := python.bundle(root, "", path)
+	opts := &Options{Root: root}
+
+	idx, err := OpenBackupIndex(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Backups()) != 0 {
+		t.Errorf("expected no backups, got %v", idx.Backups())
+	}
+	if _, err := idx.Record("python:/ca.pem", st); err != ErrNoBackupMade {
+		t.Errorf("expected ErrNoBackupMade, got %v", err)
+	}
+
+	// Record a backup, and another one taken a second later
+	if err := st.Backup(); err != nil {
+		t.Fatal(err)
+	}
+	first, err := idx.Record("python:/ca.pem", st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Certificates != len(certs) || len(first.Files) != 1 || first.Size == 0 || first.Host == "" {
+		t.Errorf("got %#v", first)
+	}
+	latest, err := st.GetLatestBackup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ts int64
+	if _, err := fmt.Sscanf(filepath.Base(latest), "ca.pem-%d.bck", &ts); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.CopyFile(latest, filepath.Join(filepath.Dir(latest), fmt.Sprintf("ca.pem-%d.bck", ts+1))); err != nil {
+		t.Fatal(err)
+	}
+	idx.backups[0].Created = idx.backups[0].Created.Add(-48 * time.Hour)
+	second, err := idx.Record("python:/ca.pem", st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index is saved and backups are found by their ID (or a prefix of it)
+	idx, err = OpenBackupIndex(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backups := idx.Backups(); len(backups) != 2 || backups[0].ID != second.ID || backups[1].ID != first.ID {
+		t.Fatalf("got %#v", backups)
+	}
+	if b, err := idx.Find(first.ID[:4]); err != nil || b.ID != first.ID {
+		t.Errorf("got %v err=%v", b.ID, err)
+	}
+	if _, err := idx.Find("zzzz"); err == nil {
+		t.Error("expected error")
+	}
+
+	// Changed backups fail verification
+	if err := idx.Verify(first); err != nil {
+		t.Error(err)
+	}
+	if err := ioutil.WriteFile(idx.Location(first), []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Verify(first); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Errorf("expected changed backup, got %v", err)
+	}
+
+	// Pruning
+	if _, err := idx.Prune(0, 0, false); err == nil {
+		t.Error("expected error pruning every backup")
+	}
+	if pruned, err := idx.Prune(0, 72*time.Hour, false); err != nil || len(pruned) != 0 {
+		t.Errorf("got %d pruned, err=%v", len(pruned), err)
+	}
+	if pruned, err := idx.Prune(1, 0, true); err != nil || len(pruned) != 1 || !file.Exists(idx.Location(first)) {
+		t.Errorf("got %d pruned, err=%v", len(pruned), err)
+	}
+	pruned, err := idx.Prune(0, 24*time.Hour, false)
+	if err != nil || len(pruned) != 1 || pruned[0].ID != first.ID {
+		t.Fatalf("got %v err=%v", pruned, err)
+	}
+	if file.Exists(idx.Location(first)) || !file.Exists(idx.Location(second)) {
+		t.Error("expected only the first backup to be deleted")
+	}
+	if backups := idx.Backups(); len(backups) != 1 || backups[0].ID != second.ID {
+		t.Errorf("got %#v", backups)
+	}
+}
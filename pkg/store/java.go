@@ -26,11 +26,14 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/adamdecaf/cert-manage/pkg/certutil"
 	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/keystore"
 	"github.com/adamdecaf/cert-manage/pkg/whitelist"
 )
 
@@ -39,18 +42,107 @@ var (
 	javaCertManageDir       = "java"
 )
 
-type javaStore struct{}
+// javaStore manages the `cacerts` keystore of a single JDK (or JRE)
+type javaStore struct {
+	ktool keytool
+}
 
-// JavaStore returns an implementation of Store for Java certificate stores
+// JavaStore returns an implementation of Store for Java certificate stores. Every
+// installed JDK is found and operated on, each is available from Stores().
 //
 // Docs:
 // - https://docs.oracle.com/cd/E19830-01/819-4712/ablqw/index.html
 // - https://www.sslshopper.com/article-most-common-java-keytool-keystore-commands.html
-func JavaStore() Store {
-	return javaStore{}
+//
+// The keystore path and password can be given in Options, otherwise they're read
+// from CERT_MANAGE_JAVA_KEYSTORE, CERT_MANAGE_JAVA_STOREPASS (or _STOREPASS_FILE)
+// and the javax.net.ssl.trustStore properties in JAVA_TOOL_OPTIONS.
+func JavaStore(opts *Options) Store {
+	kt := ktool.under(opts.root()).configure(opts)
+	installs := kt.installs()
+
+	out := multiStore{
+		app:  javaCertManageDir,
+		root: kt.root,
+		info: Info{
+			Name: "Java",
+		},
+		stores: make([]NamedStore, len(installs)),
+		none:   errNoJava,
+	}
+	for i := range installs {
+		st := javaStore{ktool: installs[i]}
+		out.stores[i] = NamedStore{
+			Name:  st.name(),
+			Store: st,
+		}
+	}
+	return out
+}
+
+// javaStoreAt returns the Store for the JDK installed at where, which is
+// addressed as `-app java:<where>`. where can also be a keystore file.
+func javaStoreAt(opts *Options, where string) (Store, error) {
+	kt := ktool.under(opts.root()).configure(opts)
+	kt.javahome = filepath.Join(kt.root, where)
+	kt.javaInstallPaths = nil
+	kt.javaHomeGlobs = nil
+	kt.keystore = ""
+	if s, err := os.Stat(kt.javahome); err == nil && s.Mode().IsRegular() {
+		kt.keystore, kt.javahome = kt.javahome, ""
+	}
+	return javaStore{ktool: kt}, nil
+}
+
+// name returns the `-app` name of the JDK, its home directory (or keystore) as
+// seen from inside of root
+func (s javaStore) name() string {
+	if s.ktool.javahome == "" {
+		return fmt.Sprintf("java:%s", unroot(s.ktool.root, s.ktool.keystore))
+	}
+	return fmt.Sprintf("java:%s", unroot(s.ktool.root, s.ktool.javahome))
+}
+
+// backupDir returns the directory (under ~/.cert-manage) backups of this JDK's keystore
+// are kept in. JDKs are told apart by their keystore's path, which doesn't change
+// with JAVA_HOME.
+func (s javaStore) backupDir() (string, error) {
+	kpath, err := s.ktool.getKeystorePath()
+	if err != nil {
+		return "", err
+	}
+	if real, err := file.ResolvePath(s.ktool.root, kpath); err == nil {
+		kpath = real
+	}
+	return filepath.Join(javaCertManageDir, backupID(s.ktool.root, kpath)), nil
 }
 
 func (s javaStore) Add(certs []*x509.Certificate) error {
+	ks, kpath, err := s.readKeystore()
+	if err != nil {
+		if debug {
+			fmt.Printf("store/java: falling back to keytool, err=%v\n", err)
+		}
+		return s.addWithKeytool(certs)
+	}
+
+	added := 0
+	for i := range certs {
+		if ks.Contains(certs[i]) {
+			continue
+		}
+		if err := ks.Add(javaAlias(ks, certs[i]), certs[i]); err != nil {
+			return err
+		}
+		added++
+	}
+	if added > 0 {
+		return s.writeKeystore(ks, kpath)
+	}
+	return nil
+}
+
+func (s javaStore) addWithKeytool(certs []*x509.Certificate) error {
 	dir, err := ioutil.TempDir("", "cert-manage-java-add")
 	if err != nil {
 		return err
@@ -68,7 +160,7 @@ func (s javaStore) Add(certs []*x509.Certificate) error {
 
 		// this replace is too simplistic
 		alias := strings.Replace(certutil.StringifyPKIXName(certs[i].Subject), " ", "_", -1)
-		err = ktool.addCertificate(path, alias)
+		err = s.ktool.addCertificate(path, alias)
 		if err != nil {
 			return err
 		}
@@ -76,12 +168,29 @@ func (s javaStore) Add(certs []*x509.Certificate) error {
 	return nil
 }
 
+// javaAlias returns an unused alias for cert, based on its subject
+func javaAlias(ks *keystore.Keystore, cert *x509.Certificate) string {
+	fp := certutil.GetHexSHA256Fingerprint(*cert)
+	alias := strings.ToLower(strings.Replace(certutil.StringifyPKIXName(cert.Subject), " ", "_", -1))
+	if alias == "" {
+		return fp
+	}
+	if ks.HasAlias(alias) {
+		return fmt.Sprintf("%s_%s", alias, fp[:8])
+	}
+	return alias
+}
+
 func (s javaStore) Backup() error {
-	kpath, err := ktool.getKeystorePath()
+	kpath, err := s.ktool.getKeystorePath()
+	if err != nil {
+		return err
+	}
+	dir, err := s.backupDir()
 	if err != nil {
 		return err
 	}
-	dir, err := getCertManageDir(javaCertManageDir)
+	dir, err = getCertManageDir(s.ktool.root, dir)
 	if err != nil {
 		return err
 	}
@@ -93,23 +202,87 @@ func (s javaStore) Backup() error {
 	return file.CopyFile(kpath, dst)
 }
 
+func (s javaStore) kind() string {
+	return javaCertManageDir
+}
+
 func (s javaStore) GetLatestBackup() (string, error) {
-	dir, err := getCertManageDir(javaCertManageDir)
+	dir, err := s.backupDir()
+	if err == nil {
+		dir, err = certManageDir(s.ktool.root, dir)
+	}
 	if err != nil {
 		return "", fmt.Errorf("GetLatestBackup: error reading java backup directory, err=%v", err)
 	}
-	return getLatestBackup(dir)
+	latest, err := findLatestBackup(dir)
+	if err != nil || latest != "" {
+		return latest, err
+	}
+	return s.legacyBackup("")
+}
+
+// legacyBackup returns the latest backup made before backups were kept per JDK,
+// which are in ~/.cert-manage/java itself (e.g. java/cacerts-1514764800.bck). If
+// where is given it's returned when it's one of those backups, otherwise "".
+func (s javaStore) legacyBackup(where string) (string, error) {
+	kpath, err := s.ktool.getKeystorePath()
+	if err != nil {
+		return "", err
+	}
+	dir, err := certManageDir(s.ktool.root, javaCertManageDir)
+	if err != nil {
+		return "", err
+	}
+	prefix := filepath.Base(kpath) + "-"
+	if where != "" {
+		path, err := filepath.Abs(where)
+		if err != nil || filepath.Dir(path) != dir || !strings.HasPrefix(filepath.Base(path), prefix) {
+			return "", err
+		}
+		return path, nil
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	var latest string
+	for i := range fis {
+		name := fis[i].Name()
+		if fis[i].Mode().IsRegular() && strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".bck") && name > latest {
+			latest = name
+		}
+	}
+	if latest == "" {
+		return "", nil
+	}
+	return filepath.Join(dir, latest), nil
 }
 
 func (s javaStore) GetInfo() *Info {
+	kpath, _ := s.ktool.getKeystorePath()
 	return &Info{
-		Name:    "Java",
-		Version: s.version(),
+		Name:     "Java",
+		Version:  s.version(),
+		Location: kpath,
 	}
 }
 
+// version returns the installed version of java. Binaries aren't ran from an
+// alternate root, so nothing is returned in that case.
 func (s javaStore) version() string {
-	out, err := exec.Command("java", "-version").CombinedOutput()
+	if s.ktool.root != "" {
+		return ""
+	}
+	bin := "java"
+	if s.ktool.javahome != "" {
+		if path := filepath.Join(s.ktool.javahome, "bin", "java"); file.Exists(path) {
+			bin = path
+		}
+	}
+	out, err := exec.Command(bin, "-version").CombinedOutput()
 	if err != nil {
 		return ""
 	}
@@ -123,22 +296,60 @@ func (s javaStore) version() string {
 // List returns the x509 Certificates from a java TrustStore
 //
 // Note: keytool does not offer the ability to "untrust" a certificate
-func (s javaStore) List(_ *ListOptions) ([]*x509.Certificate, error) {
-	return ktool.getCertificates()
+func (s javaStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
+	ks, _, err := s.readKeystore()
+	if err != nil {
+		if debug {
+			fmt.Printf("store/java: falling back to keytool, err=%v\n", err)
+		}
+		certs, err := s.ktool.getCertificates()
+		if err != nil {
+			return nil, err
+		}
+		return opts.filter(certs)
+	}
+	return opts.filter(ks.Certificates())
 }
 
 func (s javaStore) Remove(wh whitelist.Whitelist) error {
-	kpath, err := ktool.getKeystorePath()
+	ks, kpath, err := s.readKeystore()
+	if err != nil {
+		if debug {
+			fmt.Printf("store/java: falling back to keytool, err=%v\n", err)
+		}
+		return s.removeWithKeytool(wh)
+	}
+
+	removed := 0
+	for _, entry := range ks.Entries() {
+		if wh.Matches(entry.Certificate) {
+			continue
+		}
+		if ks.Delete(entry.Alias) {
+			removed++
+			if debug {
+				fmt.Printf("store/java: deleted %s from %s\n", entry.Alias, kpath)
+			}
+		}
+	}
+	if removed > 0 {
+		return s.writeKeystore(ks, kpath)
+	}
+	return nil
+}
+
+func (s javaStore) removeWithKeytool(wh whitelist.Whitelist) error {
+	kpath, err := s.ktool.getKeystorePath()
 	if err != nil {
 		return err
 	}
 
-	certs, err := ktool.getCertificates()
+	certs, err := s.ktool.getCertificates()
 	if err != nil {
 		return err
 	}
 
-	shortCerts, err := ktool.getShortCerts()
+	shortCerts, err := s.ktool.getShortCerts()
 	if err != nil {
 		return err
 	}
@@ -159,7 +370,7 @@ func (s javaStore) Remove(wh whitelist.Whitelist) error {
 			if shortCerts[i].matches(certs[j]) {
 				// then remove if it's not whitelisted
 				if !wh.Matches(certs[j]) {
-					err = ktool.deleteCertificate(kpath, shortCerts[i].alias)
+					err = s.ktool.deleteCertificate(kpath, shortCerts[i].alias)
 					if err != nil {
 						return err
 					}
@@ -182,21 +393,118 @@ func (s javaStore) Remove(wh whitelist.Whitelist) error {
 	return nil
 }
 
+// restorePoint returns the keystore to restore, which must be readable as a keystore
+func (s javaStore) restorePoint(where string) (string, error) {
+	dir, err := s.backupDir()
+	if err == nil {
+		dir, err = certManageDir(s.ktool.root, dir)
+	}
+	if err != nil {
+		return "", err
+	}
+	src, err := s.legacyBackup(where)
+	if err == nil && src == "" {
+		src, err = findRestorePoint(s, s.ktool.root, dir, where)
+		if err == nil && src == "" && where == "" {
+			src, err = s.legacyBackup("")
+		}
+	}
+	if err != nil || src == "" {
+		return src, err
+	}
+	if fi, err := os.Stat(src); err != nil || fi.IsDir() {
+		return "", fmt.Errorf("%s isn't a keystore backup, it must be a file", src)
+	}
+	if _, err := s.decodeKeystore(src); err != nil {
+		return "", fmt.Errorf("%s isn't a keystore backup: %v", src, err)
+	}
+	return src, nil
+}
+
 func (s javaStore) Restore(where string) error {
-	src, err := s.GetLatestBackup()
+	src, err := s.restorePoint(where)
 	if err != nil {
 		return err
 	}
+	if src == "" {
+		return errors.New("no java backup found")
+	}
 
 	// Get destination path
-	dst, err := ktool.getKeystorePath()
+	dst, err := s.ktool.getKeystorePath()
 	if err != nil {
 		return err
 	}
 
 	// This sometimes requires escalated permissions because the `cacerts` file
 	// is often owned by root or have perms like: -rw-rw-r-- (which prevent global writes)
-	return file.SudoCopyFile(src, dst)
+	return file.SudoReplaceFile(src, dst)
+}
+
+// listBackup reads the certificates of a backup of the keystore
+func (s javaStore) listBackup(backup string, opts *ListOptions) ([]*x509.Certificate, error) {
+	ks, err := s.decodeKeystore(backup)
+	if err != nil {
+		return nil, err
+	}
+	return opts.filter(ks.Certificates())
+}
+
+var errNoJava = errors.New("store/java: never found java and/or keystore path")
+
+// readKeystore decodes the `cacerts` keystore, returning its path as well
+func (s javaStore) readKeystore() (*keystore.Keystore, string, error) {
+	kpath, err := s.ktool.getKeystorePath()
+	if err != nil {
+		return nil, "", err
+	}
+	ks, err := s.decodeKeystore(kpath)
+	return ks, kpath, err
+}
+
+// decodeKeystore reads the keystore at kpath with the store's password
+func (s javaStore) decodeKeystore(kpath string) (*keystore.Keystore, error) {
+	bs, err := ioutil.ReadFile(kpath)
+	if err != nil {
+		return nil, err
+	}
+	pass, err := s.ktool.password()
+	if err != nil {
+		return nil, err
+	}
+	ks, err := keystore.Decode(bs, pass)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading %s: %v", kpath, err)
+	}
+	return ks, nil
+}
+
+// writeKeystore encodes ks and replaces the keystore at kpath with it
+func (s javaStore) writeKeystore(ks *keystore.Keystore, kpath string) error {
+	pass, err := s.ktool.password()
+	if err != nil {
+		return err
+	}
+	bs, err := ks.Encode(pass)
+	if err != nil {
+		return err
+	}
+
+	fd, err := ioutil.TempFile("", "cert-manage-java-keystore")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+	if _, err := fd.Write(bs); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+
+	// `cacerts` is often owned by root, see Restore
+	return file.SudoReplaceFile(fd.Name(), kpath)
 }
 
 type keytool struct {
@@ -206,9 +514,216 @@ type keytool struct {
 	// Where is java installed, default is to look at JAVA_HOME
 	javaInstallPaths []string
 
+	// Globs of directories which each might be a java install, e.g. /usr/lib/jvm/*
+	javaHomeGlobs []string
+
 	// Under java install path where is the `cacerts` keystore located?
 	// This changes on each platform...
 	relativeKeystorePaths []string
+
+	// root is the filesystem root the above paths are under, empty for "/"
+	root string
+
+	// keystore, when set, is used rather than searching for `cacerts`
+	keystore string
+
+	// storepass (or the contents of storepassFile) is the keystore password,
+	// defaultKeystorePassword is used when neither are set
+	storepass     string
+	storepassFile string
+}
+
+// under returns a copy of the keytool with each path resolved under root
+func (k keytool) under(root string) keytool {
+	if root == "" {
+		return k
+	}
+	if k.javahome != "" {
+		k.javahome = filepath.Join(root, k.javahome)
+	}
+	paths := make([]string, len(k.javaInstallPaths))
+	for i := range k.javaInstallPaths {
+		paths[i] = filepath.Join(root, k.javaInstallPaths[i])
+	}
+	k.javaInstallPaths = paths
+	globs := make([]string, len(k.javaHomeGlobs))
+	for i := range k.javaHomeGlobs {
+		globs[i] = filepath.Join(root, k.javaHomeGlobs[i])
+	}
+	k.javaHomeGlobs = globs
+	k.root = root
+	return k
+}
+
+// javaOptionsEnv are the environment variables the JVM reads options from,
+// later ones take precedence
+var javaOptionsEnv = []string{"JAVA_TOOL_OPTIONS", "JDK_JAVA_OPTIONS", "_JAVA_OPTIONS"}
+
+// configure sets the keystore path and password from opts, falling back to the
+// CERT_MANAGE_JAVA_* environment variables and then the javax.net.ssl.trustStore
+// properties the JVM is started with.
+func (k keytool) configure(opts *Options) keytool {
+	props := javaSystemProperties()
+
+	if opts != nil && opts.JavaKeystore != "" {
+		k.keystore = filepath.Join(k.root, opts.JavaKeystore)
+	} else if k.root == "" {
+		// The environment describes this machine, not an alternate root
+		if v := os.Getenv("CERT_MANAGE_JAVA_KEYSTORE"); v != "" {
+			k.keystore = v
+		} else if v := props["javax.net.ssl.trustStore"]; v != "" && v != "NONE" {
+			k.keystore = v
+		}
+	}
+
+	switch {
+	case opts != nil && opts.JavaStorePass != "":
+		k.storepass = opts.JavaStorePass
+	case opts != nil && opts.JavaStorePassFile != "":
+		k.storepassFile = opts.JavaStorePassFile
+	case os.Getenv("CERT_MANAGE_JAVA_STOREPASS") != "":
+		k.storepass = os.Getenv("CERT_MANAGE_JAVA_STOREPASS")
+	case os.Getenv("CERT_MANAGE_JAVA_STOREPASS_FILE") != "":
+		k.storepassFile = os.Getenv("CERT_MANAGE_JAVA_STOREPASS_FILE")
+	case props["javax.net.ssl.trustStorePassword"] != "":
+		k.storepass = props["javax.net.ssl.trustStorePassword"]
+	}
+	return k
+}
+
+// password returns the keystore password
+func (k keytool) password() (string, error) {
+	if k.storepassFile != "" {
+		bs, err := ioutil.ReadFile(k.storepassFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read keystore password: %v", err)
+		}
+		return strings.TrimRight(string(bs), "\r\n"), nil
+	}
+	if k.storepass != "" {
+		return k.storepass, nil
+	}
+	return defaultKeystorePassword, nil
+}
+
+// javaSystemProperties returns the -Dname=value properties set in javaOptionsEnv
+func javaSystemProperties() map[string]string {
+	props := make(map[string]string)
+	for i := range javaOptionsEnv {
+		for _, arg := range splitJavaOptions(os.Getenv(javaOptionsEnv[i])) {
+			if !strings.HasPrefix(arg, "-D") {
+				continue
+			}
+			if kv := strings.SplitN(arg[2:], "=", 2); len(kv) == 2 {
+				props[kv[0]] = kv[1]
+			}
+		}
+	}
+	return props
+}
+
+// splitJavaOptions splits JAVA_TOOL_OPTIONS into arguments on whitespace, where
+// quotes can be used to include whitespace in an argument.
+func splitJavaOptions(s string) []string {
+	var out []string
+	var cur strings.Builder
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				out = append(out, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		out = append(out, cur.String())
+	}
+	return out
+}
+
+// sdkmanJavaGlob matches the JDKs installed with sdkman (https://sdkman.io)
+func sdkmanJavaGlob() string {
+	home := file.HomeDir()
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".sdkman", "candidates", "java", "*")
+}
+
+// installs returns a keytool for each java install with a keystore. They're found
+// from JAVA_HOME, javaInstallPaths and then javaHomeGlobs. Installs sharing a
+// keystore (e.g. Debian links each JDK's cacerts to /etc/ssl/certs/java/cacerts)
+// are only returned once.
+func (k keytool) installs() []keytool {
+	if k.keystore != "" {
+		return []keytool{k}
+	}
+
+	var homes []string
+	if k.javahome != "" {
+		homes = append(homes, k.javahome)
+	}
+	for i := range k.javaInstallPaths {
+		dir, err := k.expandSymlink(k.javaInstallPaths[i])
+		if err != nil || dir == "" {
+			dir = k.javaInstallPaths[i]
+		}
+		homes = append(homes, dir)
+	}
+	for i := range k.javaHomeGlobs {
+		if k.javaHomeGlobs[i] == "" || k.javaHomeGlobs[i] == k.root {
+			continue
+		}
+		matches, err := filepath.Glob(k.javaHomeGlobs[i])
+		if err != nil {
+			continue
+		}
+		sort.Strings(matches)
+		homes = append(homes, matches...)
+	}
+
+	var out []keytool
+	seen := make(map[string]bool)
+	for i := range homes {
+		kt := keytool{
+			javahome:              filepath.Clean(homes[i]),
+			relativeKeystorePaths: k.relativeKeystorePaths,
+			root:                  k.root,
+			storepass:             k.storepass,
+			storepassFile:         k.storepassFile,
+		}
+		kpath, err := kt.getKeystorePath()
+		if err != nil {
+			continue
+		}
+		if real, err := file.ResolvePath(k.root, kpath); err == nil {
+			kpath = real
+		}
+		if seen[kpath] {
+			continue
+		}
+		seen[kpath] = true
+		if debug {
+			fmt.Printf("store/java: found %s with keystore %s\n", kt.javahome, kpath)
+		}
+		out = append(out, kt)
+	}
+	return out
 }
 
 // addCertificate installs a certificate into the truststore
@@ -221,15 +736,19 @@ func (k keytool) addCertificate(where string, alias string) error {
 	if err != nil {
 		return err
 	}
+	pass, err := k.password()
+	if err != nil {
+		return err
+	}
 
-	args := append([]string{
+	args := []string{
 		"-importcert",
 		"-keystore", kpath,
-		"-storepass", defaultKeystorePassword,
+		"-storepass", pass,
 		"-file", where,
 		"-alias", alias,
 		"-noprompt",
-	})
+	}
 	cmd := exec.Command("keytool", args...)
 
 	var stdout bytes.Buffer
@@ -264,6 +783,9 @@ func (k keytool) expandSymlink(p string) (string, error) {
 		return "", nil // path doesn't exist
 	}
 	if bin != "" {
+		if k.root != "" && filepath.IsAbs(bin) {
+			bin = filepath.Join(k.root, bin)
+		}
 		dir := strings.TrimSuffix(bin, filepath.Join("bin", "java"))
 		if debug {
 			fmt.Printf("store/java: expanded %s to %s and stripped to %s\n", p, bin, dir)
@@ -275,7 +797,10 @@ func (k keytool) expandSymlink(p string) (string, error) {
 
 func (k keytool) getKeystorePath() (string, error) {
 	kpath := k.javahome
-	if kpath == "" {
+	switch {
+	case k.keystore != "":
+		kpath = k.keystore
+	case kpath == "":
 		for i := range k.javaInstallPaths {
 			// Sometimes javaInstallPaths can be a symlink, if so expand it and use that
 			installPath := k.javaInstallPaths[i]
@@ -307,10 +832,12 @@ func (k keytool) getKeystorePath() (string, error) {
 				break
 			}
 		}
-	} else {
+	default:
 		// We've got JAVA_HOME, but need to add the relative path to `cacerts`
+		home := kpath
+		kpath = ""
 		for i := range k.relativeKeystorePaths {
-			where := filepath.Join(kpath, k.relativeKeystorePaths[i])
+			where := filepath.Join(home, k.relativeKeystorePaths[i])
 			if file.Exists(where) {
 				kpath = where
 				if debug {
@@ -322,7 +849,7 @@ func (k keytool) getKeystorePath() (string, error) {
 	}
 	// We never found a path which had a cacerts file
 	if kpath == "" {
-		return "", errors.New("store/java: never found java and/or keystore path")
+		return "", errNoJava
 	}
 
 	// Verify it's a non-empty file
@@ -343,10 +870,14 @@ func (k keytool) getShortCertsRaw(extraArgs ...string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	pass, err := k.password()
+	if err != nil {
+		return nil, err
+	}
 
 	args := append([]string{
 		"-list",
-		"-storepass", defaultKeystorePassword,
+		"-storepass", pass,
 		"-keystore", kpath,
 	}, extraArgs...)
 	cmd := exec.Command("keytool", args...)
@@ -487,13 +1018,17 @@ func (k keytool) deleteCertificate(kpath, alias string) error {
 		fmt.Printf("WARNING: alias %s cannot be currently removed from the keystore.\n", alias)
 		return nil
 	}
+	pass, err := k.password()
+	if err != nil {
+		return err
+	}
 
 	args := []string{
 		"keytool",
 		"-delete",
 		"-alias", alias,
 		"-keystore", kpath,
-		"-storepass", defaultKeystorePassword,
+		"-storepass", pass,
 	}
 
 	var cmd *exec.Cmd
@@ -509,7 +1044,7 @@ func (k keytool) deleteCertificate(kpath, alias string) error {
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		if debug {
 			fmt.Printf("Command was: %s\n", strings.Join(cmd.Args, " "))
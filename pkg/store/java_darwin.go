@@ -37,6 +37,12 @@ func init() {
 	ktool = keytool{
 		javahome:         os.Getenv("JAVA_HOME"),
 		javaInstallPaths: []string{full},
+		javaHomeGlobs: []string{
+			"/Library/Java/JavaVirtualMachines/*/Contents/Home",
+			"/usr/local/opt/openjdk*/libexec/openjdk.jdk/Contents/Home",    // homebrew
+			"/opt/homebrew/opt/openjdk*/libexec/openjdk.jdk/Contents/Home", // homebrew (arm64)
+			sdkmanJavaGlob(),
+		},
 		relativeKeystorePaths: []string{
 			"/lib/security/cacerts",
 			"/jre/lib/security/cacerts", // OSX 10.10.1
@@ -26,6 +26,13 @@ var ktool = keytool{
 	javaInstallPaths: []string{
 		"/etc/alternatives/java",
 	},
+	javaHomeGlobs: []string{
+		"/usr/lib/jvm/*", // Debian, Fedora, Arch, etc
+		"/usr/java/*",    // Oracle RPMs
+		"/opt/java/*",    // e.g. eclipse-temurin docker images
+		"/opt/jdk*",
+		sdkmanJavaGlob(),
+	},
 	relativeKeystorePaths: []string{
 		"/lib/security/cacerts",
 		"/jre/lib/security/cacerts", // alpine
@@ -18,7 +18,13 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/keystore"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
 )
 
 func TestStoreJava__expandSymlink(t *testing.T) {
@@ -118,14 +124,326 @@ func TestStoreJava__info(t *testing.T) {
 		t.Skip("java isn't installed / can't be found")
 	}
 
-	info := JavaStore().GetInfo()
-	if info == nil {
-		t.Fatal("nil Info")
+	stores := JavaStore(nil).(MultiStore).Stores()
+	if len(stores) == 0 {
+		t.Fatal("no JDKs found")
+	}
+	for i := range stores {
+		info := stores[i].GetInfo()
+		if info == nil {
+			t.Fatalf("%s: nil Info", stores[i].Name)
+		}
+		if info.Name == "" {
+			t.Errorf("%s: blank Name", stores[i].Name)
+		}
+		if info.Version == "" {
+			t.Errorf("%s: blank Version", stores[i].Name)
+		}
+	}
+}
+
+func TestStoreJava__installs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("JDK layout uses symlinks")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-java")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// java-11 and java-17 have their own keystores, while java-17-link is
+	// another name for java-17 and broken has no keystore
+	jvm := filepath.Join(root, "usr", "lib", "jvm")
+	for _, name := range []string{"java-11", "java-17"} {
+		dir := filepath.Join(jvm, name, "lib", "security")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.CopyFile(filepath.Join("..", "..", "testdata", "truststore.jks"), filepath.Join(dir, "cacerts")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("java-17", filepath.Join(jvm, "java-17-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(jvm, "broken"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	kt := keytool{
+		javaHomeGlobs:         []string{"/usr/lib/jvm/*", ""},
+		relativeKeystorePaths: []string{"lib/security/cacerts"},
+	}
+	installs := kt.under(root).installs()
+	if len(installs) != 2 {
+		t.Fatalf("expected 2 installs, got %d: %v", len(installs), installs)
+	}
+
+	var stores []javaStore
+	for i := range installs {
+		stores = append(stores, javaStore{ktool: installs[i]})
+	}
+	if stores[0].name() != "java:/usr/lib/jvm/java-11" || stores[1].name() != "java:/usr/lib/jvm/java-17" {
+		t.Errorf("unexpected names: %s, %s", stores[0].name(), stores[1].name())
+	}
+
+	// Each JDK is backed up separately
+	dir, err := stores[1].backupDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base := filepath.Base(dir); base != "usr_lib_jvm_java-17_lib_security_cacerts" {
+		t.Errorf("unexpected backup dir: %s", dir)
+	}
+}
+
+func TestStoreJava__forApp(t *testing.T) {
+	st, err := ForApp("java:/usr/lib/jvm/java-17", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, ok := st.(javaStore)
+	if !ok {
+		t.Fatalf("unexpected store %T", st)
+	}
+	if js.name() != "java:/usr/lib/jvm/java-17" {
+		t.Errorf("got %s", js.name())
+	}
+
+	if _, err := ForApp("java:", nil); err == nil {
+		t.Error("expected error without a path")
+	}
+	if _, err := ForApp("openssl:/etc/ssl", nil); err == nil {
+		t.Error("openssl doesn't support picking an install")
+	}
+}
+
+func TestStoreJava__keystore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("keystore updates copy files with cp")
+	}
+
+	for _, name := range []string{"truststore.jks", "truststore-aes.p12"} {
+		dir, err := ioutil.TempDir("", "cert-manage-java")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		kpath := filepath.Join(dir, "cacerts")
+		if err := file.CopyFile(filepath.Join("..", "..", "testdata", name), kpath); err != nil {
+			t.Fatal(err)
+		}
+		st := javaStore{
+			ktool: keytool{
+				javahome:              dir,
+				relativeKeystorePaths: []string{"cacerts"},
+			},
+		}
+
+		before, err := st.List(&ListOptions{Trusted: true})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		example, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "example.crt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := st.Add(example); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := st.Remove(whitelist.FromCertificates(example)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		after, err := st.List(&ListOptions{Trusted: true})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(after) != 1 || !after[0].Equal(example[0]) {
+			t.Errorf("%s: expected only example.crt after whitelist, got %d certs (from %d)", name, len(after), len(before))
+		}
+	}
+}
+
+func TestStoreJava__splitJavaOptions(t *testing.T) {
+	args := splitJavaOptions(` -Xmx1g  -Djavax.net.ssl.trustStore="/opt/my certs/trust.jks" -Dother='a b'`)
+	expected := []string{"-Xmx1g", "-Djavax.net.ssl.trustStore=/opt/my certs/trust.jks", "-Dother=a b"}
+	if len(args) != len(expected) {
+		t.Fatalf("got %q", args)
+	}
+	for i := range args {
+		if args[i] != expected[i] {
+			t.Errorf("got %q, expected %q", args[i], expected[i])
+		}
+	}
+}
+
+func TestStoreJava__configure(t *testing.T) {
+	t.Setenv("CERT_MANAGE_JAVA_KEYSTORE", "")
+	t.Setenv("CERT_MANAGE_JAVA_STOREPASS", "")
+	t.Setenv("CERT_MANAGE_JAVA_STOREPASS_FILE", "")
+	t.Setenv("JDK_JAVA_OPTIONS", "")
+	t.Setenv("_JAVA_OPTIONS", "")
+	t.Setenv("JAVA_TOOL_OPTIONS", "-Djavax.net.ssl.trustStore=/etc/trust.jks -Djavax.net.ssl.trustStorePassword=hint")
+
+	// JVM options are used by default
+	kt := keytool{}.configure(nil)
+	if kt.keystore != "/etc/trust.jks" {
+		t.Errorf("got keystore %q", kt.keystore)
+	}
+	if pass, _ := kt.password(); pass != "hint" {
+		t.Errorf("got password %q", pass)
+	}
+
+	// then our own environment variables
+	t.Setenv("CERT_MANAGE_JAVA_KEYSTORE", "/etc/env.jks")
+	t.Setenv("CERT_MANAGE_JAVA_STOREPASS", "env")
+	kt = keytool{}.configure(nil)
+	if kt.keystore != "/etc/env.jks" {
+		t.Errorf("got keystore %q", kt.keystore)
+	}
+	if pass, _ := kt.password(); pass != "env" {
+		t.Errorf("got password %q", pass)
+	}
+
+	// and options override everything
+	dir, err := ioutil.TempDir("", "cert-manage-java")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passFile := filepath.Join(dir, "storepass")
+	if err := ioutil.WriteFile(passFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	kt = keytool{}.under(dir).configure(&Options{
+		JavaKeystore:      "/etc/opts.jks",
+		JavaStorePassFile: passFile,
+	})
+	if kt.keystore != filepath.Join(dir, "etc", "opts.jks") {
+		t.Errorf("got keystore %q", kt.keystore)
+	}
+	if pass, _ := kt.password(); pass != "from-file" {
+		t.Errorf("got password %q", pass)
+	}
+
+	// with no configuration the default password is used
+	if pass, _ := (keytool{}).password(); pass != defaultKeystorePassword {
+		t.Errorf("got password %q", pass)
+	}
+}
+
+func TestStoreJava__customKeystore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("keystore updates copy files with cp")
 	}
-	if info.Name == "" {
-		t.Error("blank Name")
+
+	dir, err := ioutil.TempDir("", "cert-manage-java")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Re-encode a keystore with another password
+	bs, err := ioutil.ReadFile(filepath.Join("..", "..", "testdata", "truststore.jks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := keystore.Decode(bs, defaultKeystorePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err = ks.Encode("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kpath := filepath.Join(dir, "trust.jks")
+	if err := ioutil.WriteFile(kpath, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{
+		JavaKeystore:  kpath,
+		JavaStorePass: "s3cret",
+	}
+	st := JavaStore(opts)
+	if info := st.GetInfo(); info.Location != kpath {
+		t.Errorf("got location %q", info.Location)
+	}
+	certs, err := st.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 {
+		t.Errorf("got %d certificates", len(certs))
+	}
+
+	// java:<keystore> picks the file directly
+	st, err = ForApp("java:"+kpath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := st.GetInfo(); info.Location != kpath {
+		t.Errorf("got location %q", info.Location)
+	}
+
+	// the wrong password fails
+	opts.JavaStorePass = "wrong"
+	if _, err := JavaStore(opts).List(&ListOptions{Trusted: true}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestStoreJava__legacyBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-java")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kpath := filepath.Join(dir, "cacerts")
+	if err := file.CopyFile(filepath.Join("..", "..", "testdata", "truststore.jks"), kpath); err != nil {
+		t.Fatal(err)
+	}
+	ms, ok := JavaStore(&Options{JavaKeystore: kpath}).(multiStore)
+	if !ok || len(ms.stores) != 1 {
+		t.Fatalf("unexpected store %#v", ms)
+	}
+	st := ms.stores[0].Store
+
+	// Backups from before they were kept per JDK
+	legacy, err := getCertManageDir("", javaCertManageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cacerts-1514764800.bck", "cacerts-1514764900.bck", "other-1514765000.bck"} {
+		if err := file.CopyFile(kpath, filepath.Join(legacy, name)); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(filepath.Join(legacy, name))
+	}
+
+	latest, err := st.GetLatestBackup()
+	if err != nil || latest != filepath.Join(legacy, "cacerts-1514764900.bck") {
+		t.Errorf("got %q err=%v", latest, err)
+	}
+	if backup, err := RestorePoint(st, ""); err != nil || backup != latest {
+		t.Errorf("got %q err=%v", backup, err)
+	}
+	older := filepath.Join(legacy, "cacerts-1514764800.bck")
+	if backup, err := RestorePoint(st, older); err != nil || backup != older {
+		t.Errorf("got %q err=%v", backup, err)
+	}
+
+	// New backups are preferred
+	if err := st.Backup(); err != nil {
+		t.Fatal(err)
 	}
-	if info.Version == "" {
-		t.Error("blank Version")
+	if latest, err := st.GetLatestBackup(); err != nil || filepath.Dir(latest) == legacy {
+		t.Errorf("got %q err=%v", latest, err)
 	}
 }
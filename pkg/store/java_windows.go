@@ -22,7 +22,16 @@ import (
 )
 
 var ktool = keytool{
-	javahome:              os.Getenv("JAVA_HOME"),
-	javaInstallPaths:      []string{},
-	relativeKeystorePaths: []string{},
+	javahome:         os.Getenv("JAVA_HOME"),
+	javaInstallPaths: []string{},
+	javaHomeGlobs: []string{
+		`C:\Program Files\Java\*`,
+		`C:\Program Files\Eclipse Adoptium\*`,
+		`C:\Program Files\Microsoft\jdk-*`,
+		sdkmanJavaGlob(),
+	},
+	relativeKeystorePaths: []string{
+		`lib\security\cacerts`,
+		`jre\lib\security\cacerts`,
+	},
 }
@@ -0,0 +1,122 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+var (
+	// libreofficeUserDirs are LibreOffice's user profiles (under the home directory)
+	libreofficeUserDirs = []string{
+		".config/libreoffice/4/user",                                     // Linux
+		"snap/libreoffice/current/.config/libreoffice/4/user",            // Ubuntu (Snap)
+		".var/app/org.libreoffice.LibreOffice/config/libreoffice/4/user", // Flatpak
+		"Library/Application Support/LibreOffice/4/user",                 // Darwin
+		"AppData/Roaming/LibreOffice/4/user",                             // Windows
+	}
+
+	libreofficeBinaryPaths = []string{
+		"/usr/bin/libreoffice",                                 // Linux
+		"/snap/bin/libreoffice",                                // Ubuntu (Snap)
+		`/Applications/LibreOffice.app/Contents/MacOS/soffice`, // Darwin
+	}
+)
+
+// LibreOfficeStore returns a Store for the NSS database LibreOffice uses to sign
+// and verify documents. That's the certificate path chosen in its security
+// options, otherwise LibreOffice uses the first Thunderbird or Firefox profile.
+func LibreOfficeStore(opts *Options) Store {
+	root := opts.root()
+	if where := libreofficeCertDir(opts); where != "" {
+		return newNssStore(root, "libreoffice", libreofficeVersion(root), where)
+	}
+	return emptyStore{}
+}
+
+// libreofficeCertDir returns the NSS database LibreOffice is configured with
+func libreofficeCertDir(opts *Options) string {
+	root := opts.root()
+	for i := range libreofficeUserDirs {
+		path := filepath.Join(root, file.HomeDir(), libreofficeUserDirs[i], "registrymodifications.xcu")
+		fd, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		dir, err := parseLibreOfficeCertDir(fd)
+		fd.Close()
+		if err != nil && debug {
+			fmt.Printf("store/libreoffice: error reading %s: %v\n", path, err)
+		}
+		if dir != "" && containsCertdb(filepath.Join(root, dir)) {
+			return filepath.Join(root, dir)
+		}
+	}
+
+	// Fallback to the same profiles LibreOffice searches
+	for _, app := range []mozillaApp{thunderbird, firefox} {
+		profiles := app.profiles(opts)
+		for i := range profiles {
+			if containsCertdb(profiles[i].Dir) {
+				return profiles[i].Dir
+			}
+		}
+	}
+	return ""
+}
+
+// parseLibreOfficeCertDir reads the CertDir setting from a registrymodifications.xcu
+// file, which looks like:
+//
+//	<item oor:path="/org.openoffice.Office.Common/Security/Scripting">
+//	  <prop oor:name="CertDir" oor:op="fuse"><value>/home/user/.pki/nssdb</value></prop>
+//	</item>
+func parseLibreOfficeCertDir(r io.Reader) (string, error) {
+	var items struct {
+		Items []struct {
+			Path string `xml:"path,attr"`
+			Prop struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:"value"`
+			} `xml:"prop"`
+		} `xml:"item"`
+	}
+	if err := xml.NewDecoder(r).Decode(&items); err != nil {
+		return "", err
+	}
+	dir := ""
+	for _, item := range items.Items {
+		if strings.HasSuffix(item.Path, "/Security/Scripting") && item.Prop.Name == "CertDir" {
+			dir = strings.TrimSpace(item.Prop.Value)
+		}
+	}
+	return filepath.FromSlash(dir), nil
+}
+
+// libreofficeVersion returns the installed version of LibreOffice
+func libreofficeVersion(root string) string {
+	// returns "LibreOffice 7.3.7.2 30(Build:2)"
+	if parts := strings.Fields(binaryVersion(root, libreofficeBinaryPaths, "--version", "LibreOffice")); len(parts) > 0 {
+		return parts[0]
+	}
+	return ""
+}
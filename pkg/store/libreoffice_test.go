@@ -0,0 +1,96 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+const libreofficeRegistry = `<?xml version="1.0" encoding="UTF-8"?>
+<oor:items xmlns:oor="http://openoffice.org/2001/registry" xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<item oor:path="/org.openoffice.Office.Common/Misc"><prop oor:name="FirstRun" oor:op="fuse"><value>false</value></prop></item>
+<item oor:path="/org.openoffice.Office.Common/Security/Scripting"><prop oor:name="CertDir" oor:op="fuse"><value>/home/user/.pki/nssdb</value></prop></item>
+</oor:items>
+`
+
+func TestStoreLibreOffice__parseCertDir(t *testing.T) {
+	dir, err := parseLibreOfficeCertDir(strings.NewReader(libreofficeRegistry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != filepath.FromSlash("/home/user/.pki/nssdb") {
+		t.Errorf("got %q", dir)
+	}
+
+	dir, err = parseLibreOfficeCertDir(strings.NewReader(`<oor:items xmlns:oor="http://openoffice.org/2001/registry"></oor:items>`))
+	if err != nil || dir != "" {
+		t.Errorf("got %q, err=%v", dir, err)
+	}
+}
+
+func TestStoreLibreOffice__certDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("profile paths are unix specific")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-libreoffice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	opts := &Options{Root: root}
+
+	if _, ok := LibreOfficeStore(opts).(emptyStore); !ok {
+		t.Error("expected an empty store")
+	}
+
+	// Without a configured path the Firefox profile is used
+	home := filepath.Join(root, file.HomeDir())
+	profile := filepath.Join(home, ".mozilla", "firefox", "abcd1234.default")
+	copyCertdb(t, profile)
+	if dir := libreofficeCertDir(opts); dir != profile {
+		t.Errorf("got %s", dir)
+	}
+
+	// The configured path is preferred
+	nssdb := filepath.Join(home, ".pki", "nssdb")
+	copyCertdb(t, nssdb)
+	userDir := filepath.Join(home, ".config", "libreoffice", "4", "user")
+	if err := os.MkdirAll(userDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	registry := strings.Replace(libreofficeRegistry, "/home/user/.pki/nssdb", unroot(root, nssdb), 1)
+	if err := ioutil.WriteFile(filepath.Join(userDir, "registrymodifications.xcu"), []byte(registry), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st, ok := LibreOfficeStore(opts).(nssStore)
+	if !ok {
+		t.Fatal("expected an NSS store")
+	}
+	if st.foundCertdbLocation != nssdb {
+		t.Errorf("got %s", st.foundCertdbLocation)
+	}
+	if st.backupDir != "libreoffice" {
+		t.Errorf("got backup dir %s", st.backupDir)
+	}
+}
@@ -18,13 +18,11 @@
 package store
 
 import (
-	"bytes"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -35,6 +33,9 @@ import (
 )
 
 type cadir struct {
+	// os-release ID values which use this layout
+	distros []string
+
 	// directory for new/custom certificates
 	add string
 
@@ -44,52 +45,248 @@ type cadir struct {
 	// the filepath containing all certs (optional)
 	all string
 
-	// reload/refresh command
-	refresh string
+	// directory where distrusted certs are written (optional)
+	// If set, Remove() writes untrusted certs here instead of rewriting `dir`.
+	blocklist string
+
+	// ca-certificates.conf listing which certs under `dir` are enabled (optional)
+	// If set, the bundle can be rebuilt without running `refresh`.
+	conf string
+
+	// hooks is the directory of update.d scripts update-ca-certificates runs after
+	// updating the certificates (optional). If any exist they're ran by `refresh`
+	// rather than rebundling natively.
+	hooks string
+
+	// alpineLinks is set when update-ca-certificates names its links in /etc/ssl/certs/
+	// like Alpine's (ca-cert-<name>.pem) rather than Debian's
+	alpineLinks bool
+
+	// reload/refresh command and its arguments
+	refresh []string
+
+	// root is the filesystem root the above paths are under, empty for "/"
+	// The refresh command is ran inside of root.
+	root string
+
+	// err is set when one of the above paths leads outside of root
+	err error
 }
 
 func (ca *cadir) empty() bool {
 	if ca == nil {
 		return false
 	}
-	path, err := filepath.Abs(ca.all)
+	if ca.err != nil {
+		return true
+	}
+	path, err := file.ResolveLinks(ca.root, ca.all)
+	if err != nil {
+		return true
+	}
+	path, err = filepath.Abs(path)
 	return err != nil || !file.Exists(path)
 }
 
+// under returns a copy of the cadir with each path resolved under root, following
+// symlinks inside of root rather than onto the host
+func (ca cadir) under(root string) cadir {
+	if root == "" {
+		return ca
+	}
+	for _, p := range []*string{&ca.add, &ca.dir, &ca.all, &ca.blocklist, &ca.conf, &ca.hooks} {
+		if *p != "" {
+			path, err := file.JoinRoot(root, *p)
+			if err != nil {
+				ca.err = err
+				path = filepath.Join(root, *p)
+			}
+			*p = path
+		}
+	}
+	ca.root = root
+	return ca
+}
+
+// separateAdd is true when the directory for custom certificates isn't under dir,
+// so it's backed up and walked on its own
+func (ca cadir) separateAdd() bool {
+	return ca.add != "" && !within(ca.dir, ca.add)
+}
+
+// unroot returns the path as seen from inside of the cadir's root
+func (ca cadir) unroot(path string) string {
+	return unroot(ca.root, path)
+}
+
 var (
 	// From Go's source, src/crypto/x509/root_linux.go
 	cadirs = []cadir{
 		// Debian/Ubuntu/Gentoo/etc..
 		{
+			distros: []string{"debian", "ubuntu", "gentoo"},
 			add:     "/usr/local/share/ca-certificates",
 			dir:     "/usr/share/ca-certificates",
 			all:     "/etc/ssl/certs/ca-certificates.crt",
-			refresh: "/usr/sbin/update-ca-certificates",
+			conf:    "/etc/ca-certificates.conf",
+			hooks:   "/etc/ca-certificates/update.d",
+			refresh: []string{"/usr/sbin/update-ca-certificates"},
+		},
+		// Fedora/RHEL/CentOS
+		// https://www.unix.com/man-page/centos/8/update-ca-trust/
+		{
+			distros:   []string{"fedora", "rhel", "centos"},
+			add:       "/etc/pki/ca-trust/source/anchors",
+			dir:       "/etc/pki/ca-trust/source",
+			all:       "/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+			blocklist: "/etc/pki/ca-trust/source/blocklist",
+			refresh:   []string{"/usr/bin/update-ca-trust", "extract"},
+		},
+		// Alpine, from the ca-certificates package
+		// https://git.alpinelinux.org/ca-certificates/
+		{
+			distros:     []string{"alpine"},
+			add:         "/usr/local/share/ca-certificates",
+			dir:         "/usr/share/ca-certificates",
+			all:         "/etc/ssl/certs/ca-certificates.crt",
+			conf:        "/etc/ca-certificates.conf",
+			hooks:       "/etc/ca-certificates/update.d",
+			alpineLinks: true,
+			refresh:     []string{"/usr/sbin/update-ca-certificates"},
+		},
+		// Arch Linux, managed by p11-kit's `trust`
+		// https://wiki.archlinux.org/title/User:Grawity/Adding_a_trusted_CA_certificate
+		{
+			distros:   []string{"arch"},
+			add:       "/etc/ca-certificates/trust-source/anchors",
+			dir:       "/etc/ca-certificates/trust-source",
+			all:       "/etc/ssl/certs/ca-certificates.crt",
+			blocklist: "/etc/ca-certificates/trust-source/blocklist",
+			refresh:   []string{"/usr/bin/trust", "extract-compat"},
 		},
 	}
 
+	// os-release(5) file locations, in order of preference
+	osReleasePaths = []string{
+		"/etc/os-release",
+		"/usr/lib/os-release",
+	}
+
 	linuxBackupDir = "linux"
+
+	// linuxAddBackupDir holds the backup of the cadir's `add` directory inside of
+	// a backup, when it isn't under `dir`
+	linuxAddBackupDir = ".cert-manage-add"
 )
 
 type linuxStore struct {
 	ca cadir
+
+	// release holds the distro information, it may be nil
+	release *osRelease
+
+	// execRefresh forces ca.refresh to be ran rather than rebundling natively
+	execRefresh bool
+}
+
+func platform(opts *Options) Store {
+	root := opts.root()
+
+	paths := make([]string, len(osReleasePaths))
+	for i := range osReleasePaths {
+		paths[i] = filepath.Join(root, osReleasePaths[i])
+	}
+	rel, err := readOSRelease(paths...)
+	if err != nil && debug {
+		fmt.Printf("store/linux: unable to read os-release: %v\n", err)
+	}
+	return linuxStore{
+		ca:          findCadir(rel, root),
+		release:     rel,
+		execRefresh: opts.execRefresh(),
+	}
 }
 
-func platform() Store {
-	var ca cadir
+// findCadir returns the cadir matching the distro (by ID and then ID_LIKE). If
+// no distro matches the first cadir with a certificate bundle is returned.
+func findCadir(rel *osRelease, root string) cadir {
+	if rel != nil {
+		ids := append([]string{rel.id}, rel.idLike...)
+		for i := range ids {
+			for j := range cadirs {
+				for k := range cadirs[j].distros {
+					if ids[i] == cadirs[j].distros[k] {
+						return cadirs[j].under(root)
+					}
+				}
+			}
+		}
+	}
 	// find the cadir, if it exists
-	for _, ca = range cadirs {
-		if ca.empty() {
-			break
+	for i := range cadirs {
+		ca := cadirs[i].under(root)
+		if !ca.empty() {
+			return ca
 		}
 	}
+	return cadirs[0].under(root)
+}
 
-	return linuxStore{
-		ca: ca,
+// osRelease holds the fields we care about from /etc/os-release
+//
+// Docs: https://www.freedesktop.org/software/systemd/man/os-release.html
+type osRelease struct {
+	id      string
+	idLike  []string
+	name    string
+	version string
+}
+
+// readOSRelease parses the first os-release file found from `paths`
+func readOSRelease(paths ...string) (*osRelease, error) {
+	for i := range paths {
+		bs, err := ioutil.ReadFile(paths[i])
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		return parseOSRelease(bs), nil
+	}
+	return nil, errors.New("no os-release file found")
+}
+
+func parseOSRelease(bs []byte) *osRelease {
+	kv := make(map[string]string)
+	for _, line := range strings.Split(string(bs), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		kv[parts[0]] = strings.Trim(parts[1], `"'`)
+	}
+
+	rel := &osRelease{
+		id:      strings.ToLower(kv["ID"]),
+		idLike:  strings.Fields(strings.ToLower(kv["ID_LIKE"])),
+		name:    kv["NAME"],
+		version: kv["VERSION_ID"],
+	}
+	if rel.version == "" {
+		rel.version = kv["VERSION"] // e.g. rolling releases
 	}
+	return rel
 }
 
 func (s linuxStore) Add(certs []*x509.Certificate) error {
+	if s.ca.err != nil {
+		return s.ca.err
+	}
 	if s.ca.empty() {
 		return errors.New("unable to find certificate directory")
 	}
@@ -114,33 +311,47 @@ func (s linuxStore) Add(certs []*x509.Certificate) error {
 // Backup takes a snapshot of the current set of CA certificates and
 // saves them to another location. It will overwrite any previous backup.
 func (s linuxStore) Backup() error {
-	dir, err := getCertManageDir(fmt.Sprintf("%s/%d", linuxBackupDir, time.Now().Unix()))
+	if s.ca.err != nil {
+		return s.ca.err
+	}
+	dir, err := getCertManageDir(s.ca.root, fmt.Sprintf("%s/%d", linuxBackupDir, time.Now().Unix()))
 	if err != nil {
 		return err
 	}
-	return file.MirrorDir(s.ca.dir, dir)
+	if err := file.MirrorDir(s.ca.dir, dir); err != nil {
+		return err
+	}
+	if s.ca.separateAdd() && file.Exists(s.ca.add) {
+		return file.MirrorDir(s.ca.add, filepath.Join(dir, linuxAddBackupDir))
+	}
+	return nil
 }
 
-func (s linuxStore) GetLatestBackup() (string, error) {
-	dir, err := getCertManageDir(linuxBackupDir)
-	if err != nil {
-		return "", fmt.Errorf("GetLatestBackup: error getting linux backup directory, err=%v", err)
+// kind includes the distro family, as each keeps its CA certificates differently
+func (s linuxStore) kind() string {
+	if len(s.ca.distros) == 0 {
+		return linuxBackupDir
 	}
-	return getLatestBackup(dir)
+	return linuxBackupDir + "/" + s.ca.distros[0]
 }
 
-func (s linuxStore) uname(args ...string) string {
-	out, err := exec.Command("uname", args...).CombinedOutput()
+func (s linuxStore) GetLatestBackup() (string, error) {
+	dir, err := certManageDir(s.ca.root, linuxBackupDir)
 	if err != nil {
-		return ""
+		return "", fmt.Errorf("GetLatestBackup: error getting linux backup directory, err=%v", err)
 	}
-	return strings.TrimSpace(string(out))
+	return findLatestBackup(dir)
 }
 
 func (s linuxStore) GetInfo() *Info {
+	if s.release == nil || s.release.name == "" {
+		return &Info{
+			Name: "Linux",
+		}
+	}
 	return &Info{
-		Name:    s.uname("-o"), // GNU/Linux,
-		Version: s.uname("-r"), // 4.9.60-linuxkit-aufs
+		Name:    s.release.name,    // Alpine Linux
+		Version: s.release.version, // 3.7.0
 	}
 }
 
@@ -148,23 +359,26 @@ func (s linuxStore) GetInfo() *Info {
 //
 // Note: Linux does not offer support for "untrusting" a certificate
 // it must be removed instead.
-func (s linuxStore) List(_ *ListOptions) ([]*x509.Certificate, error) {
+func (s linuxStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
 	if s.ca.empty() {
 		return nil, nil
 	}
 
-	bytes, err := ioutil.ReadFile(s.ca.all)
+	path, err := file.ResolveLinks(s.ca.root, s.ca.all)
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO(adam): Filter out expired and revoked certs, based on ListOptions
 	certs, err := certutil.ParsePEM(bytes)
 	if err != nil {
 		return nil, err
 	}
 
-	return certs, nil
+	return opts.filter(certs)
 }
 
 // Remove walks through the installed CA certificates on a linux based
@@ -172,92 +386,303 @@ func (s linuxStore) List(_ *ListOptions) ([]*x509.Certificate, error) {
 //
 // Steps
 // 1. Walk through the dir (/etc/ssl/certs/) and chmod 000 the certs we aren't trusting
-// 2. Run `update-ca-certificates` to re-create the ca-certificates.crt file
+// 2. Re-create the ca-certificates.crt file (natively or with `update-ca-certificates`)
+//
+// If the cadir has a blocklist directory (e.g. ca-trust) then the certs aren't
+// modified, instead a copy of each untrusted cert is written into the blocklist.
 func (s linuxStore) Remove(wh whitelist.Whitelist) error {
-	// Check each CA cert file and optionally disable
+	if s.ca.err != nil {
+		return s.ca.err
+	}
+	if s.ca.blocklist != "" {
+		if err := s.writeBlocklist(wh); err != nil {
+			return err
+		}
+		return s.rebundleCerts()
+	}
+
+	// Read and filter every CA cert file before writing any of them back, so a
+	// file which can't be read leaves the store untouched
+	kept := make(map[string][]*x509.Certificate)
 	walk := func(path string, info os.FileInfo, err error) error {
 		// Ignore SkipDir and directories
 		if (err != nil && err != filepath.SkipDir) || info.IsDir() {
 			return nil
 		}
 
+		// follow links under root, so files outside of it aren't rewritten
+		path, err = file.ResolveLinks(s.ca.root, path)
+		if err != nil {
+			return err
+		}
+
 		// read the cert(s) contained at the file and only keep those
 		// that aren't removable
 		read, err := certutil.FromFile(path)
 		if err != nil {
 			return err
 		}
-		for i := 0; i < len(read); i++ {
-			// Remove the cert if we don't match
-			if !wh.Matches(read[i]) {
-				read = append(read[:i], read[i+1:]...)
-				if len(read) == 0 {
-					break
-				}
+		var keep []*x509.Certificate
+		for i := range read {
+			if wh.Matches(read[i]) {
+				keep = append(keep, read[i])
 			}
 		}
+		if len(keep) < len(read) {
+			kept[path] = keep
+		}
+		return nil
+	}
+	// Custom certificates are in the bundle as well, so they're whitelisted too
+	dirs := []string{s.ca.dir}
+	if s.ca.separateAdd() {
+		dirs = append(dirs, s.ca.add)
+	}
+	for i := range dirs {
+		if err := filepath.Walk(dirs[i], walk); err != nil {
+			return err
+		}
+	}
 
-		// otherwise, write kept certs from `read` back
-		err = certutil.ToFile(path, read)
-		if err != nil {
+	// write kept certs back, each file is replaced with a rename
+	for path, certs := range kept {
+		if err := writeCertsAtomic(path, certs); err != nil {
 			return err
 		}
+	}
 
-		return nil
+	return s.rebundleCerts()
+}
+
+// distrusts is true when Remove writes untrusted certificates into a blocklist
+func (s linuxStore) distrusts() bool {
+	return s.ca.blocklist != ""
+}
+
+// restorePoint returns the backup of the CA directory to restore, which must be
+// a directory
+func (s linuxStore) restorePoint(where string) (string, error) {
+	dir, err := certManageDir(s.ca.root, linuxBackupDir)
+	if err != nil {
+		return "", err
+	}
+	src, err := findRestorePoint(s, s.ca.root, dir, where)
+	if err != nil || src == "" {
+		return src, err
 	}
+	return src, checkBackupDir(s.ca.root, src, certutil.FromFile, "README*", "*.conf")
+}
 
-	// Walk the fs and deactivate each cert
-	err := filepath.Walk(s.ca.dir, walk)
+// listBackup reads the certificates which would be trusted after restoring backup.
+//
+// With a ca-certificates.conf the bundle is rebuilt from the certificates it enables
+// in the backup and the custom certificates (from the backup, if it has them).
+// Otherwise certificates only leave the bundle by being blocklisted, so the backup's
+// sources and blocklist are applied to what's trusted now.
+func (s linuxStore) listBackup(backup string, opts *ListOptions) ([]*x509.Certificate, error) {
+	if s.ca.conf != "" {
+		enabled, _, err := readCACertificatesConf(s.ca.conf)
+		if err != nil {
+			return nil, err
+		}
+		var out []*x509.Certificate
+		for i := range enabled {
+			certs, err := s.readBackupFile(filepath.Join(backup, enabled[i]))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			out = append(out, certs...)
+		}
+		add := s.ca.add
+		if dir := filepath.Join(backup, linuxAddBackupDir); file.Exists(dir) {
+			add = dir
+		}
+		paths, err := findCrtFiles(add)
+		if err != nil {
+			return nil, err
+		}
+		for i := range paths {
+			certs, err := s.readBackupFile(paths[i])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, certs...)
+		}
+		return opts.filter(out)
+	}
+
+	trusted, err := s.List(&ListOptions{Trusted: true})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	var blocklist string
+	if s.ca.blocklist != "" {
+		if rel, err := filepath.Rel(s.ca.dir, s.ca.blocklist); err == nil && within(s.ca.dir, s.ca.blocklist) {
+			blocklist = rel
+		}
+	}
+	blockedNow := s.readCertDir(s.ca.blocklist, "")
+	sourcesNow := s.readCertDir(s.ca.dir, blocklist)
+	sources := s.readCertDir(backup, blocklist)
+	var blocked []*x509.Certificate
+	if blocklist != "" {
+		blocked = s.readCertDir(filepath.Join(backup, blocklist), "")
 	}
 
-	return s.rebundleCerts()
+	// certificates blocklisted now are trusted again, unless the backup blocklists
+	// them or they were only in the sources now
+	drop := fingerprints(blocked)
+	kept := fingerprints(sources)
+	for fp := range fingerprints(sourcesNow) {
+		if !kept[fp] {
+			drop[fp] = true
+		}
+	}
+	var out []*x509.Certificate
+	for _, certs := range [][]*x509.Certificate{trusted, blockedNow, sources} {
+		for i := range certs {
+			if !drop[certutil.GetHexSHA256Fingerprint(*certs[i])] {
+				out = append(out, certs[i])
+			}
+		}
+	}
+	return opts.filter(dedupCerts(out))
+}
+
+// readBackupFile reads the certificates in a backup's file, following its links
+// under the cadir's root
+func (s linuxStore) readBackupFile(path string) ([]*x509.Certificate, error) {
+	path, err := file.ResolveLinks(s.ca.root, path)
+	if err != nil {
+		return nil, err
+	}
+	return certutil.FromFile(path)
+}
+
+// readCertDir reads the certificates of each file under dir, other than those
+// under skip (relative to dir). Files which aren't certificates are ignored.
+func (s linuxStore) readCertDir(dir, skip string) []*x509.Certificate {
+	if dir == "" {
+		return nil
+	}
+	var out []*x509.Certificate
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if skip != "" && path == filepath.Join(dir, skip) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if certs, err := s.readBackupFile(path); err == nil {
+			out = append(out, certs...)
+		}
+		return nil
+	})
+	return out
+}
+
+// fingerprints returns the set of SHA256 fingerprints of certs
+func fingerprints(certs []*x509.Certificate) map[string]bool {
+	out := make(map[string]bool, len(certs))
+	for i := range certs {
+		out[certutil.GetHexSHA256Fingerprint(*certs[i])] = true
+	}
+	return out
 }
 
 func (s linuxStore) Restore(where string) error {
-	dir, err := s.GetLatestBackup()
+	if s.ca.err != nil {
+		return s.ca.err
+	}
+	dir, err := s.restorePoint(where)
 	if err != nil {
 		return err
 	}
+	if dir == "" {
+		return errors.New("no linux backup found")
+	}
 	if debug {
 		fmt.Printf("store/linux: restoring from backup dir %s\n", dir)
 	}
 
-	// Remove the current dir
-	if file.Exists(s.ca.dir) {
-		err := os.RemoveAll(s.ca.dir)
-		if err != nil && !os.IsNotExist(err) {
+	// Restore into a copy of the dir which then replaces it
+	if err := replaceDir(dir, s.ca.dir, linuxAddBackupDir); err != nil {
+		return err
+	}
+	// Backups taken before custom certificates were backed up leave them as-is
+	if add := filepath.Join(dir, linuxAddBackupDir); s.ca.separateAdd() && file.Exists(add) {
+		if err := replaceDir(add, s.ca.add); err != nil {
 			return err
 		}
 	}
+	return s.rebundleCerts()
+}
 
-	// Restore
-	err = file.MirrorDir(dir, s.ca.dir)
+// writeBlocklist saves each trusted certificate which isn't whitelisted into
+// the blocklist directory. Tools like update-ca-trust will then exclude those
+// certificates from the extracted bundles.
+func (s linuxStore) writeBlocklist(wh whitelist.Whitelist) error {
+	certs, err := s.List(&ListOptions{
+		Trusted: true,
+	})
 	if err != nil {
 		return err
 	}
-	return s.rebundleCerts()
+
+	if err := os.MkdirAll(s.ca.blocklist, 0755); err != nil {
+		return err
+	}
+	for i := range certs {
+		if wh.Matches(certs[i]) {
+			continue
+		}
+		fp := certutil.GetHexSHA256Fingerprint(*certs[i])
+		path := filepath.Join(s.ca.blocklist, fmt.Sprintf("%s.pem", fp))
+		if err := certutil.ToFile(path, certs[i:i+1]); err != nil {
+			return err
+		}
+		if debug {
+			fmt.Printf("store/linux: distrusted %s in %s\n", fp, path)
+		}
+	}
+	return nil
 }
 
 // Update the certs trust system-wide
 func (s linuxStore) rebundleCerts() error {
-	var out bytes.Buffer
-
-	cmd := exec.Command("sudo", s.ca.refresh)
-	if os.Getuid() == 0 {
-		// drop sudo if we're already root
-		cmd = exec.Command(s.ca.refresh)
+	if s.ca.conf != "" && !s.execRefresh && !s.runsHooks() {
+		return s.rebundleNative()
+	}
+	if len(s.ca.refresh) == 0 {
+		return errors.New("no refresh command for certificate directory")
 	}
-	cmd.Stdout = &out
-
 	if debug {
 		fmt.Println("store/linux: updated CA certificates")
 	}
+	return runRefresh(s.ca.root, s.ca.refresh)
+}
 
-	err := cmd.Run()
+// runsHooks returns true when update-ca-certificates is installed and has update.d
+// hooks to run, e.g. Debian's which rebuilds the Java cacerts. Rebundling natively
+// doesn't run them.
+func (s linuxStore) runsHooks() bool {
+	if s.ca.hooks == "" || len(s.ca.refresh) == 0 {
+		return false
+	}
+	if path, err := file.JoinRoot(s.ca.root, s.ca.refresh[0]); err != nil || !file.Exists(path) {
+		return false
+	}
+	fis, err := ioutil.ReadDir(s.ca.hooks)
 	if err != nil {
-		return fmt.Errorf("error updating trust status: err=%v, out=%s", err, out.String())
+		return false
 	}
-	return nil
+	for i := range fis {
+		if fis[i].Mode().IsRegular() && fis[i].Mode().Perm()&0111 != 0 {
+			return true
+		}
+	}
+	return false
 }
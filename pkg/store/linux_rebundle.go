@@ -0,0 +1,248 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+// rebundleNative is a Go implementation of update-ca-certificates(8) from the
+// Debian and Alpine ca-certificates packages.
+//
+// Links in /etc/ssl/certs/ are named like the distro's own tool names them. As
+// Alpine's does, links into the certificate directories which aren't for an
+// enabled certificate are removed on Alpine.
+//
+// Steps
+//  1. Read ca-certificates.conf for enabled (and disabled) certificates under ca.dir
+//  2. Add every *.crt file under ca.add
+//  3. Symlink each certificate into /etc/ssl/certs/ and drop links for disabled ones
+//  4. Atomically replace the certificate bundle (ca.all)
+//  5. Rebuild the /etc/ssl/certs/<hash>.0 symlinks
+func (s linuxStore) rebundleNative() error {
+	etc := filepath.Dir(s.ca.all)
+
+	enabled, disabled, err := readCACertificatesConf(s.ca.conf)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(enabled))
+	for i := range enabled {
+		paths = append(paths, filepath.Join(s.ca.dir, enabled[i]))
+	}
+	local, err := findCrtFiles(s.ca.add)
+	if err != nil {
+		return err
+	}
+	paths = append(paths, local...)
+
+	// Remove links for any certificate we're not going to be trusting
+	for i := range disabled {
+		err := os.Remove(filepath.Join(etc, s.ca.pemLinkName(disabled[i])))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	var bundle bytes.Buffer
+	links := make(map[string]bool)
+	for i := range paths {
+		bs, err := ioutil.ReadFile(paths[i])
+		if err != nil {
+			if os.IsNotExist(err) {
+				if debug {
+					fmt.Printf("store/linux: skipping missing certificate %s\n", paths[i])
+				}
+				continue
+			}
+			return err
+		}
+		certs, err := certutil.ParsePEM(bs)
+		if err != nil {
+			return fmt.Errorf("problem reading %s: %v", paths[i], err)
+		}
+
+		// Certificate files emptied by Remove() are no longer trusted
+		link := filepath.Join(etc, s.ca.pemLinkName(paths[i]))
+		if len(certs) == 0 {
+			if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		for j := range certs {
+			err := pem.Encode(&bundle, &pem.Block{
+				Type:  "CERTIFICATE",
+				Bytes: certs[j].Raw,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if err := replaceSymlink(s.ca.unroot(paths[i]), link); err != nil {
+			return err
+		}
+		links[filepath.Base(link)] = true
+	}
+
+	if s.ca.alpineLinks {
+		if err := s.removeUnknownLinks(etc, links); err != nil {
+			return err
+		}
+	}
+
+	if err := removeDanglingSymlinks(s.ca.root, etc); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.ca.all, bundle.Bytes(), 0644); err != nil {
+		return err
+	}
+	if debug {
+		fmt.Printf("store/linux: wrote %d certificate files into %s\n", len(paths), s.ca.all)
+	}
+	return certutil.Rehash(s.ca.root, etc)
+}
+
+// readCACertificatesConf parses /etc/ca-certificates.conf returning the enabled
+// and disabled certificate paths (relative to the CA directory).
+//
+// Lines starting with # are comments and lines starting with ! are certificates
+// which have been deselected.
+func readCACertificatesConf(path string) (enabled []string, disabled []string, err error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fd.Close()
+
+	r := bufio.NewScanner(fd)
+	for r.Scan() {
+		line := strings.TrimSpace(r.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "!") {
+			disabled = append(disabled, strings.TrimPrefix(line, "!"))
+			continue
+		}
+		enabled = append(enabled, line)
+	}
+	return enabled, disabled, r.Err()
+}
+
+// findCrtFiles returns each *.crt file under dir, which doesn't need to exist.
+func findCrtFiles(dir string) ([]string, error) {
+	var out []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".crt") {
+			out = append(out, path)
+		}
+		return nil
+	})
+	sort.Strings(out)
+	return out, err
+}
+
+// pemLinkName mirrors the naming update-ca-certificates uses for the links
+// it creates in /etc/ssl/certs/
+func (ca cadir) pemLinkName(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), ".crt")
+	if ca.alpineLinks {
+		return "ca-cert-" + name + ".pem"
+	}
+	r := strings.NewReplacer(" ", "_", "(", "=", ")", "=", ",", "_")
+	return r.Replace(name) + ".pem"
+}
+
+// removeUnknownLinks deletes the links in dir pointing into the certificate
+// directories which aren't in keep, e.g. those of disabled certificates or named
+// by another tool.
+func (s linuxStore) removeUnknownLinks(dir string, keep map[string]bool) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for i := range fis {
+		if fis[i].Mode()&os.ModeSymlink == 0 || keep[fis[i].Name()] {
+			continue
+		}
+		path := filepath.Join(dir, fis[i].Name())
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			target = s.ca.unroot(filepath.Join(dir, target))
+		}
+		if within(s.ca.unroot(s.ca.dir), target) || within(s.ca.unroot(s.ca.add), target) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replaceSymlink creates (or updates) a symlink at `link` pointing to `target`.
+func replaceSymlink(target, link string) error {
+	if current, err := os.Readlink(link); err == nil && current == target {
+		return nil
+	}
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(target, link)
+}
+
+// removeDanglingSymlinks deletes symlinks in dir whose target no longer exists
+// Absolute targets are checked under root.
+func removeDanglingSymlinks(root, dir string) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for i := range fis {
+		if fis[i].Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		path := filepath.Join(dir, fis[i].Name())
+		if _, err := file.ResolveLinks(root, path); os.IsNotExist(err) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
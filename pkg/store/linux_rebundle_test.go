@@ -0,0 +1,342 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+)
+
+func TestStoreLinux__rebundleNative(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-linux-rebundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := cadir{
+		add:  filepath.Join(dir, "local"),
+		dir:  filepath.Join(dir, "share"),
+		all:  filepath.Join(dir, "etc", "ca-certificates.crt"),
+		conf: filepath.Join(dir, "ca-certificates.conf"),
+	}
+	for _, d := range []string{ca.add, filepath.Join(ca.dir, "mozilla"), filepath.Dir(ca.all)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two distro certs (one disabled) and one local cert
+	write := func(path string, idx int) {
+		if err := certutil.ToFile(path, certs[idx:idx+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(ca.dir, "mozilla", "Enabled (2048).crt"), 0)
+	write(filepath.Join(ca.dir, "mozilla", "Disabled.crt"), 1)
+	write(filepath.Join(ca.add, "local.crt"), 2)
+	conf := "# comment\nmozilla/Enabled (2048).crt\n!mozilla/Disabled.crt\nmozilla/Missing.crt\n"
+	if err := ioutil.WriteFile(ca.conf, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pretend Disabled.crt was previously trusted
+	etc := filepath.Dir(ca.all)
+	if err := os.Symlink(filepath.Join(ca.dir, "mozilla", "Disabled.crt"), filepath.Join(etc, "Disabled.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := linuxStore{ca: ca}
+	if err := s.rebundleNative(); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := s.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Errorf("expected 2 certs in bundle, got %d", len(found))
+	}
+
+	for _, name := range []string{"Enabled_=2048=.pem", "local.pem"} {
+		if _, err := os.Stat(filepath.Join(etc, name)); err != nil {
+			t.Errorf("expected link %s: %v", name, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(etc, "Disabled.pem")); !os.IsNotExist(err) {
+		t.Errorf("expected Disabled.pem to be removed, err=%v", err)
+	}
+
+	// hash links from `openssl x509 -hash`
+	for _, name := range []string{"aee5f10d.0", "106f3e4d.0"} {
+		if _, err := os.Stat(filepath.Join(etc, name)); err != nil {
+			t.Errorf("expected hash link %s: %v", name, err)
+		}
+	}
+
+	// Emptied certificate files are dropped on the next rebundle
+	if err := certutil.ToFile(filepath.Join(ca.add, "local.crt"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.rebundleNative(); err != nil {
+		t.Fatal(err)
+	}
+	found, err = s.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Errorf("expected 1 cert in bundle, got %d", len(found))
+	}
+	if _, err := os.Lstat(filepath.Join(etc, "106f3e4d.0")); !os.IsNotExist(err) {
+		t.Errorf("expected stale hash link to be removed, err=%v", err)
+	}
+}
+
+func TestStoreLinux__readCACertificatesConf(t *testing.T) {
+	if _, _, err := readCACertificatesConf("missing"); err == nil {
+		t.Error("expected error")
+	}
+
+	path := "/etc/ca-certificates.conf"
+	if _, err := os.Stat(path); err != nil {
+		t.Skipf("%s not found", path)
+	}
+	enabled, _, err := readCACertificatesConf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enabled) == 0 {
+		t.Errorf("no certificates enabled in %s", path)
+	}
+}
+
+func TestStoreLinux__rebundleUnderRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-linux-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lay out a minimal debian filesystem under root
+	for _, d := range []string{"etc/ssl/certs", "usr/share/ca-certificates/mozilla", "usr/local/share/ca-certificates"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"etc/os-release":           "ID=debian\nNAME=\"Debian GNU/Linux\"\nVERSION_ID=\"12\"\n",
+		"etc/ca-certificates.conf": "mozilla/first.crt\n",
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := certutil.ToFile(filepath.Join(root, "usr/share/ca-certificates/mozilla/first.crt"), certs[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	st := platform(&Options{Root: root})
+	s, ok := st.(linuxStore)
+	if !ok {
+		t.Fatalf("unexpected store %T", st)
+	}
+	if s.ca.all != filepath.Join(root, "etc/ssl/certs/ca-certificates.crt") {
+		t.Fatalf("unexpected bundle path %s", s.ca.all)
+	}
+	if err := s.rebundleNative(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(certs[2:3]); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := s.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Errorf("expected 2 certs in bundle, got %d", len(found))
+	}
+
+	// Links are written as seen from inside of root
+	target, err := os.Readlink(filepath.Join(root, "etc/ssl/certs/first.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "/usr/share/ca-certificates/mozilla/first.crt" {
+		t.Errorf("unexpected link target %s", target)
+	}
+
+	// Backups are kept on the host, apart from the host's own
+	dir, err := getCertManageDir(s.ca.root, linuxBackupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if within(root, dir) || !within(filepath.Join(certManageParentDir(""), rootsDir), dir) {
+		t.Errorf("backup dir %s isn't on the host", dir)
+	}
+}
+
+func TestStoreLinux__rebundleAlpine(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-linux-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lay out a minimal alpine filesystem under root
+	for _, d := range []string{"etc/ssl/certs", "usr/share/ca-certificates/mozilla", "usr/local/share/ca-certificates"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"etc/os-release":           "NAME=\"Alpine Linux\"\nID=alpine\nVERSION_ID=3.18.4\n",
+		"etc/ca-certificates.conf": "mozilla/First (2048).crt\n!mozilla/second.crt\n",
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	share := filepath.Join(root, "usr/share/ca-certificates/mozilla")
+	if err := certutil.ToFile(filepath.Join(share, "First (2048).crt"), certs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := certutil.ToFile(filepath.Join(share, "second.crt"), certs[1:2]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Links left by another tool, and one to a file outside of the cert dirs
+	etc := filepath.Join(root, "etc/ssl/certs")
+	links := map[string]string{
+		"First_=2048=.pem":    "/usr/share/ca-certificates/mozilla/First (2048).crt",
+		"ca-cert-second.pem":  "/usr/share/ca-certificates/mozilla/second.crt",
+		"other.pem":           "/etc/other.pem",
+		"ca-cert-local.pem":   "../../../usr/local/share/ca-certificates/gone.crt",
+		"ca-cert-unknown.pem": "/usr/share/ca-certificates/mozilla/second.crt",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(etc, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "etc/other.pem"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := platform(&Options{Root: root}).(linuxStore)
+	if !ok || !s.ca.alpineLinks {
+		t.Fatalf("expected alpine store: %#v", s.ca)
+	}
+	if err := s.rebundleNative(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add(certs[2:3]); err != nil {
+		t.Fatal(err)
+	}
+
+	fis, err := ioutil.ReadDir(etc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := range fis {
+		if !strings.HasSuffix(fis[i].Name(), ".0") {
+			names = append(names, fis[i].Name())
+		}
+	}
+	fp := certutil.GetHexSHA256Fingerprint(*certs[2])
+	expected := []string{"ca-cert-" + fp + ".pem", "ca-cert-First (2048).pem", "ca-certificates.crt", "other.pem"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("got %v, expected %v", names, expected)
+	}
+	target, err := os.Readlink(filepath.Join(etc, "ca-cert-First (2048).pem"))
+	if err != nil || target != "/usr/share/ca-certificates/mozilla/First (2048).crt" {
+		t.Errorf("unexpected link target %q err=%v", target, err)
+	}
+}
+
+func TestStoreLinux__runsHooks(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-linux-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	s := linuxStore{ca: cadirs[0].under(root)}
+	if s.runsHooks() {
+		t.Error("no hooks directory")
+	}
+
+	hooks := filepath.Join(root, "etc/ca-certificates/update.d")
+	if err := os.MkdirAll(hooks, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(hooks, "README"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(hooks, "jks-keystore"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if s.runsHooks() {
+		t.Error("update-ca-certificates isn't installed")
+	}
+
+	tool := filepath.Join(root, "usr/sbin/update-ca-certificates")
+	if err := os.MkdirAll(filepath.Dir(tool), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tool, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !s.runsHooks() {
+		t.Error("expected the jks-keystore hook to be ran")
+	}
+
+	// Only executable hooks are ran
+	if err := os.Chmod(filepath.Join(hooks, "jks-keystore"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if s.runsHooks() {
+		t.Error("jks-keystore isn't executable")
+	}
+}
@@ -18,17 +18,289 @@
 package store
 
 import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
 )
 
 func TestStoreLinux__cadir(t *testing.T) {
 	// just grab the linuxStore and make sure it has a cadir member
-	s, ok := platform().(linuxStore)
+	s, ok := platform(nil).(linuxStore)
 	if !ok {
 		t.Error("error casting to linuxStore")
 	}
 	if s.ca.empty() {
 		t.Errorf("no cadir found on platform: %s", runtime.GOOS)
 	}
+
+	// archives are only restored to the same distro family
+	if kind := (linuxStore{ca: cadirs[0]}).kind(); kind != "linux/debian" {
+		t.Errorf("got %s", kind)
+	}
+	if kind := (linuxStore{ca: cadirs[1]}).kind(); kind != "linux/fedora" {
+		t.Errorf("got %s", kind)
+	}
+}
+
+func TestStoreLinux__cadirUnder(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-linux-under")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// root/etc/pki -> /srv/pki is followed inside of root, not onto the host
+	if err := os.MkdirAll(filepath.Join(root, "srv", "pki"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/srv/pki", filepath.Join(root, "etc", "pki")); err != nil {
+		t.Fatal(err)
+	}
+	ca := cadirs[1].under(root)
+	if ca.err != nil {
+		t.Fatal(ca.err)
+	}
+	if expected := filepath.Join(root, "srv", "pki", "ca-trust", "source"); ca.dir != expected {
+		t.Errorf("got %s, expected %s", ca.dir, expected)
+	}
+
+	// links which leave root are refused
+	if err := os.Remove(filepath.Join(root, "etc", "pki")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../../../../etc/pki", filepath.Join(root, "etc", "pki")); err != nil {
+		t.Fatal(err)
+	}
+	ca = cadirs[1].under(root)
+	if ca.err == nil || !ca.empty() {
+		t.Fatalf("expected error, got %+v", ca)
+	}
+	if err := (linuxStore{ca: ca}).Remove(whitelist.Whitelist{}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestStoreLinux__writeBlocklist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-linux-blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Use lots.crt as the extracted bundle
+	all := filepath.Join(dir, "tls-ca-bundle.pem")
+	if err := file.CopyFile(filepath.Join("..", "..", "testdata", "lots.crt"), all); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := certutil.FromFile(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := linuxStore{
+		ca: cadir{
+			all:       all,
+			blocklist: filepath.Join(dir, "blocklist"),
+		},
+	}
+	wh := whitelist.FromCertificates(certs[:1])
+	if err := s.writeBlocklist(wh); err != nil {
+		t.Fatal(err)
+	}
+
+	fis, err := ioutil.ReadDir(s.ca.blocklist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != len(certs)-1 {
+		t.Errorf("expected %d blocklisted certs, got %d", len(certs)-1, len(fis))
+	}
+	kept := certutil.GetHexSHA256Fingerprint(*certs[0])
+	if file.Exists(filepath.Join(s.ca.blocklist, kept+".pem")) {
+		t.Errorf("whitelisted cert %s was blocklisted", kept)
+	}
+}
+
+func TestStoreLinux__osRelease(t *testing.T) {
+	rel := parseOSRelease([]byte(`NAME="Alpine Linux"
+ID=alpine
+VERSION_ID=3.7.0
+PRETTY_NAME="Alpine Linux v3.7"
+HOME_URL="http://alpinelinux.org"
+`))
+	if rel.id != "alpine" {
+		t.Errorf("got id=%q", rel.id)
+	}
+	if rel.name != "Alpine Linux" {
+		t.Errorf("got name=%q", rel.name)
+	}
+	if rel.version != "3.7.0" {
+		t.Errorf("got version=%q", rel.version)
+	}
+
+	// ID_LIKE is a space separated list
+	rel = parseOSRelease([]byte(`NAME="Rocky Linux"
+VERSION="8.9 (Green Obsidian)"
+ID="rocky"
+ID_LIKE="rhel centos fedora"
+VERSION_ID="8.9"
+`))
+	if rel.id != "rocky" || len(rel.idLike) != 3 {
+		t.Errorf("got id=%q idLike=%q", rel.id, rel.idLike)
+	}
+	if ca := findCadir(rel, ""); ca.blocklist != "/etc/pki/ca-trust/source/blocklist" {
+		t.Errorf("expected ca-trust cadir, got %#v", ca)
+	}
+
+	// Arch doesn't have VERSION_ID
+	rel = parseOSRelease([]byte("NAME=\"Arch Linux\"\nID=arch\nBUILD_ID=rolling\n"))
+	if ca := findCadir(rel, ""); ca.refresh[0] != "/usr/bin/trust" {
+		t.Errorf("expected arch cadir, got %#v", ca)
+	}
+	info := linuxStore{release: rel}.GetInfo()
+	if info.Name != "Arch Linux" || info.Version != "" {
+		t.Errorf("got %#v", info)
+	}
+
+	// No os-release still gives something
+	info = linuxStore{}.GetInfo()
+	if info.Name != "Linux" {
+		t.Errorf("got %#v", info)
+	}
+}
+
+func TestStoreLinux__readOSRelease(t *testing.T) {
+	rel, err := readOSRelease("missing")
+	if err == nil || rel != nil {
+		t.Errorf("expected error, got %#v", rel)
+	}
+
+	// Find whatever the host has, if any
+	rel, err = readOSRelease(osReleasePaths...)
+	if err != nil {
+		t.Skipf("no os-release on host: %v", err)
+	}
+	if rel.id == "" {
+		t.Error("empty ID")
+	}
+}
+
+func TestStoreLinux__planRemove(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-linux-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A debian filesystem with a file of several certificates and a custom one
+	for _, d := range []string{"etc/ssl/certs", "usr/share/ca-certificates/mozilla", "usr/local/share/ca-certificates"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		"etc/os-release":           "ID=debian\n",
+		"etc/ca-certificates.conf": "mozilla/several.crt\nmozilla/one.crt\n",
+	}
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := map[string][]*x509.Certificate{
+		"usr/share/ca-certificates/mozilla/several.crt": certs[:3],
+		"usr/share/ca-certificates/mozilla/one.crt":     certs[3:4],
+		"usr/local/share/ca-certificates/local.crt":     certs[4:5],
+	}
+	for name, cs := range write {
+		if err := certutil.ToFile(filepath.Join(root, name), cs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, ok := platform(&Options{Root: root}).(linuxStore)
+	if !ok {
+		t.Fatal("expected a linuxStore")
+	}
+	if err := s.rebundleNative(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 5 {
+		t.Fatalf("got %d certificates", len(before))
+	}
+	if err := s.Backup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The plan matches what Remove drops, including the custom certificate
+	wh := whitelist.FromCertificates(certs[3:4])
+	changes, err := PlanRemove(s, wh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(wh); err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 1 || !after[0].Equal(certs[3]) {
+		t.Errorf("got %d certificates after Remove", len(after))
+	}
+	planned := make(map[string]bool)
+	for i := range changes {
+		planned[certutil.GetHexSHA256Fingerprint(*changes[i].Certificate)] = true
+	}
+	removed := diffChanges(before, after, "")[ActionRemove]
+	if len(removed) != len(changes) {
+		t.Errorf("planned %d removals, removed %d", len(changes), len(removed))
+	}
+	for i := range removed {
+		if !planned[certutil.GetHexSHA256Fingerprint(*removed[i].Certificate)] {
+			t.Errorf("%s was removed without being planned", removed[i].Certificate.Subject)
+		}
+	}
+
+	// Restoring plans to add back each removed certificate, and does
+	backup, changes, err := PlanRestore(s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 4 {
+		t.Errorf("got %d changes restoring %s", len(changes), backup)
+	}
+	for i := range changes {
+		if changes[i].Action != ActionAdd {
+			t.Errorf("unexpected %s of %s", changes[i].Action, changes[i].Certificate.Subject)
+		}
+	}
+	if err := s.Restore(""); err != nil {
+		t.Fatal(err)
+	}
+	after, err = s.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 5 {
+		t.Errorf("got %d certificates after Restore", len(after))
+	}
 }
@@ -0,0 +1,241 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+// mozillaApp is an application (e.g. Firefox or Thunderbird) which keeps an NSS
+// database in each of its profiles, which are listed in a profiles.ini file.
+type mozillaApp struct {
+	// name is used for -app and the backup directory, e.g. firefox
+	name  string
+	title string
+
+	// profileRoots are the directories (under the home directory) which hold
+	// profiles.ini and the profiles themselves
+	profileRoots []string
+
+	// version returns the installed version, root is empty for "/"
+	version func(root string) string
+}
+
+// mozillaProfileSuggestions are searched for profiles when there's no profiles.ini
+var mozillaProfileSuggestions = []string{
+	"*.default*",
+	"Profiles/*.default*",
+}
+
+// MozillaProfile is a Firefox or Thunderbird profile directory
+type MozillaProfile struct {
+	// Name is the profile's name from profiles.ini, e.g. default-release
+	Name string
+	Dir  string
+}
+
+// profiles returns every profile listed in a profiles.ini file, or found by
+// searching for *.default directories when there isn't one.
+func (a mozillaApp) profiles(opts *Options) []MozillaProfile {
+	root := opts.root()
+
+	var out []MozillaProfile
+	seen := make(map[string]bool)
+	for i := range a.profileRoots {
+		base := filepath.Join(root, file.HomeDir(), a.profileRoots[i])
+
+		var profiles []MozillaProfile
+		if fd, err := os.Open(filepath.Join(base, "profiles.ini")); err == nil {
+			profiles, err = parseMozillaProfiles(root, base, fd)
+			fd.Close()
+			if err != nil && debug {
+				fmt.Printf("store/%s: error reading %s/profiles.ini: %v\n", a.name, base, err)
+			}
+		} else {
+			for j := range mozillaProfileSuggestions {
+				matches, _ := filepath.Glob(filepath.Join(base, mozillaProfileSuggestions[j]))
+				for k := range matches {
+					profiles = append(profiles, MozillaProfile{
+						Name: mozillaProfileName(matches[k]),
+						Dir:  matches[k],
+					})
+				}
+			}
+		}
+
+		for j := range profiles {
+			if !seen[profiles[j].Dir] {
+				seen[profiles[j].Dir] = true
+				out = append(out, profiles[j])
+			}
+		}
+	}
+	return out
+}
+
+// store returns a Store for the app, every profile with an NSS database is
+// operated on and each is available from Stores().
+func (a mozillaApp) store(opts *Options) Store {
+	version := a.version(opts.root())
+	stores := a.stores(opts, version)
+	if len(stores) == 0 {
+		return emptyStore{}
+	}
+	return multiStore{
+		app:  a.name,
+		root: opts.root(),
+		info: Info{
+			Name:    a.title,
+			Version: version,
+		},
+		stores: stores,
+		none:   fmt.Errorf("store/%s: no %s profiles found", a.name, a.title),
+	}
+}
+
+// storeAt returns the store for one profile, addressed as `-app <name>:<profile>`
+// where <profile> is its name or directory.
+func (a mozillaApp) storeAt(opts *Options, where string) (Store, error) {
+	stores := a.stores(opts, a.version(opts.root()))
+	for i := range stores {
+		st := stores[i].Store.(nssStore)
+		dir := unroot(st.root, st.foundCertdbLocation)
+		if stores[i].Name == a.name+":"+where || where == dir || where == filepath.Base(dir) {
+			return st, nil
+		}
+	}
+	return nil, fmt.Errorf("%s profile %q not found", a.title, where)
+}
+
+// stores returns an NSS store for each profile with a cert.db
+func (a mozillaApp) stores(opts *Options, version string) []NamedStore {
+	root := opts.root()
+
+	var out []NamedStore
+	names := make(map[string]bool)
+	profiles := a.profiles(opts)
+	for i := range profiles {
+		if !containsCertdb(profiles[i].Dir) {
+			continue
+		}
+		st := newNssStore(root, a.name, version, profiles[i].Dir)
+		// Profiles are told apart by their full path, as regular, Snap and Flatpak
+		// installs can have profile directories with the same name
+		st.backupDir = filepath.Join(a.name, backupID(root, profiles[i].Dir))
+
+		// Profiles in different locations (e.g. Snap) can share a name
+		name := a.name + ":" + profiles[i].Name
+		if names[name] {
+			name = a.name + ":" + unroot(root, profiles[i].Dir)
+		}
+		names[name] = true
+
+		out = append(out, NamedStore{
+			Name:  name,
+			Store: st,
+		})
+	}
+	return out
+}
+
+// parseMozillaProfiles reads the [Profile<n>] sections of a profiles.ini file
+// in base, which look like:
+//
+//	[Profile0]
+//	Name=default-release
+//	IsRelative=1
+//	Path=x8b3kuo3.default-release
+func parseMozillaProfiles(root, base string, r io.Reader) ([]MozillaProfile, error) {
+	var out []MozillaProfile
+	var current map[string]string
+	flush := func() {
+		if current == nil || current["Path"] == "" {
+			return
+		}
+		path := filepath.FromSlash(current["Path"])
+		if current["IsRelative"] == "1" {
+			path = filepath.Join(base, path)
+		} else {
+			path = filepath.Join(root, path)
+		}
+		name := current["Name"]
+		if name == "" {
+			name = mozillaProfileName(path)
+		}
+		out = append(out, MozillaProfile{
+			Name: name,
+			Dir:  path,
+		})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			flush()
+			current = nil
+			if strings.HasPrefix(line, "[Profile") {
+				current = make(map[string]string)
+			}
+		case current != nil:
+			if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+				current[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+		}
+	}
+	flush()
+	return out, scanner.Err()
+}
+
+// mozillaProfileName returns the name of a profile from its directory, where
+// names are prefixed with a random string (e.g. x8b3kuo3.default-release)
+func mozillaProfileName(dir string) string {
+	name := filepath.Base(dir)
+	if idx := strings.Index(name, "."); idx >= 0 {
+		return name[idx+1:]
+	}
+	return name
+}
+
+// binaryVersion runs the first of paths which exists with flag and returns its
+// output without prefix, e.g. "Mozilla Firefox 57.0.3" is returned as "57.0.3".
+//
+// Binaries aren't ran from an alternate root, so nothing is returned in that case.
+func binaryVersion(root string, paths []string, flag, prefix string) string {
+	if root != "" {
+		return ""
+	}
+	for i := range paths {
+		if !file.Exists(paths[i]) {
+			continue
+		}
+		out, err := exec.Command(paths[i], flag).CombinedOutput()
+		if err == nil && len(out) > 0 {
+			return strings.TrimSpace(strings.Replace(string(out), prefix, "", 1))
+		}
+	}
+	return ""
+}
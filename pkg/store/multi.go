@@ -0,0 +1,181 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+// multiStore is a MultiStore for the installs of one app, such as each JDK or
+// Firefox profile. Every operation is applied to each store in order.
+type multiStore struct {
+	// app names the directory (under ~/.cert-manage) each store keeps its backups in
+	app  string
+	root string
+
+	// info is returned from GetInfo when there isn't exactly one store
+	info Info
+
+	stores []NamedStore
+
+	// none is returned when no stores were found
+	none error
+}
+
+func (s multiStore) Stores() []NamedStore {
+	out := make([]NamedStore, len(s.stores))
+	copy(out, s.stores)
+	return out
+}
+
+func (s multiStore) Add(certs []*x509.Certificate) error {
+	return s.each(func(st Store) error {
+		return st.Add(certs)
+	})
+}
+
+func (s multiStore) planAdd(certs []*x509.Certificate) ([]Change, error) {
+	var out []Change
+	err := s.each(func(st Store) error {
+		changes, err := PlanAdd(st, certs)
+		out = append(out, changes...)
+		return err
+	})
+	return out, err
+}
+
+func (s multiStore) Backup() error {
+	return s.each(func(st Store) error {
+		return st.Backup()
+	})
+}
+
+// GetLatestBackup returns the app's backup directory if every store has a backup
+func (s multiStore) GetLatestBackup() (string, error) {
+	if len(s.stores) == 0 {
+		return "", s.none
+	}
+	for i := range s.stores {
+		latest, err := s.stores[i].GetLatestBackup()
+		if err != nil || latest == "" {
+			return "", err
+		}
+	}
+	return certManageDir(s.root, s.app)
+}
+
+func (s multiStore) GetInfo() *Info {
+	if len(s.stores) == 1 {
+		return s.stores[0].GetInfo()
+	}
+	info := s.info
+	return &info
+}
+
+// List returns the certificates found in any store
+func (s multiStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
+	pool := certutil.Pool{}
+	err := s.each(func(st Store) error {
+		certs, err := st.List(opts)
+		pool.AddCertificates(certs)
+		return err
+	})
+	return pool.GetCertificates(), err
+}
+
+func (s multiStore) Remove(wh whitelist.Whitelist) error {
+	return s.each(func(st Store) error {
+		return st.Remove(wh)
+	})
+}
+
+func (s multiStore) planRemove(wh whitelist.Whitelist) ([]Change, error) {
+	var out []Change
+	err := s.each(func(st Store) error {
+		changes, err := PlanRemove(st, wh)
+		out = append(out, changes...)
+		return err
+	})
+	return out, err
+}
+
+// Restore restores each store from its latest backup, or only the stores a backup
+// path, ID or archive is of when where is given.
+func (s multiStore) Restore(where string) error {
+	if where == "" {
+		return s.each(func(st Store) error {
+			return st.Restore(where)
+		})
+	}
+	stores, err := s.restoreStores(where)
+	if err != nil {
+		return err
+	}
+	for i := range stores {
+		if err := stores[i].Store.Restore(where); err != nil {
+			return fmt.Errorf("%s: %v", stores[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// restorePoint returns the backup of the (first) store where is a backup of
+func (s multiStore) restorePoint(where string) (string, error) {
+	if where == "" {
+		return s.GetLatestBackup()
+	}
+	stores, err := s.restoreStores(where)
+	if err != nil {
+		return "", err
+	}
+	return RestorePoint(stores[0].Store, where)
+}
+
+// restoreStores returns the store a backup path or ID is of. Archives can hold
+// backups of several stores, so each store with a backup in an archive is returned.
+func (s multiStore) restoreStores(where string) ([]NamedStore, error) {
+	if len(s.stores) == 0 {
+		return nil, s.none
+	}
+	stores, err := matchRestore(s.stores, where)
+	if err != nil {
+		return nil, err
+	}
+	if len(stores) > 1 && !isArchiveDir(where) {
+		names := make([]string, len(stores))
+		for i := range stores {
+			names[i] = stores[i].Name
+		}
+		return nil, fmt.Errorf("%s could be restored to %s, choose one with -app", where, strings.Join(names, ", "))
+	}
+	return stores, nil
+}
+
+func (s multiStore) each(fn func(Store) error) error {
+	if len(s.stores) == 0 {
+		return s.none
+	}
+	for i := range s.stores {
+		if err := fn(s.stores[i].Store); err != nil {
+			return fmt.Errorf("%s: %v", s.stores[i].Name, err)
+		}
+	}
+	return nil
+}
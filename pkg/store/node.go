@@ -0,0 +1,90 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+var (
+	node = bundleApp{
+		name:    "node",
+		title:   "Node.js",
+		find:    nodeBundles,
+		version: nodeVersion,
+	}
+
+	nodeBinaryPaths = []string{
+		"/usr/bin/node",
+		"/usr/local/bin/node",
+		"/opt/homebrew/bin/node",
+	}
+)
+
+// NodeStore returns a Store for the extra CA bundles of Node.js, which are given
+// with NODE_EXTRA_CA_CERTS or npm's `cafile` setting (~/.npmrc).
+//
+// Node's own roots are compiled in and can't be changed, but `node --use-openssl-ca`
+// trusts OpenSSL's store (-app openssl) instead.
+func NodeStore(opts *Options) Store {
+	return node.store(opts)
+}
+
+func nodeBundles(opts *Options) []string {
+	root := opts.root()
+
+	var out []string
+	if env := envPath(root, "NODE_EXTRA_CA_CERTS"); env != "" {
+		out = append(out, env)
+	}
+	if fd, err := os.Open(filepath.Join(root, file.HomeDir(), ".npmrc")); err == nil {
+		if cafile := parseNpmrcCafile(fd); cafile != "" {
+			out = append(out, filepath.Join(root, cafile))
+		}
+		fd.Close()
+	}
+	return out
+}
+
+// parseNpmrcCafile reads the `cafile` setting from an .npmrc file, which looks like:
+//
+//	registry=https://registry.npmjs.org/
+//	cafile=/etc/ssl/corp-ca.pem
+func parseNpmrcCafile(r io.Reader) string {
+	cafile := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "cafile" {
+			cafile = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		}
+	}
+	return cafile
+}
+
+// nodeVersion returns the installed version of Node.js
+func nodeVersion(root string) string {
+	// returns "v18.19.0"
+	return strings.TrimPrefix(binaryVersion(root, nodeBinaryPaths, "--version", ""), "v")
+}
@@ -17,6 +17,7 @@ package store
 import (
 	"bytes"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -37,17 +38,25 @@ var (
 	cutil = crtutil{
 		execPaths: []string{
 			"/usr/local/opt/nss/bin/certutil", // Darwin
-			"/usr/bin/crtutil",                // Linux
+			"/usr/bin/certutil",               // Linux
 		},
 	}
 
+	// nssBackupFiles make up an NSS database, each is included in backups
+	nssBackupFiles = []string{
+		"cert9.db", "key4.db", "pkcs11.txt", // sql
+		"cert8.db", "key3.db", "secmod.db", // dbm (legacy)
+	}
+
 	// certutil trust attrubutes
 	// Run `crtutil -A -H` for the full list
-	trustAttrsProhibited = "p,p,p"
-	trustAttrsTrusted    = "CT,C,C"
+	trustAttrsTrusted = "CT,C,C"
 )
 
 type nssStore struct {
+	// root is the filesystem root used for backups, empty for "/"
+	root string
+
 	// nssType refers to the application using this NSS instance
 	// This is used for printing back to the user and for backup/restore.
 	nssType string
@@ -58,6 +67,11 @@ type nssStore struct {
 	// foundCertdbLocation is the locally found filepath to a cert.db file
 	foundCertdbLocation string
 
+	// backupDir is where backups are kept under ~/.cert-manage, which defaults
+	// to nssType. Apps with several NSS databases (e.g. Firefox profiles) use a
+	// directory for each.
+	backupDir string
+
 	// Holds a trigger if we've made modifications (useful for triggering a "Restart app" message
 	// after we're done with modifications.
 	notify *sync.Once
@@ -71,10 +85,16 @@ type nssStore struct {
 // - https://wiki.mozilla.org/NSS_Shared_DB
 // - https://wiki.mozilla.org/NSS_Shared_DB_And_LINUX
 func NssStore(nssType string, appVersion string, certdbPath string) Store {
+	return newNssStore("", nssType, appVersion, certdbPath)
+}
+
+func newNssStore(root string, nssType string, appVersion string, certdbPath string) nssStore {
 	return nssStore{
+		root:                root,
 		nssType:             nssType,
 		appVersion:          appVersion,
 		foundCertdbLocation: certdbPath,
+		backupDir:           nssType,
 		notify:              &sync.Once{},
 	}
 }
@@ -133,41 +153,111 @@ func (s nssStore) Add(certs []*x509.Certificate) error {
 	return nil
 }
 
+// nssManifest is saved alongside the files of an NSS backup
+type nssManifest struct {
+	// Source is the NSS database directory, as seen from inside of root
+	Source  string    `json:"source"`
+	Created time.Time `json:"created"`
+
+	// Files maps each filename to its hex encoded SHA256 checksum
+	Files map[string]string `json:"files"`
+}
+
+const nssManifestFilename = "manifest.json"
+
+// Backup copies each file of the NSS database into a new directory, along with
+// a manifest of their checksums.
 func (s nssStore) Backup() error {
-	dir, err := getCertManageDir(s.nssType)
+	if s.foundCertdbLocation == "" {
+		return errors.New("No NSS cert db paths found")
+	}
+
+	dir, err := getCertManageDir(s.root, filepath.Join(s.backupDir, fmt.Sprintf("%d", time.Now().Unix())))
 	if err != nil {
 		return err
 	}
 
-	// Only backup the first nss cert.db path for now
-	if s.foundCertdbLocation == "" {
-		return errors.New("No NSS cert db paths found")
+	manifest := nssManifest{
+		Source:  unroot(s.root, s.foundCertdbLocation),
+		Created: time.Now().UTC(),
+		Files:   make(map[string]string),
+	}
+	for _, name := range nssBackupFiles {
+		src := filepath.Join(s.foundCertdbLocation, name)
+		if !file.Exists(src) {
+			continue
+		}
+		if err := file.CopyFile(src, filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		sum, err := file.SHA256(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		manifest.Files[name] = sum
+	}
+	if len(manifest.Files) == 0 {
+		return fmt.Errorf("no NSS database files found in %s", s.foundCertdbLocation)
+	}
+
+	bs, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, nssManifestFilename), bs, file.TempFilePermissions)
+}
+
+// readNSSManifest reads the manifest of the backup in dir and verifies each file's checksum
+func readNSSManifest(dir string) (*nssManifest, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(dir, nssManifestFilename))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read NSS backup manifest: %v", err)
+	}
+	var manifest nssManifest
+	if err := json.Unmarshal(bs, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid NSS backup manifest in %s: %v", dir, err)
+	}
+	if len(manifest.Files) == 0 {
+		return nil, fmt.Errorf("NSS backup manifest in %s lists no files", dir)
+	}
+	for name, expected := range manifest.Files {
+		if name != filepath.Base(name) {
+			return nil, fmt.Errorf("NSS backup manifest in %s has invalid filename %q", dir, name)
+		}
+		sum, err := file.SHA256(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if sum != expected {
+			return nil, fmt.Errorf("NSS backup %s has been modified, checksum of %s doesn't match", dir, name)
+		}
 	}
+	return &manifest, nil
+}
 
-	// Copy file into backup location
-	src := filepath.Join(s.foundCertdbLocation, filepath.Base(s.foundCertdbLocation))
-	dst := filepath.Join(dir, fmt.Sprintf("cert.db-%d", time.Now().Unix()))
-	return file.CopyFile(src, dst)
+func (s nssStore) kind() string {
+	return s.nssType
 }
 
 func (s nssStore) GetLatestBackup() (string, error) {
-	dir, err := getCertManageDir(s.nssType)
+	dir, err := certManageDir(s.root, s.backupDir)
 	if err != nil {
 		return "", fmt.Errorf("GetLatestBackup: error getting %s backup directory err=%v", s.nssType, err)
 	}
-	return getLatestBackup(dir)
+	return findLatestBackup(dir)
 }
 
 func (s nssStore) GetInfo() *Info {
 	return &Info{
-		Name:    strings.Title(s.nssType),
-		Version: s.appVersion,
+		Name:     strings.Title(s.nssType),
+		Version:  s.appVersion,
+		Location: unroot(s.root, s.foundCertdbLocation),
 	}
 }
 
 // List returns the installed (and trusted) certificates contained in a NSS cert.db file
 //
-// To list certificates with the NSS `crtutil` tool the following would be ran
+// cert9.db files are read directly, otherwise the NSS `crtutil` tool is ran:
 // $ /usr/local/opt/nss/bin/crtutil -L -d "$dir"
 //
 // Note: `dir` represents a directory path which contains a cert.db file
@@ -176,21 +266,26 @@ func (s nssStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
 		return nil, errors.New("unable to find NSS db directory")
 	}
 
-	items, err := cutil.listCertsFromDB(s.foundCertdbLocation)
+	items, err := s.listItems()
 	if err != nil {
 		return nil, err
 	}
 
+	usage := opts.Usage
+	if usage == "" {
+		usage = whitelist.UsageTLS
+	}
+
 	kept := make([]*x509.Certificate, 0)
 	for i := range items {
-		if opts.Trusted && items[i].trustedForSSL() {
+		if opts.Trusted && items[i].trustedFor(usage) {
 			kept = append(kept, items[i].certs...)
 		}
 		if opts.Untrusted {
 			kept = append(kept, items[i].certs...)
 		}
 	}
-	return kept, nil
+	return opts.filter(kept)
 }
 
 func (s nssStore) Remove(wh whitelist.Whitelist) error {
@@ -198,20 +293,20 @@ func (s nssStore) Remove(wh whitelist.Whitelist) error {
 		return errors.New("unable to find NSS db directory")
 	}
 
-	items, err := cutil.listCertsFromDB(s.foundCertdbLocation)
+	items, err := s.listItems()
 	if err != nil {
 		return err
 	}
 
-	// Remove trust from each cert if needed.
+	// Remove trust from each cert for the usages it's not whitelisted for
 	for i := range items {
-		if wh.MatchesAll(items[i].certs) {
+		attrs := items[i].whitelistedTrust(wh)
+		if attrs == items[i].trustAttrs {
 			continue
 		}
 
-		// whitelist didn't match, blacklist cert
 		defer s.notifyToRestart()
-		err = cutil.modifyTrustAttributes(s.foundCertdbLocation, items[i].nick, trustAttrsProhibited)
+		err = cutil.modifyTrustAttributes(s.foundCertdbLocation, items[i].nick, attrs)
 		if err != nil {
 			return err
 		}
@@ -219,23 +314,89 @@ func (s nssStore) Remove(wh whitelist.Whitelist) error {
 	return nil
 }
 
+// distrusts is true as Remove changes the trust attributes of certificates
+func (s nssStore) distrusts() bool {
+	return true
+}
+
+// restorePoint returns the backup directory to restore, which must have a manifest
+// showing it's a backup of this cert db
+func (s nssStore) restorePoint(where string) (string, error) {
+	dir, err := certManageDir(s.root, s.backupDir)
+	if err != nil {
+		return "", err
+	}
+	src, err := findRestorePoint(s, s.root, dir, where)
+	if err != nil || src == "" {
+		return src, err
+	}
+	if fi, err := os.Stat(src); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("%s isn't a %s backup directory, older backups can't be restored", src, s.nssType)
+	}
+	manifest, err := readNSSManifest(src)
+	if err != nil {
+		return "", err
+	}
+	// Archives can be restored to the cert db of another profile or host
+	if db := unroot(s.root, s.foundCertdbLocation); manifest.Source != db && !fromArchive(src) {
+		return "", fmt.Errorf("backup %s is of %s, not %s", src, manifest.Source, db)
+	}
+	return src, nil
+}
+
+// Restore puts back each file from the latest backup, after verifying them
+// against the backup's manifest.
 func (s nssStore) Restore(where string) error {
-	src, err := s.GetLatestBackup()
+	dir, err := s.restorePoint(where)
 	if err != nil {
 		return err
 	}
-
-	// Find filename from src (latest backup)
-	fname := "cert9.db"
-	if _, err := os.Stat(filepath.Join(src, "cert8.db")); !os.IsNotExist(err) {
-		fname = "cert8.db"
+	if dir == "" {
+		return fmt.Errorf("no %s backup found", s.nssType)
+	}
+	manifest, err := readNSSManifest(dir)
+	if err != nil {
+		return err
 	}
 
 	// Queue notification to restart app
 	defer s.notifyToRestart()
 
-	// Restore the latest backup file
-	return file.CopyFile(src, filepath.Join(s.foundCertdbLocation, fname))
+	var src, dst []string
+	for name := range manifest.Files {
+		src = append(src, filepath.Join(dir, name))
+		dst = append(dst, filepath.Join(s.foundCertdbLocation, name))
+	}
+	return replaceEntries(src, dst)
+}
+
+// listBackup reads the certificates of a backup of the NSS database
+func (s nssStore) listBackup(backup string, opts *ListOptions) ([]*x509.Certificate, error) {
+	if fi, err := os.Stat(backup); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("%s isn't a %s backup directory, older backups can't be restored", backup, s.nssType)
+	}
+	s.foundCertdbLocation = backup
+	return s.List(opts)
+}
+
+// listItems reads each certificate and its trust from the cert.db. cert9.db files
+// are read natively, while cert8.db (and cert9.db files we fail to read) require
+// NSS' certutil.
+func (s nssStore) listItems() ([]certdbItem, error) {
+	if !file.Exists(filepath.Join(s.foundCertdbLocation, "cert9.db")) {
+		return cutil.listCertsFromDB(s.foundCertdbLocation)
+	}
+	items, err := readCertdb(s.foundCertdbLocation)
+	if err == nil {
+		return items, nil
+	}
+	if _, cerr := cutil.getExecPath(); cerr != nil {
+		return nil, err
+	}
+	if debug {
+		fmt.Printf("store/nss: falling back to certutil after reading cert9.db failed: %v\n", err)
+	}
+	return cutil.listCertsFromDB(s.foundCertdbLocation)
 }
 
 // certdbItem represents an x509 Certificate with the NSS trust attributes
@@ -248,10 +409,21 @@ type certdbItem struct {
 	trustAttrs string
 }
 
+// nssTrustColumns are the usages of each column in trustAttrs
+var nssTrustColumns = []whitelist.Usage{
+	whitelist.UsageTLS,
+	whitelist.UsageEmail,
+	whitelist.UsageCodeSigning,
+}
+
 func (c certdbItem) trustedForSSL() bool {
-	// We only care about the first C,.,. attribute, which is for SSL
-	parts := strings.SplitN(c.trustAttrs, ",", 2)
-	if len(parts) != 2 {
+	return c.trustedFor(whitelist.UsageTLS)
+}
+
+// trustedFor returns true unless the certificate is distrusted for usage
+func (c certdbItem) trustedFor(usage whitelist.Usage) bool {
+	parts := strings.Split(c.trustAttrs, ",")
+	if len(parts) != len(nssTrustColumns) {
 		if debug {
 			fmt.Printf("store/nss: after trustAttrs split (in %d parts): %s\n", len(parts), parts)
 		}
@@ -264,7 +436,28 @@ func (c certdbItem) trustedForSSL() bool {
 	// The other flags refer to sending warnings (but still trusted), user certs,
 	// or other attributes which may limit, but not explicitly remove trust
 	// in regards to SSL/TLS communication.
-	return !strings.Contains(parts[0], "p")
+	for i := range nssTrustColumns {
+		if nssTrustColumns[i] == usage {
+			return !strings.Contains(parts[i], "p")
+		}
+	}
+	return false
+}
+
+// whitelistedTrust returns the trust attributes with every usage the certificate
+// isn't whitelisted for marked as prohibited, e.g. "p,C,p" for a CA only kept
+// for email.
+func (c certdbItem) whitelistedTrust(wh whitelist.Whitelist) string {
+	parts := strings.Split(c.trustAttrs, ",")
+	if len(parts) != len(nssTrustColumns) {
+		parts = make([]string, len(nssTrustColumns))
+	}
+	for i := range nssTrustColumns {
+		if !wh.MatchesAllUsage(c.certs, nssTrustColumns[i]) {
+			parts[i] = "p"
+		}
+	}
+	return strings.Join(parts, ",")
 }
 
 // crtutil represents the NSS cli tool by the same name
@@ -285,7 +478,7 @@ func (c crtutil) getExecPath() (string, error) {
 // Different versions of NSS/cert.db files require different prefixes
 // when passed to crtutil.
 func (c crtutil) appendScheme(where string) string {
-	if filepath.Base(where) == "cert9.db" {
+	if filepath.Base(where) == "cert9.db" || file.Exists(filepath.Join(where, "cert9.db")) {
 		return "sql:" + where
 	}
 	return "dbm:" + where
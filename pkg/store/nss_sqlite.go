@@ -0,0 +1,207 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/go-sqlite/sqlite3"
+)
+
+// cert9.db is a SQLite database (the NSS "shared db") where each PKCS#11 object is
+// a row in the nssPublic table. Columns are named after the attribute type in hex
+// ("a" + %x) and hold the raw attribute value. CK_ULONG values are written as 4 byte
+// big-endian integers and empty values as sqliteExplicitNull.
+//
+// Docs:
+// - https://wiki.mozilla.org/NSS_Shared_DB
+// - https://hg.mozilla.org/projects/nss/file/tip/lib/softoken/sdb.c
+const (
+	nssPublicTable = "nssPublic"
+
+	ckaClass                = 0x0
+	ckaLabel                = 0x3
+	ckaValue                = 0x11
+	ckaIssuer               = 0x81
+	ckaSerialNumber         = 0x82
+	ckaTrustServerAuth      = 0xce536358
+	ckaTrustClientAuth      = 0xce536359
+	ckaTrustCodeSigning     = 0xce53635a
+	ckaTrustEmailProtection = 0xce53635b
+
+	ckoCertificate = 0x1
+	ckoNSSTrust    = 0xce534353
+
+	cktNSSTrusted          = 0xce534351
+	cktNSSTrustedDelegator = 0xce534352
+	cktNSSNotTrusted       = 0xce53435a
+	cktNSSValidDelegator   = 0xce53435b
+)
+
+var sqliteExplicitNull = []byte{0xa5, 0x00, 0x5a}
+
+// nssObject is a row of nssPublic, keyed by attribute type
+type nssObject map[uint32][]byte
+
+func (o nssObject) ulong(attr uint32) (uint32, bool) {
+	v, ok := o[attr]
+	if !ok || len(v) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(v), true
+}
+
+// issuerSerial identifies the certificate an object refers to
+func (o nssObject) issuerSerial() string {
+	return string(o[ckaIssuer]) + string(o[ckaSerialNumber])
+}
+
+// readCertdb reads each certificate and its trust from the cert9.db in dir,
+// without needing NSS' certutil.
+func readCertdb(dir string) ([]certdbItem, error) {
+	db, err := sqlite3.Open(filepath.Join(dir, "cert9.db"))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	objects, err := readNSSObjects(db)
+	if err != nil {
+		return nil, err
+	}
+
+	// Trust objects are matched to certificates by issuer and serial number
+	trust := make(map[string]nssObject)
+	for i := range objects {
+		if class, _ := objects[i].ulong(ckaClass); class == ckoNSSTrust {
+			trust[objects[i].issuerSerial()] = objects[i]
+		}
+	}
+
+	var items []certdbItem
+	for i := range objects {
+		if class, _ := objects[i].ulong(ckaClass); class != ckoCertificate {
+			continue
+		}
+		cert, err := x509.ParseCertificate(objects[i][ckaValue])
+		if err != nil {
+			if debug {
+				fmt.Printf("store/nss: unable to parse certificate %q: %v\n", objects[i][ckaLabel], err)
+			}
+			continue
+		}
+		nick := string(objects[i][ckaLabel])
+		if nick == "" {
+			nick = certutil.StringifyPKIXName(cert.Subject)
+		}
+		items = append(items, certdbItem{
+			nick:       nick,
+			certs:      []*x509.Certificate{cert},
+			trustAttrs: nssTrustAttrs(trust[objects[i].issuerSerial()]),
+		})
+	}
+	return items, nil
+}
+
+// readNSSObjects returns each row of nssPublic
+func readNSSObjects(db *sqlite3.DbFile) ([]nssObject, error) {
+	// Map column indexes to attribute types
+	var attrs map[int]uint32
+	for _, table := range db.Tables() {
+		if table.Name() != nssPublicTable {
+			continue
+		}
+		attrs = make(map[int]uint32)
+		cols := table.Columns()
+		for i := range cols {
+			var attr uint32
+			if _, err := fmt.Sscanf(cols[i].Name(), "a%x", &attr); err == nil {
+				attrs[i] = attr
+			}
+		}
+	}
+	if attrs == nil {
+		return nil, fmt.Errorf("no %s table found", nssPublicTable)
+	}
+
+	var objects []nssObject
+	err := db.VisitTableRecords(nssPublicTable, func(_ *int64, rec sqlite3.Record) error {
+		obj := make(nssObject)
+		for i := range rec.Values {
+			attr, ok := attrs[i]
+			if !ok {
+				continue
+			}
+			var v []byte
+			switch value := rec.Values[i].(type) {
+			case []byte:
+				v = value
+			case string:
+				v = []byte(value)
+			default:
+				continue // NULL, the object doesn't have this attribute
+			}
+			if bytes.Equal(v, sqliteExplicitNull) {
+				v = []byte{}
+			}
+			obj[attr] = v
+		}
+		objects = append(objects, obj)
+		return nil
+	})
+	return objects, err
+}
+
+// nssTrustAttrs converts a trust object into the "SSL,S/MIME,JAR/XPI" flags
+// printed by `certutil -L`. Certificates without a trust object have ",,".
+func nssTrustAttrs(obj nssObject) string {
+	if obj == nil {
+		return ",,"
+	}
+	flags := func(attr uint32, delegator string) string {
+		t, _ := obj.ulong(attr)
+		switch t {
+		case cktNSSTrustedDelegator:
+			return delegator
+		case cktNSSValidDelegator:
+			return "c"
+		case cktNSSTrusted:
+			return "P"
+		case cktNSSNotTrusted:
+			return "p"
+		}
+		return ""
+	}
+
+	// The SSL column combines server and client auth, where "T" marks a CA
+	// trusted for client auth.
+	ssl := flags(ckaTrustServerAuth, "C")
+	for _, c := range flags(ckaTrustClientAuth, "T") {
+		if !strings.ContainsRune(ssl, c) {
+			ssl += string(c)
+		}
+	}
+	return strings.Join([]string{
+		ssl,
+		flags(ckaTrustEmailProtection, "C"),
+		flags(ckaTrustCodeSigning, "C"),
+	}, ",")
+}
@@ -19,6 +19,10 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
 )
 
 func TestStoreNSS_certdbDiscovery(t *testing.T) {
@@ -66,3 +70,165 @@ func TestStoreNSS_trustedForSSL(t *testing.T) {
 		}
 	}
 }
+
+func TestStoreNSS__readCertdb(t *testing.T) {
+	items, err := readCertdb(filepath.Join("..", "..", "testdata"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"Entrust.net Certification Authority (2048)": "C,C,",
+		"Entrust Root Certification Authority - G2":  "p,p,p",
+		"Entrust Root Certification Authority - EC1": ",,",
+		"Entrust Root Certification Authority":       "CT,,",
+		"EE Certification Centre Root CA":            "c,,",
+		"Starfield Secure Certification Authority":   "P,,",
+	}
+	if len(items) != len(expected) {
+		t.Fatalf("got %d items", len(items))
+	}
+	for i := range items {
+		if len(items[i].certs) != 1 {
+			t.Errorf("%s: got %d certs", items[i].nick, len(items[i].certs))
+		}
+		if attrs, ok := expected[items[i].nick]; !ok || attrs != items[i].trustAttrs {
+			t.Errorf("%s: got trust %q, expected %q", items[i].nick, items[i].trustAttrs, attrs)
+		}
+	}
+}
+
+func TestStoreNSS__list(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nss-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := file.CopyFile(filepath.Join("..", "..", "testdata", "cert9.db"), filepath.Join(dir, "cert9.db")); err != nil {
+		t.Fatal(err)
+	}
+
+	st := newNssStore("", "firefox", "", dir)
+	trusted, err := st.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trusted) != 5 {
+		t.Errorf("got %d trusted certificates", len(trusted))
+	}
+	all, err := st.List(&ListOptions{Untrusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 6 {
+		t.Errorf("got %d certificates", len(all))
+	}
+}
+
+func TestStoreNSS__backupRestore(t *testing.T) {
+	root, err := ioutil.TempDir("", "nss-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "profile")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.CopyFile(filepath.Join("..", "..", "testdata", "cert9.db"), filepath.Join(dir, "cert9.db")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pkcs11.txt"), []byte("library=\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	st := newNssStore(root, "firefox", "", dir)
+	if err := st.Backup(); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := st.GetLatestBackup()
+	if err != nil || latest == "" {
+		t.Fatalf("latest=%q err=%v", latest, err)
+	}
+	manifest, err := readNSSManifest(latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Source != "/profile" || len(manifest.Files) != 2 {
+		t.Errorf("got %#v", manifest)
+	}
+
+	// Changes are reverted
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert9.db"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Restore(""); err != nil {
+		t.Fatal(err)
+	}
+	if items, err := readCertdb(dir); err != nil || len(items) != 6 {
+		t.Errorf("got %d items, err=%v", len(items), err)
+	}
+
+	// Backups of another profile are refused
+	other := newNssStore(root, "firefox", "", filepath.Join(root, "other"))
+	if err := other.Restore(""); err == nil {
+		t.Error("expected error")
+	}
+
+	// Modified backups are refused
+	if err := ioutil.WriteFile(filepath.Join(latest, "pkcs11.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Restore(""); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestStoreNSS__trustedFor(t *testing.T) {
+	item := certdbItem{trustAttrs: "p,C,"}
+	if item.trustedFor(whitelist.UsageTLS) {
+		t.Error("shouldn't be trusted for TLS")
+	}
+	if !item.trustedFor(whitelist.UsageEmail) || !item.trustedFor(whitelist.UsageCodeSigning) {
+		t.Error("should be trusted for email and code signing")
+	}
+}
+
+func TestStoreNSS__whitelistedTrust(t *testing.T) {
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "example.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := certutil.GetHexSHA256Fingerprint(*certs[0])
+
+	cases := []struct {
+		wh       whitelist.Whitelist
+		expected string
+	}{
+		{whitelist.Whitelist{}, "p,p,p"},
+		{whitelist.Whitelist{Fingerprints: []string{fp}}, "CT,C,C"},
+		{whitelist.Whitelist{Usages: map[whitelist.Usage]whitelist.Items{
+			whitelist.UsageEmail: {Fingerprints: []string{fp}},
+		}}, "p,C,p"},
+		{whitelist.Whitelist{Usages: map[whitelist.Usage]whitelist.Items{
+			whitelist.UsageTLS: {Fingerprints: []string{fp}},
+		}}, "CT,p,p"},
+	}
+	for i := range cases {
+		item := certdbItem{certs: certs, trustAttrs: "CT,C,C"}
+		if attrs := item.whitelistedTrust(cases[i].wh); attrs != cases[i].expected {
+			t.Errorf("%d: got %q, expected %q", i, attrs, cases[i].expected)
+		}
+	}
+}
+
+func TestStoreNSS__listUsage(t *testing.T) {
+	st := newNssStore("", "firefox", "", filepath.Join("..", "..", "testdata"))
+	certs, err := st.List(&ListOptions{Trusted: true, Usage: whitelist.UsageEmail})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 5 {
+		t.Errorf("got %d certificates trusted for email", len(certs))
+	}
+}
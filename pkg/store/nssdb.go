@@ -0,0 +1,89 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+// nssdbLocations are the shared NSS databases, which many applications (e.g.
+// Chromium, curl and NetworkManager on Linux) read from.
+var nssdbLocations = []struct {
+	name string
+	path string
+	home bool // path is under the home directory
+}{
+	{name: "system", path: "/etc/pki/nssdb"},
+	{name: "user", path: ".pki/nssdb", home: true},
+}
+
+// NSSDBStore returns a Store for the shared system (/etc/pki/nssdb) and user
+// (~/.pki/nssdb) NSS databases. Each is available from Stores().
+//
+// Docs:
+// - https://wiki.mozilla.org/NSS_Shared_DB_And_LINUX
+func NSSDBStore(opts *Options) Store {
+	stores := nssdbStores(opts)
+	if len(stores) == 0 {
+		return emptyStore{}
+	}
+	return multiStore{
+		app:  "nssdb",
+		root: opts.root(),
+		info: Info{
+			Name: "NSS Shared DB",
+		},
+		stores: stores,
+		none:   fmt.Errorf("store/nssdb: no shared NSS databases found"),
+	}
+}
+
+// nssdbStoreAt returns one shared NSS database, addressed as `-app nssdb:<name>`
+// where <name> is system, user or the database's directory.
+func nssdbStoreAt(opts *Options, where string) (Store, error) {
+	stores := nssdbStores(opts)
+	for i := range stores {
+		st := stores[i].Store.(nssStore)
+		if stores[i].Name == "nssdb:"+where || where == unroot(st.root, st.foundCertdbLocation) {
+			return st, nil
+		}
+	}
+	return nil, fmt.Errorf("shared NSS database %q not found", where)
+}
+
+func nssdbStores(opts *Options) []NamedStore {
+	root := opts.root()
+
+	var out []NamedStore
+	for _, loc := range nssdbLocations {
+		where := filepath.Join(root, loc.path)
+		if loc.home {
+			where = filepath.Join(root, file.HomeDir(), loc.path)
+		}
+		if !containsCertdb(where) {
+			continue
+		}
+		st := newNssStore(root, "nssdb", "", where)
+		st.backupDir = filepath.Join("nssdb", loc.name)
+		out = append(out, NamedStore{
+			Name:  "nssdb:" + loc.name,
+			Store: st,
+		})
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+func TestStoreNSSDB__stores(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("nssdb paths are unix specific")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-nssdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	copyCertdb(t, filepath.Join(root, "etc", "pki", "nssdb"))
+	copyCertdb(t, filepath.Join(root, file.HomeDir(), ".pki", "nssdb"))
+
+	opts := &Options{Root: root}
+	ms, ok := NSSDBStore(opts).(MultiStore)
+	if !ok {
+		t.Fatal("expected a MultiStore")
+	}
+	stores := ms.Stores()
+	if len(stores) != 2 {
+		t.Fatalf("got %d stores", len(stores))
+	}
+	if stores[0].Name != "nssdb:system" || stores[1].Name != "nssdb:user" {
+		t.Errorf("got %s and %s", stores[0].Name, stores[1].Name)
+	}
+	if dir := stores[1].Store.(nssStore).backupDir; dir != filepath.Join("nssdb", "user") {
+		t.Errorf("got backup dir %s", dir)
+	}
+
+	for _, where := range []string{"system", "/etc/pki/nssdb"} {
+		st, err := ForApp("nssdb:"+where, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", where, err)
+		}
+		if loc := st.GetInfo().Location; loc != "/etc/pki/nssdb" {
+			t.Errorf("%s: got location %s", where, loc)
+		}
+	}
+	if _, err := ForApp("nssdb:other", opts); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -18,9 +18,12 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/adamdecaf/cert-manage/pkg/certutil"
 	"github.com/adamdecaf/cert-manage/pkg/file"
@@ -28,55 +31,164 @@ import (
 )
 
 var (
-	openSSLCertPaths = []string{
-		"/etc/ssl/certs/ca-certificates.crt", // Ubuntu
-		"/usr/local/etc/openssl/certs",       // Darwin/OSX
+	// openSSLDirs are common values of OPENSSLDIR, checked when `openssl version -d`
+	// doesn't work. Each contains a cert.pem bundle and/or a certs/ directory.
+	openSSLDirs = []string{
+		"/usr/lib/ssl",                // Debian, Ubuntu
+		"/etc/pki/tls",                // Fedora, RHEL, CentOS
+		"/etc/ssl",                    // Alpine, Arch
+		"/usr/local/etc/openssl@3",    // Darwin/OSX (homebrew)
+		"/usr/local/etc/openssl@1.1",  // Darwin/OSX (homebrew)
+		"/usr/local/etc/openssl",      // Darwin/OSX (homebrew)
+		"/opt/homebrew/etc/openssl@3", // Darwin/OSX (homebrew, arm64)
+		"/private/etc/ssl",            // Darwin/OSX (LibreSSL)
 		// `C:\Users\etc\openssl\certs`,         // Windows // TODO(adam)
 	}
 
-	openSSLRehashPaths = []string{
-		"/usr/bin/c_rehash",                   // Ubuntu
-		"/usr/local/opt/openssl/bin/c_rehash", // Darwin/OSX
-		// `C:\OpenSSL-Win32\bin\c_rehash`,       // Windows
-	}
+	opensslBackupDir = "openssl"
+
+	// opensslCertSuffixes are the file extensions c_rehash considers
+	opensslCertSuffixes = []string{".pem", ".crt", ".cer"}
 )
 
-type opensslStore struct{}
+type opensslStore struct {
+	// root is the filesystem root cert paths are under, empty for "/"
+	root string
+
+	// file is the CA bundle, SSL_CERT_FILE or $OPENSSLDIR/cert.pem
+	file string
+
+	// dir is the hashed certificate directory, SSL_CERT_DIR or $OPENSSLDIR/certs
+	dir string
+}
 
 // OpenSSLStore returns an implementation of Store for OpenSSL certificate stores
-func OpenSSLStore() Store {
-	return opensslStore{}
+//
+// The bundle file and certificate directory are found (in order) from the
+// SSL_CERT_FILE and SSL_CERT_DIR environment variables, `openssl version -d`
+// and then a list of common OPENSSLDIR locations. Under an alternate root only
+// the common locations are checked.
+func OpenSSLStore(opts *Options) Store {
+	s := opensslStore{
+		root: opts.root(),
+	}
+
+	if s.root == "" {
+		s.file = os.Getenv("SSL_CERT_FILE")
+		// SSL_CERT_DIR can be a list, just use the first
+		s.dir = strings.Split(os.Getenv("SSL_CERT_DIR"), string(os.PathListSeparator))[0]
+	}
+	if s.file != "" && s.dir != "" {
+		return s
+	}
+
+	dirs := openSSLDirs
+	if s.root == "" {
+		if dir := opensslDir(); dir != "" {
+			dirs = append([]string{dir}, dirs...)
+		}
+	}
+	for i := range dirs {
+		dir, err := file.JoinRoot(s.root, dirs[i])
+		if err != nil {
+			continue
+		}
+		bundle, certs := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "certs")
+		if !file.Exists(bundle) && !file.Exists(certs) {
+			continue
+		}
+		if s.file == "" && file.Exists(bundle) {
+			s.file = bundle
+		}
+		if s.dir == "" && file.Exists(certs) {
+			s.dir = certs
+		}
+		break
+	}
+	return s
 }
 
-func (s opensslStore) Add(certs []*x509.Certificate) error {
-	dir, err := s.findCertPath()
+// opensslDir returns OPENSSLDIR from `openssl version -d`, or an empty string
+func opensslDir() string {
+	out, err := exec.Command("openssl", "version", "-d").CombinedOutput()
 	if err != nil {
-		return err
+		if debug {
+			fmt.Printf("store/openssl: error getting OPENSSLDIR: %v\n", err)
+		}
+		return ""
+	}
+	return parseOpenSSLDir(string(out))
+}
+
+// parseOpenSSLDir reads the output of `openssl version -d`, which looks like
+// `OPENSSLDIR: "/usr/lib/ssl"`
+func parseOpenSSLDir(out string) string {
+	out = strings.TrimSpace(out)
+	if !strings.HasPrefix(out, "OPENSSLDIR:") {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(strings.TrimPrefix(out, "OPENSSLDIR:")), `"`)
+}
+
+func (s opensslStore) Add(certs []*x509.Certificate) error {
+	if s.dir == "" {
+		return errors.New("unable to find openssl cert directory")
 	}
 
 	for i := range certs {
 		fp := certutil.GetHexSHA256Fingerprint(*certs[i])
-		path := filepath.Join(dir, fmt.Sprintf("%s.crt", fp))
-		err = certutil.ToFile(path, certs[i:i+1])
+		path := filepath.Join(s.dir, fmt.Sprintf("%s.crt", fp))
+		err := certutil.ToFile(path, certs[i:i+1])
 		if err != nil {
 			return err
 		}
 	}
 	if len(certs) > 0 {
-		return s.rehash()
+		return s.rehash(s.dir)
 	}
 	return nil
 }
 
+// Backup copies the bundle file and certificate directory into a timestamped
+// directory under ~/.cert-manage/openssl/
 func (s opensslStore) Backup() error {
+	if s.file == "" && s.dir == "" {
+		return errors.New("unable to find openssl certificates")
+	}
+	dir, err := getCertManageDir(s.root, fmt.Sprintf("%s/%d", opensslBackupDir, time.Now().Unix()))
+	if err != nil {
+		return err
+	}
+	if s.file != "" {
+		if err := copyEntry(s.file, filepath.Join(dir, "cert.pem")); err != nil {
+			return err
+		}
+	}
+	if s.dir != "" {
+		return file.MirrorDir(s.dir, filepath.Join(dir, "certs"))
+	}
 	return nil
 }
 
+func (s opensslStore) kind() string {
+	return opensslBackupDir
+}
+
 func (s opensslStore) GetLatestBackup() (string, error) {
-	return "", nil
+	dir, err := certManageDir(s.root, opensslBackupDir)
+	if err != nil {
+		return "", fmt.Errorf("GetLatestBackup: error getting openssl backup directory, err=%v", err)
+	}
+	return findLatestBackup(dir)
 }
 
 func (s opensslStore) GetInfo() *Info {
+	if s.root != "" {
+		// Binaries aren't ran from an alternate root
+		return &Info{
+			Name: "OpenSSL",
+		}
+	}
 	out, err := exec.Command("openssl", "version").CombinedOutput()
 	if err != nil {
 		return &Info{ // just return something non-nil
@@ -93,49 +205,237 @@ func (s opensslStore) GetInfo() *Info {
 	}
 }
 
-func (s opensslStore) List(_ *ListOptions) ([]*x509.Certificate, error) {
-	return nil, nil
+// List returns the certificates from the bundle file and each certificate file
+// in the hashed directory.
+func (s opensslStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
+	pool := certutil.Pool{}
+	if s.file != "" {
+		certs, err := s.readFile(s.file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		pool.AddCertificates(certs)
+	}
+
+	files, err := s.certFiles()
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		certs, err := s.readFile(files[i])
+		if err != nil {
+			return nil, err
+		}
+		pool.AddCertificates(certs)
+	}
+	return opts.filter(pool.GetCertificates())
 }
 
+// Remove drops each certificate which isn't whitelisted.
+//
+// The bundle file is rewritten with the kept certificates. If it was a symlink
+// (e.g. to the Linux bundle) the link is replaced rather than its target.
+// Certificate files in the hashed directory are deleted and the directory is
+// rehashed.
 func (s opensslStore) Remove(wh whitelist.Whitelist) error {
+	if s.file != "" {
+		certs, err := s.readFile(s.file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var kept []*x509.Certificate
+		for i := range certs {
+			if wh.Matches(certs[i]) {
+				kept = append(kept, certs[i])
+			}
+		}
+		if len(kept) < len(certs) {
+			if err := replaceFile(s.file, kept); err != nil {
+				return err
+			}
+		}
+	}
+
+	files, err := s.certFiles()
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for i := range files {
+		certs, err := s.readFile(files[i])
+		if err != nil {
+			return err
+		}
+		if wh.Matches(certs[0]) {
+			continue
+		}
+		if err := os.Remove(files[i]); err != nil {
+			return err
+		}
+		if debug {
+			fmt.Printf("store/openssl: removed %s\n", files[i])
+		}
+		removed++
+	}
+	if removed > 0 {
+		return s.rehash(s.dir)
+	}
 	return nil
 }
 
+// restorePoint returns the backup to restore, which must be a directory with the
+// cert.pem file or certs directory
+func (s opensslStore) restorePoint(where string) (string, error) {
+	dir, err := certManageDir(s.root, opensslBackupDir)
+	if err != nil {
+		return "", err
+	}
+	src, err := findRestorePoint(s, s.root, dir, where)
+	if err != nil || src == "" {
+		return src, err
+	}
+	if fi, err := os.Stat(src); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("%s isn't a backup directory", src)
+	}
+	bundle, certs := filepath.Join(src, "cert.pem"), filepath.Join(src, "certs")
+	_, ferr := os.Lstat(bundle)
+	_, derr := os.Lstat(certs)
+	if ferr != nil && derr != nil {
+		return "", fmt.Errorf("%s isn't an openssl backup, cert.pem and certs/ are missing", src)
+	}
+	if !within(certManageParentDir(s.root), src) {
+		if ferr == nil {
+			if cs, err := certutil.FromFile(bundle); err != nil || len(cs) == 0 {
+				return "", fmt.Errorf("%s isn't an openssl backup, %s has no certificates", src, bundle)
+			}
+		}
+		if derr == nil {
+			if err := checkBackupDir(s.root, certs, certutil.FromFile); err != nil {
+				return "", err
+			}
+		}
+	}
+	return src, nil
+}
+
+// Restore replaces the bundle file and certificate directory from the latest backup
 func (s opensslStore) Restore(where string) error {
+	dir, err := s.restorePoint(where)
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		return errors.New("no openssl backup found")
+	}
+	if debug {
+		fmt.Printf("store/openssl: restoring from backup dir %s\n", dir)
+	}
+
+	if src := filepath.Join(dir, "cert.pem"); s.file != "" && file.Exists(src) {
+		if err := replaceEntry(src, s.file); err != nil {
+			return err
+		}
+	}
+	if src := filepath.Join(dir, "certs"); s.dir != "" && file.Exists(src) {
+		// Resolve the directory so a symlinked certs/ (e.g. /usr/lib/ssl/certs) is kept
+		dst, err := file.ResolveLinks(s.root, s.dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if dst == "" {
+			dst = s.dir
+		}
+		return replaceDir(src, dst)
+	}
 	return nil
 }
 
-// TODO(adam): What do we do if multiple exist
-func (s opensslStore) findCertPath() (string, error) {
-	for i := range openSSLCertPaths {
-		if file.Exists(openSSLCertPaths[i]) {
-			return openSSLCertPaths[i], nil
+// listBackup reads the certificates of the bundle file and directory Restore
+// would put back from backup
+func (s opensslStore) listBackup(backup string, opts *ListOptions) ([]*x509.Certificate, error) {
+	if src := filepath.Join(backup, "cert.pem"); s.file != "" && file.Exists(src) {
+		s.file = src
+	}
+	if src := filepath.Join(backup, "certs"); s.dir != "" && file.Exists(src) {
+		s.dir = src
+	}
+	return s.List(opts)
+}
+
+// readFile reads certificates from path, following symlinks under the store's root
+func (s opensslStore) readFile(path string) ([]*x509.Certificate, error) {
+	path, err := file.ResolveLinks(s.root, path)
+	if err != nil {
+		return nil, err
+	}
+	return certutil.FromFile(path)
+}
+
+// certFiles returns the files in the hashed directory which OpenSSL can use. Like
+// c_rehash only files holding a single certificate are included, which skips any
+// bundles that are also stored there. Hash links (<hash>.<n>) are skipped as
+// they're duplicates of the other files.
+func (s opensslStore) certFiles() ([]string, error) {
+	if s.dir == "" {
+		return nil, nil
+	}
+	fis, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var out []string
+	for i := range fis {
+		if fis[i].IsDir() || !hasCertSuffix(fis[i].Name()) {
+			continue
+		}
+		path := filepath.Join(s.dir, fis[i].Name())
+		certs, err := s.readFile(path)
+		if err != nil || len(certs) != 1 {
+			continue
 		}
+		out = append(out, path)
 	}
-	return "", errors.New("unable to find openssl cert directory")
+	return out, nil
 }
 
-func (s opensslStore) rehash() error {
-	var bin string
-	for i := range openSSLRehashPaths {
-		if file.Exists(openSSLRehashPaths[i]) {
-			bin = openSSLRehashPaths[i]
-			break
+func hasCertSuffix(name string) bool {
+	for i := range opensslCertSuffixes {
+		if strings.HasSuffix(name, opensslCertSuffixes[i]) {
+			return true
 		}
 	}
-	if bin == "" {
-		return errors.New("unable to find openssl c_rehash binary")
+	return false
+}
+
+// replaceFile writes certs to path, replacing (rather than writing through) a symlink
+func replaceFile(path string, certs []*x509.Certificate) error {
+	if _, err := os.Lstat(path); err != nil {
+		return err
 	}
+	return writeCertsAtomic(path, certs)
+}
 
-	// run c_rehash
-	cmd := exec.Command(bin)
-	out, err := cmd.CombinedOutput()
+// copyEntry copies the file at src to dst, symlinks are copied as symlinks
+func copyEntry(src, dst string) error {
+	fi, err := os.Lstat(src)
 	if err != nil {
-		if debug {
-			fmt.Printf("store/openssl: Command was: %s\n", strings.Join(cmd.Args, " "))
-			fmt.Printf("store/openssl: Output: %q\n", string(out))
-		}
 		return err
 	}
-	return nil
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return file.CopyFile(src, dst)
+	}
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, dst)
+}
+
+// rehash rebuilds the <hash>.<n> links in dir
+func (s opensslStore) rehash(dir string) error {
+	return certutil.Rehash(s.root, dir)
 }
@@ -15,28 +15,124 @@
 package store
 
 import (
-	"os/exec"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
-)
 
-func TestStoreOpenSSL__paths(t *testing.T) {
-	t.Skip("TODO(adam): fix")
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
 
-	err := exec.Command("openssl", "version").Run()
-	if err != nil {
-		t.Skipf("can't find openssl, err=%v", err)
+func TestStoreOpenSSL__parseOpenSSLDir(t *testing.T) {
+	cases := map[string]string{
+		`OPENSSLDIR: "/usr/lib/ssl"` + "\n":              "/usr/lib/ssl",
+		`OPENSSLDIR: "/private/etc/ssl"`:                 "/private/etc/ssl",
+		"openssl: command not found":                     "",
+		`OPENSSLDIR: "/usr/local/etc/openssl@1.1"` + " ": "/usr/local/etc/openssl@1.1",
+	}
+	for out, expected := range cases {
+		if dir := parseOpenSSLDir(out); dir != expected {
+			t.Errorf("got %q, expected %q (from %q)", dir, expected, out)
+		}
 	}
+}
+
+func TestStoreOpenSSL__root(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		t.Skip("openssl store unsupported on windows")
 	}
 
-	st := opensslStore{}
-	dir, err := st.findCertPath()
+	root, err := ioutil.TempDir("", "cert-manage-openssl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Setup an Arch style OPENSSLDIR, where cert.pem links to a bundle
+	// which is also stored in certs/ (and is skipped there).
+	sslDir := filepath.Join(root, "etc", "ssl")
+	if err := os.MkdirAll(filepath.Join(sslDir, "certs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := certutil.ToFile(filepath.Join(sslDir, "certs", "ca-certificates.crt"), certs[:3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/ssl/certs/ca-certificates.crt", filepath.Join(sslDir, "cert.pem")); err != nil {
+		t.Fatal(err)
+	}
+	if err := certutil.ToFile(filepath.Join(sslDir, "certs", "single.pem"), certs[3:4]); err != nil {
+		t.Fatal(err)
+	}
+
+	st := OpenSSLStore(&Options{Root: root})
+	s, ok := st.(opensslStore)
+	if !ok {
+		t.Fatalf("unexpected store %T", st)
+	}
+	if s.file != filepath.Join(sslDir, "cert.pem") || s.dir != filepath.Join(sslDir, "certs") {
+		t.Fatalf("file=%s dir=%s", s.file, s.dir)
+	}
+
+	found, err := s.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 4 {
+		t.Errorf("expected 4 certs, got %d", len(found))
+	}
+
+	if err := s.Backup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only keep the first and fourth certs, so the certs/ dir doesn't change
+	wh := whitelist.FromCertificates([]*x509.Certificate{certs[0], certs[3]})
+	if err := s.Remove(wh); err != nil {
+		t.Fatal(err)
+	}
+	found, err = s.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Errorf("expected 2 certs after whitelist, got %d", len(found))
+	}
+
+	// The symlink was replaced, not the bundle it pointed at
+	fi, err := os.Lstat(s.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("expected %s to be a regular file", s.file)
+	}
+	bundle, err := certutil.FromFile(filepath.Join(sslDir, "certs", "ca-certificates.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle) != 3 {
+		t.Errorf("expected linked bundle to be unchanged, got %d certs", len(bundle))
+	}
+
+	if err := s.Restore(""); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(s.file); err != nil || target != "/etc/ssl/certs/ca-certificates.crt" {
+		t.Errorf("expected cert.pem symlink to be restored, target=%q err=%v", target, err)
+	}
+	found, err = s.List(&ListOptions{Trusted: true})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if dir == "" {
-		t.Error("empty dir")
+	if len(found) != 4 {
+		t.Errorf("expected 4 certs after restore, got %d", len(found))
 	}
 }
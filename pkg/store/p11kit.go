@@ -0,0 +1,608 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+var (
+	// p11kitSources are the directories p11-kit's trust module reads from, in
+	// order. Records are written to the first of the writable ones which exists.
+	p11kitSources = []struct {
+		dir      string
+		writable bool
+	}{
+		{dir: "/usr/share/p11-kit"},
+		{dir: "/usr/share/pki/ca-trust-source"},                    // Fedora, RHEL, CentOS
+		{dir: "/usr/share/ca-certificates/trust-source"},           // Arch
+		{dir: "/etc/pki/ca-trust/source", writable: true},          // Fedora, RHEL, CentOS
+		{dir: "/etc/ca-certificates/trust-source", writable: true}, // Arch
+	}
+
+	// p11kitRefreshCmds extract the trust sources into bundles, the first found is ran
+	p11kitRefreshCmds = [][]string{
+		{"/usr/bin/update-ca-trust", "extract"},
+		{"/usr/bin/trust", "extract-compat"},
+	}
+
+	// p11kitRecordsFilename holds the anchor and distrust records cert-manage writes
+	p11kitRecordsFilename = "cert-manage.p11-kit"
+
+	p11kitBackupDir = "p11kit"
+)
+
+// p11kitCert is a certificate from the trust sources, along with its trust.
+// Distrust wins over being an anchor in any source.
+type p11kitCert struct {
+	cert       *x509.Certificate
+	anchor     bool
+	distrusted bool
+
+	// distrustedBy are the files which distrust the certificate
+	distrustedBy []string
+}
+
+type p11kitStore struct {
+	// root is the filesystem root the sources are under, empty for "/"
+	root string
+
+	// sources are the trust source directories which exist
+	sources []string
+
+	// dir is the source records are written to
+	dir string
+}
+
+// P11KitStore returns a Store for the trust sources of p11-kit's trust module
+// (e.g. /etc/pki/ca-trust/source), which NSS, GnuTLS and the extracted bundles
+// read from. Certificates are distrusted with `x-distrusted: true` records and
+// added as anchors rather than being deleted or copied around.
+//
+// Docs:
+// - https://p11-glue.github.io/p11-glue/p11-kit/manual/trust-module.html
+// - https://p11-glue.github.io/p11-glue/doc/storing-trust-policy/storing-trust-model.html
+func P11KitStore(opts *Options) Store {
+	s := p11kitStore{
+		root: opts.root(),
+	}
+	for _, src := range p11kitSources {
+		dir, err := file.JoinRoot(s.root, src.dir)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		s.sources = append(s.sources, dir)
+		if src.writable && s.dir == "" {
+			s.dir = dir
+		}
+	}
+	return s
+}
+
+func (s p11kitStore) recordsPath() string {
+	return filepath.Join(s.dir, p11kitRecordsFilename)
+}
+
+// Add writes an anchor record for each certificate, replacing any distrust record
+// cert-manage wrote. Certificates distrusted elsewhere (e.g. in a blocklist) can't
+// be anchored, as distrust always wins.
+func (s p11kitStore) Add(certs []*x509.Certificate) error {
+	if s.dir == "" {
+		return errors.New("no writable p11-kit trust source found")
+	}
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	records, err := s.readRecords()
+	if err != nil {
+		return err
+	}
+
+	for i := range certs {
+		if err := s.distrustedElsewhere(all, certs[i]); err != nil {
+			return err
+		}
+		records[certutil.GetHexSHA256Fingerprint(*certs[i])] = &p11kitCert{
+			cert:   certs[i],
+			anchor: true,
+		}
+	}
+	return s.writeRecords(records)
+}
+
+func (s p11kitStore) planAdd(certs []*x509.Certificate) ([]Change, error) {
+	if s.dir == "" {
+		return nil, errors.New("no writable p11-kit trust source found")
+	}
+	all, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	for i := range certs {
+		if err := s.distrustedElsewhere(all, certs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return addChanges(s, certs)
+}
+
+// distrustedElsewhere returns an error if cert is distrusted by a file other than
+// the records cert-manage writes
+func (s p11kitStore) distrustedElsewhere(all *p11kitCerts, cert *x509.Certificate) error {
+	c, ok := all.certs[certutil.GetHexSHA256Fingerprint(*cert)]
+	if !ok || !c.distrusted {
+		return nil
+	}
+	var others []string
+	for _, path := range c.distrustedBy {
+		if path != s.recordsPath() {
+			others = append(others, unroot(s.root, path))
+		}
+	}
+	if len(others) > 0 {
+		return fmt.Errorf("%s is distrusted by %s", certutil.StringifyPKIXName(cert.Subject), strings.Join(others, ", "))
+	}
+	return nil
+}
+
+// Backup copies the writable trust source into ~/.cert-manage/p11kit/<timestamp>/
+func (s p11kitStore) Backup() error {
+	if s.dir == "" {
+		return errors.New("no writable p11-kit trust source found")
+	}
+	dir, err := getCertManageDir(s.root, filepath.Join(p11kitBackupDir, fmt.Sprintf("%d", time.Now().Unix())))
+	if err != nil {
+		return err
+	}
+	return file.MirrorDir(s.dir, dir)
+}
+
+func (s p11kitStore) kind() string {
+	return p11kitBackupDir
+}
+
+func (s p11kitStore) GetLatestBackup() (string, error) {
+	dir, err := certManageDir(s.root, p11kitBackupDir)
+	if err != nil {
+		return "", fmt.Errorf("GetLatestBackup: error getting p11kit backup directory, err=%v", err)
+	}
+	return findLatestBackup(dir)
+}
+
+func (s p11kitStore) GetInfo() *Info {
+	return &Info{
+		Name:     "p11-kit",
+		Location: unroot(s.root, s.dir),
+	}
+}
+
+// List returns the anchors which aren't distrusted (ListOptions.Trusted) and the
+// distrusted certificates (ListOptions.Untrusted). Certificates in a source which
+// are neither (e.g. intermediates) aren't returned.
+func (s p11kitStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
+	if opts == nil {
+		opts = &ListOptions{Trusted: true}
+	}
+	all, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	var out []*x509.Certificate
+	for _, fp := range all.order {
+		c := all.certs[fp]
+		if (opts.Trusted && c.anchor && !c.distrusted) || (opts.Untrusted && c.distrusted) {
+			out = append(out, c.cert)
+		}
+	}
+	return opts.filter(out)
+}
+
+// Remove writes a distrust record for each anchor which isn't whitelisted
+func (s p11kitStore) Remove(wh whitelist.Whitelist) error {
+	if s.dir == "" {
+		return errors.New("no writable p11-kit trust source found")
+	}
+	trusted, err := s.List(&ListOptions{Trusted: true})
+	if err != nil {
+		return err
+	}
+	records, err := s.readRecords()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for i := range trusted {
+		if wh.Matches(trusted[i]) {
+			continue
+		}
+		records[certutil.GetHexSHA256Fingerprint(*trusted[i])] = &p11kitCert{
+			cert:       trusted[i],
+			distrusted: true,
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return s.writeRecords(records)
+}
+
+// distrusts is true as Remove writes distrust records
+func (s p11kitStore) distrusts() bool {
+	return true
+}
+
+// restorePoint returns the backup of the writable trust source to restore, which
+// must be a directory
+func (s p11kitStore) restorePoint(where string) (string, error) {
+	dir, err := certManageDir(s.root, p11kitBackupDir)
+	if err != nil {
+		return "", err
+	}
+	src, err := findRestorePoint(s, s.root, dir, where)
+	if err != nil || src == "" {
+		return src, err
+	}
+	return src, checkBackupDir(s.root, src, readP11KitCerts, "README*")
+}
+
+// Restore replaces the writable trust source with a backup, the latest unless
+// where is given
+func (s p11kitStore) Restore(where string) error {
+	if s.dir == "" {
+		return errors.New("no writable p11-kit trust source found")
+	}
+	src, err := s.restorePoint(where)
+	if err != nil {
+		return err
+	}
+	if src == "" {
+		return errors.New("no p11kit backup found")
+	}
+	if err := mirrorBackup(src, s.dir); err != nil {
+		return err
+	}
+	return s.refresh()
+}
+
+// listBackup reads the certificates of the trust sources with the writable source
+// replaced by backup
+func (s p11kitStore) listBackup(backup string, opts *ListOptions) ([]*x509.Certificate, error) {
+	if s.dir == "" {
+		return nil, errors.New("no writable p11-kit trust source found")
+	}
+	sources := make([]string, len(s.sources))
+	for i := range s.sources {
+		sources[i] = s.sources[i]
+		if sources[i] == s.dir {
+			sources[i] = backup
+		}
+	}
+	s.sources, s.dir = sources, backup
+	return s.List(opts)
+}
+
+// p11kitCerts are the certificates from every trust source, by fingerprint
+type p11kitCerts struct {
+	certs map[string]*p11kitCert
+	order []string
+}
+
+func (c *p11kitCerts) add(path string, obj p11kitObject, anchor, distrusted bool) {
+	fp := certutil.GetHexSHA256Fingerprint(*obj.cert)
+	cur, ok := c.certs[fp]
+	if !ok {
+		cur = &p11kitCert{cert: obj.cert}
+		c.certs[fp] = cur
+		c.order = append(c.order, fp)
+	}
+	cur.anchor = cur.anchor || anchor || obj.trusted
+	if distrusted || obj.distrusted {
+		cur.distrusted = true
+		cur.distrustedBy = append(cur.distrustedBy, path)
+	}
+}
+
+// read returns the certificates from every trust source. Files directly in a source
+// are read for their trust (.p11-kit objects or BEGIN TRUSTED CERTIFICATE), files
+// under anchors/ are trusted and files under blocklist/ are distrusted.
+func (s p11kitStore) read() (*p11kitCerts, error) {
+	out := &p11kitCerts{
+		certs: make(map[string]*p11kitCert),
+	}
+	for _, src := range s.sources {
+		dirs := []struct {
+			dir                string
+			anchor, distrusted bool
+		}{
+			{dir: src},
+			{dir: filepath.Join(src, "anchors"), anchor: true},
+			{dir: filepath.Join(src, "blocklist"), distrusted: true},
+			{dir: filepath.Join(src, "blacklist"), distrusted: true}, // older name of blocklist
+		}
+		for _, d := range dirs {
+			fis, err := ioutil.ReadDir(d.dir)
+			if err != nil {
+				continue
+			}
+			for i := range fis {
+				if fis[i].IsDir() {
+					continue
+				}
+				path := filepath.Join(d.dir, fis[i].Name())
+				objects, err := readP11KitFile(s.root, path)
+				if err != nil {
+					if debug {
+						fmt.Printf("store/p11kit: skipping %s: %v\n", path, err)
+					}
+					continue
+				}
+				for j := range objects {
+					out.add(path, objects[j], d.anchor, d.distrusted)
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// p11kitObject is a certificate read from a trust source file
+type p11kitObject struct {
+	cert *x509.Certificate
+
+	// trusted and distrusted are set from the object's attributes, e.g. `trusted: true`
+	trusted    bool
+	distrusted bool
+}
+
+// readP11KitCerts returns the certificates of a .p11-kit, PEM or DER file
+func readP11KitCerts(path string) ([]*x509.Certificate, error) {
+	objs, err := readP11KitFile("", path)
+	if err != nil {
+		return nil, err
+	}
+	var out []*x509.Certificate
+	for i := range objs {
+		if objs[i].cert != nil {
+			out = append(out, objs[i].cert)
+		}
+	}
+	return out, nil
+}
+
+// readP11KitFile reads the certificates from a .p11-kit file, or a PEM or DER file
+func readP11KitFile(root, path string) ([]p11kitObject, error) {
+	path, err := file.ResolveLinks(root, path)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(bs, []byte(p11kitObjectHeader)) {
+		return parseP11KitObjects(bs)
+	}
+
+	var out []p11kitObject
+	rest := bs
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		obj, err := parseP11KitPEM(block)
+		if err != nil {
+			return nil, err
+		}
+		if obj != nil {
+			out = append(out, *obj)
+		}
+	}
+	if len(out) == 0 {
+		certs, err := x509.ParseCertificates(bs)
+		if err != nil {
+			return nil, err
+		}
+		for i := range certs {
+			out = append(out, p11kitObject{cert: certs[i]})
+		}
+	}
+	return out, nil
+}
+
+// parseP11KitPEM reads a CERTIFICATE block, or a TRUSTED CERTIFICATE (OpenSSL's
+// format with trust settings after the certificate) which is trusted.
+func parseP11KitPEM(block *pem.Block) (*p11kitObject, error) {
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &p11kitObject{cert: cert}, nil
+
+	case "TRUSTED CERTIFICATE":
+		var raw asn1.RawValue
+		if _, err := asn1.Unmarshal(block.Bytes, &raw); err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		return &p11kitObject{cert: cert, trusted: true}, nil
+	}
+	return nil, nil
+}
+
+const p11kitObjectHeader = "[p11-kit-object-v1]"
+
+// parseP11KitObjects reads the certificate objects of a .p11-kit file, which look like:
+//
+//	[p11-kit-object-v1]
+//	class: certificate
+//	label: "Example Root CA"
+//	trusted: true
+//	-----BEGIN CERTIFICATE-----
+//	...
+//	-----END CERTIFICATE-----
+//
+// Other objects (e.g. x-certificate-extension) are skipped.
+func parseP11KitObjects(bs []byte) ([]p11kitObject, error) {
+	var out []p11kitObject
+	var attrs map[string]string
+	var der []byte
+	flush := func() error {
+		if attrs == nil || attrs["class"] != "certificate" || der == nil {
+			return nil
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		out = append(out, p11kitObject{
+			cert:       cert,
+			trusted:    attrs["trusted"] == "true",
+			distrusted: attrs["x-distrusted"] == "true",
+		})
+		return nil
+	}
+
+	var block bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case block.Len() > 0:
+			block.WriteString(line + "\n")
+			if strings.HasPrefix(line, "-----END ") {
+				if b, _ := pem.Decode(block.Bytes()); b != nil && b.Type == "CERTIFICATE" {
+					der = b.Bytes
+				}
+				block.Reset()
+			}
+		case strings.HasPrefix(line, "-----BEGIN "):
+			block.WriteString(line + "\n")
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case line == p11kitObjectHeader:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			attrs, der = make(map[string]string), nil
+		case attrs != nil:
+			if kv := strings.SplitN(line, ":", 2); len(kv) == 2 {
+				attrs[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// readRecords returns the anchor and distrust records cert-manage has written
+func (s p11kitStore) readRecords() (map[string]*p11kitCert, error) {
+	out := make(map[string]*p11kitCert)
+	objects, err := readP11KitFile(s.root, s.recordsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return out, nil
+		}
+		return nil, err
+	}
+	for i := range objects {
+		out[certutil.GetHexSHA256Fingerprint(*objects[i].cert)] = &p11kitCert{
+			cert:       objects[i].cert,
+			anchor:     objects[i].trusted,
+			distrusted: objects[i].distrusted,
+		}
+	}
+	return out, nil
+}
+
+// writeRecords replaces cert-manage's records file and refreshes the system's trust
+func (s p11kitStore) writeRecords(records map[string]*p11kitCert) error {
+	var fps []string
+	for fp := range records {
+		fps = append(fps, fp)
+	}
+	file.SortNames(fps)
+
+	var buf bytes.Buffer
+	buf.WriteString("# Trust records written by cert-manage\n")
+	for _, fp := range fps {
+		r := records[fp]
+		buf.WriteString("\n" + p11kitObjectHeader + "\n")
+		buf.WriteString("class: certificate\n")
+		buf.WriteString("certificate-type: x-509\n")
+		fmt.Fprintf(&buf, "label: \"%s\"\n", strings.Replace(certutil.StringifyPKIXName(r.cert.Subject), `"`, "", -1))
+		if r.distrusted {
+			buf.WriteString("x-distrusted: true\n")
+		} else {
+			buf.WriteString("trusted: true\n")
+		}
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: r.cert.Raw}); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(s.recordsPath(), buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if debug {
+		fmt.Printf("store/p11kit: wrote %d records to %s\n", len(records), s.recordsPath())
+	}
+	return s.refresh()
+}
+
+// refresh runs update-ca-trust (or trust extract-compat) so the extracted bundles
+// pick up changes to the trust sources
+func (s p11kitStore) refresh() error {
+	for _, args := range p11kitRefreshCmds {
+		if file.Exists(filepath.Join(s.root, args[0])) {
+			return runRefresh(s.root, args)
+		}
+	}
+	if debug {
+		fmt.Println("store/p11kit: no refresh command found")
+	}
+	return nil
+}
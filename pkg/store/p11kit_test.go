@@ -0,0 +1,167 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+// p11kitBundle returns a .p11-kit file of certs, each with the given trust
+// attribute (e.g. trusted: true) and followed by an extension object
+func p11kitBundle(t *testing.T, certs []*x509.Certificate, attr string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for i := range certs {
+		fmt.Fprintf(&buf, "[p11-kit-object-v1]\nclass: certificate\nlabel: \"cert %d\"\n%s\nmodifiable: false\n", i, attr)
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: certs[i].Raw}); err != nil {
+			t.Fatal(err)
+		}
+		buf.WriteString("\n[p11-kit-object-v1]\nclass: x-certificate-extension\nlabel: \"ext\"\nobject-id: 2.5.29.37\nvalue: \"%30%0a\"\n\n")
+	}
+	return buf.Bytes()
+}
+
+func TestStoreP11Kit__parseObjects(t *testing.T) {
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := parseP11KitObjects(p11kitBundle(t, certs[:2], "x-distrusted: true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("got %d objects", len(objects))
+	}
+	if !objects[0].distrusted || objects[0].trusted || !objects[1].cert.Equal(certs[1]) {
+		t.Errorf("got %#v", objects[0])
+	}
+
+	// OpenSSL's trusted certificate format, with trust settings after the certificate
+	trusted := append(append([]byte{}, certs[0].Raw...), 0x30, 0x00)
+	obj, err := parseP11KitPEM(&pem.Block{Type: "TRUSTED CERTIFICATE", Bytes: trusted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !obj.trusted || !obj.cert.Equal(certs[0]) {
+		t.Errorf("got %#v", obj)
+	}
+}
+
+func TestStoreP11Kit__trust(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("p11-kit is unix specific")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-p11kit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fedora style: the distro's bundle, an anchor and a blocklisted anchor
+	share := filepath.Join(root, "usr", "share", "pki", "ca-trust-source")
+	source := filepath.Join(root, "etc", "pki", "ca-trust", "source")
+	for _, dir := range []string{share, filepath.Join(source, "anchors"), filepath.Join(source, "blocklist")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(share, "ca-bundle.trust.p11-kit"), p11kitBundle(t, certs[:3], "trusted: true"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := certutil.ToFile(filepath.Join(source, "anchors", "corp.pem"), certs[3:4]); err != nil {
+		t.Fatal(err)
+	}
+	if err := certutil.ToFile(filepath.Join(source, "blocklist", "bad.pem"), certs[2:3]); err != nil {
+		t.Fatal(err)
+	}
+
+	st := P11KitStore(&Options{Root: root})
+	s, ok := st.(p11kitStore)
+	if !ok || s.dir != source {
+		t.Fatalf("got %#v", st)
+	}
+	count := func(lopts *ListOptions) int {
+		found, err := st.List(lopts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(found)
+	}
+	if n := count(&ListOptions{Trusted: true}); n != 3 {
+		t.Errorf("got %d trusted", n)
+	}
+	if n := count(&ListOptions{Untrusted: true}); n != 1 {
+		t.Errorf("got %d untrusted", n)
+	}
+
+	if err := st.Backup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Distrust everything but the first certificate
+	if err := st.Remove(whitelist.FromCertificates([]*x509.Certificate{certs[0]})); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(&ListOptions{Trusted: true}); n != 1 {
+		t.Errorf("got %d trusted after whitelist", n)
+	}
+	if n := count(&ListOptions{Untrusted: true}); n != 3 {
+		t.Errorf("got %d untrusted after whitelist", n)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(source, p11kitRecordsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(bs), "x-distrusted: true"); n != 2 {
+		t.Errorf("got %d distrust records", n)
+	}
+
+	// Certificates we distrusted can be anchored again, but not blocklisted ones
+	if err := st.Add(certs[1:2]); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(&ListOptions{Trusted: true}); n != 2 {
+		t.Errorf("got %d trusted after add", n)
+	}
+	if err := st.Add(certs[2:3]); err == nil || !strings.Contains(err.Error(), "blocklist/bad.pem") {
+		t.Errorf("expected blocklist error, got %v", err)
+	}
+
+	if err := st.Restore(""); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(&ListOptions{Trusted: true}); n != 3 {
+		t.Errorf("got %d trusted after restore", n)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/x509"
+	"errors"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+// Action is how a change alters the trust of a certificate in a store
+type Action string
+
+const (
+	ActionAdd      Action = "add"
+	ActionRemove   Action = "remove"
+	ActionDistrust Action = "distrust"
+)
+
+// Change is a certificate which would be added, removed or distrusted by
+// mutating a store.
+type Change struct {
+	Action Action
+
+	// Usage is set for stores which keep trust for each usage (NSS), as trust
+	// may only change for some usages.
+	Usage whitelist.Usage
+
+	Certificate *x509.Certificate
+}
+
+// ErrRestoreNotPlanned is returned by PlanRestore when the certificates of a
+// store's backups can't be read, so only the backup being restored is known.
+var ErrRestoreNotPlanned = errors.New("certificates in backup can't be read")
+
+// removePlanner and addPlanner are implemented by stores whose changes differ
+// from dropping each trusted certificate which isn't whitelisted, or trusting each
+// certificate which isn't trusted yet.
+type removePlanner interface {
+	planRemove(wh whitelist.Whitelist) ([]Change, error)
+}
+
+type addPlanner interface {
+	planAdd(certs []*x509.Certificate) ([]Change, error)
+}
+
+// distruster is implemented by stores which keep certificates around when
+// Remove drops trust in them (e.g. with distrust records)
+type distruster interface {
+	distrusts() bool
+}
+
+// backupLister is implemented by stores which can read the trusted certificates
+// of a backup, as they would be after restoring it.
+type backupLister interface {
+	listBackup(backup string, opts *ListOptions) ([]*x509.Certificate, error)
+}
+
+// PlanRemove returns the changes s.Remove(wh) would make, without changing the store
+func PlanRemove(s Store, wh whitelist.Whitelist) ([]Change, error) {
+	if p, ok := s.(removePlanner); ok {
+		return p.planRemove(wh)
+	}
+	action := ActionRemove
+	if d, ok := s.(distruster); ok && d.distrusts() {
+		action = ActionDistrust
+	}
+
+	var out []Change
+	for _, usage := range planUsages(s) {
+		trusted, err := s.List(&ListOptions{
+			Trusted: true,
+			Usage:   usage,
+		})
+		if err != nil {
+			return nil, err
+		}
+		trusted = dedupCerts(trusted)
+		for i := range trusted {
+			if usage == "" && wh.Matches(trusted[i]) || usage != "" && wh.MatchesUsage(trusted[i], usage) {
+				continue
+			}
+			out = append(out, Change{
+				Action:      action,
+				Usage:       usage,
+				Certificate: trusted[i],
+			})
+		}
+	}
+	return out, nil
+}
+
+// PlanAdd returns the changes s.Add(certs) would make, without changing the store
+func PlanAdd(s Store, certs []*x509.Certificate) ([]Change, error) {
+	if p, ok := s.(addPlanner); ok {
+		return p.planAdd(certs)
+	}
+	return addChanges(s, certs)
+}
+
+// addChanges returns an ActionAdd change for each certificate s doesn't trust
+func addChanges(s Store, certs []*x509.Certificate) ([]Change, error) {
+	trusted, err := s.List(&ListOptions{
+		Trusted: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diffChanges(trusted, certs, "")[ActionAdd], nil
+}
+
+// PlanRestore returns the backup s.Restore(where) restores and the changes restoring
+// it would make without changing the store. If the store's backups can't be read
+// the backup is returned along with ErrRestoreNotPlanned.
+func PlanRestore(s Store, where string) (string, []Change, error) {
+	backup, err := RestorePoint(s, where)
+	if err != nil {
+		return "", nil, err
+	}
+	if backup == "" {
+		return "", nil, errors.New("no backup found")
+	}
+	lister, ok := s.(backupLister)
+	if !ok {
+		return backup, nil, ErrRestoreNotPlanned
+	}
+
+	var out []Change
+	for _, usage := range planUsages(s) {
+		opts := &ListOptions{
+			Trusted: true,
+			Usage:   usage,
+		}
+		before, err := s.List(opts)
+		if err != nil {
+			return backup, nil, err
+		}
+		after, err := lister.listBackup(backup, opts)
+		if err != nil {
+			return backup, nil, err
+		}
+		changes := diffChanges(before, after, usage)
+		out = append(out, changes[ActionRemove]...)
+		out = append(out, changes[ActionAdd]...)
+	}
+	return backup, out, nil
+}
+
+// planUsages returns the usages a store keeps trust for, which is only the
+// default usage (as the empty string) for most stores.
+func planUsages(s Store) []whitelist.Usage {
+	if _, ok := s.(nssStore); ok {
+		return nssTrustColumns
+	}
+	return []whitelist.Usage{""}
+}
+
+// diffChanges returns the certificates in after which aren't in before (ActionAdd)
+// and those in before which aren't in after (ActionRemove).
+func diffChanges(before, after []*x509.Certificate, usage whitelist.Usage) map[Action][]Change {
+	out := make(map[Action][]Change)
+	diff := func(action Action, from, to []*x509.Certificate) {
+		seen := make(map[string]bool, len(to))
+		for i := range to {
+			seen[certutil.GetHexSHA256Fingerprint(*to[i])] = true
+		}
+		for i := range from {
+			if !seen[certutil.GetHexSHA256Fingerprint(*from[i])] {
+				out[action] = append(out[action], Change{
+					Action:      action,
+					Usage:       usage,
+					Certificate: from[i],
+				})
+			}
+		}
+	}
+	diff(ActionAdd, dedupCerts(after), before)
+	diff(ActionRemove, dedupCerts(before), after)
+	return out
+}
+
+func dedupCerts(certs []*x509.Certificate) []*x509.Certificate {
+	pool := certutil.Pool{}
+	for i := range certs {
+		pool.Add(certs[i]) // one at a time, so duplicates in certs are dropped
+	}
+	return pool.GetCertificates()
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/whitelist"
+)
+
+func TestStore__plan(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "ca.pem")
+	if err := certutil.ToFile(path, certs[:3]); err != nil {
+		t.Fatal(err)
+	}
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := python.bundle(root, "", path)
+	wh := whitelist.FromCertificates(certs[:1])
+
+	// Restoring needs a backup, which planning doesn't make
+	if _, _, err := PlanRestore(st, ""); err == nil {
+		t.Error("expected error without a backup")
+	}
+	if dir, err := certManageDir(root, st.backupDir()); err != nil || file.Exists(dir) {
+		t.Errorf("expected %s to not be created, err=%v", dir, err)
+	}
+
+	changes, err := PlanRemove(st, wh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Action != ActionRemove || !changes[0].Certificate.Equal(certs[1]) || !changes[1].Certificate.Equal(certs[2]) {
+		t.Errorf("got %#v", changes)
+	}
+	changes, err = PlanAdd(st, []*x509.Certificate{certs[2], certs[3], certs[3]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Action != ActionAdd || !changes[0].Certificate.Equal(certs[3]) {
+		t.Errorf("got %#v", changes)
+	}
+	if after, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(before, after) {
+		t.Errorf("expected bundle to be unchanged, err=%v", err)
+	}
+
+	// After whitelisting restoring brings back the removed certificates
+	if err := st.Backup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Remove(wh); err != nil {
+		t.Fatal(err)
+	}
+	backup, changes, err := PlanRestore(st, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(backup) != filepath.Join(certManageParentDir(root), st.backupDir()) {
+		t.Errorf("got backup %s", backup)
+	}
+	if len(changes) != 2 || changes[0].Action != ActionAdd || !changes[0].Certificate.Equal(certs[1]) {
+		t.Errorf("got %#v", changes)
+	}
+
+	// Stores whose backups can't be read only give the backup
+	ms := multiStore{
+		app:  "python",
+		root: root,
+		stores: []NamedStore{
+			{Name: "python:/ca.pem", Store: st},
+		},
+	}
+	if backup, _, err := PlanRestore(ms, ""); err != ErrRestoreNotPlanned || backup == "" {
+		t.Errorf("got backup=%q err=%v", backup, err)
+	}
+}
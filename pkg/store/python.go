@@ -0,0 +1,150 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+var (
+	python = bundleApp{
+		name:    "python",
+		title:   "Python",
+		find:    pythonBundles,
+		version: pythonVersion,
+	}
+
+	// pythonSitePackages are the site-packages directories of system installs
+	pythonSitePackages = []string{
+		"/usr/lib/python3/dist-packages",                                             // Debian, Ubuntu
+		"/usr/lib/python3*/site-packages",                                            // Fedora, Alpine, Arch
+		"/usr/lib64/python3*/site-packages",                                          // Fedora, RHEL
+		"/usr/local/lib/python3*/site-packages",                                      // source installs
+		"/usr/local/lib/python3*/dist-packages",                                      // pip on Debian
+		"/opt/homebrew/lib/python3*/site-packages",                                   // Darwin/OSX (homebrew, arm64)
+		"/Library/Frameworks/Python.framework/Versions/*/lib/python3*/site-packages", // Darwin/OSX (python.org)
+	}
+
+	// pythonUserSitePackages are site-packages directories under the home directory
+	pythonUserSitePackages = []string{
+		".local/lib/python3*/site-packages",             // pip install --user
+		"Library/Python/*/lib/python/site-packages",     // Darwin/OSX, pip install --user
+		"AppData/Roaming/Python/Python3*/site-packages", // Windows, pip install --user
+		".pyenv/versions/*/lib/python3*/site-packages",  // pyenv
+	}
+
+	// pythonUserVenvs are where virtualenv tools keep their venvs, under the home directory
+	pythonUserVenvs = []string{
+		".virtualenvs/*",                // virtualenvwrapper
+		".local/share/virtualenvs/*",    // pipenv
+		".cache/pypoetry/virtualenvs/*", // poetry
+	}
+
+	// pythonVenvSitePackages are the site-packages directories inside of a venv
+	pythonVenvSitePackages = []string{
+		"lib/python3*/site-packages",
+		"Lib/site-packages", // Windows
+	}
+
+	// pythonCertifiBundles are the certifi bundles inside of a site-packages directory
+	pythonCertifiBundles = []string{
+		"certifi/cacert.pem",
+		"pip/_vendor/certifi/cacert.pem",
+	}
+
+	// pythonVenvSearchDepth limits how deep venvs are searched for under Options.VenvDirs
+	pythonVenvSearchDepth = 4
+
+	pythonBinaryPaths = []string{
+		"/usr/bin/python3",
+		"/usr/local/bin/python3",
+		"/opt/homebrew/bin/python3",
+	}
+)
+
+// PythonStore returns a Store for the certifi CA bundles (used by requests, pip
+// and others) of each Python install and virtualenv. Each bundle is available
+// from Stores().
+//
+// Bundles are found in the system and user site-packages directories, the active
+// venv (VIRTUAL_ENV), common virtualenv locations, venvs under Options.VenvDirs and
+// REQUESTS_CA_BUNDLE.
+func PythonStore(opts *Options) Store {
+	return python.store(opts)
+}
+
+func pythonBundles(opts *Options) []string {
+	root := opts.root()
+	home := filepath.Join(root, file.HomeDir())
+
+	dirs := globAll(root, pythonSitePackages)
+	dirs = append(dirs, globAll(home, pythonUserSitePackages)...)
+
+	venvs := globAll(home, pythonUserVenvs)
+	if env := envPath(root, "VIRTUAL_ENV"); env != "" {
+		venvs = append([]string{env}, venvs...)
+	}
+	if opts != nil {
+		for i := range opts.VenvDirs {
+			venvs = append(venvs, findPythonVenvs(filepath.Join(root, opts.VenvDirs[i]), pythonVenvSearchDepth)...)
+		}
+	}
+	for i := range venvs {
+		dirs = append(dirs, globAll(venvs[i], pythonVenvSitePackages)...)
+	}
+
+	var out []string
+	if env := envPath(root, "REQUESTS_CA_BUNDLE"); env != "" {
+		out = append(out, env)
+	}
+	for i := range dirs {
+		out = append(out, globAll(dirs[i], pythonCertifiBundles)...)
+	}
+	return out
+}
+
+// findPythonVenvs returns each directory under dir (including dir) which is a
+// venv, found by its pyvenv.cfg file. Venvs aren't searched for inside of other venvs.
+func findPythonVenvs(dir string, depth int) []string {
+	if file.Exists(filepath.Join(dir, "pyvenv.cfg")) {
+		return []string{dir}
+	}
+	if depth <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for i := range entries {
+		name := entries[i].Name()
+		if !entries[i].IsDir() || name == "node_modules" || (strings.HasPrefix(name, ".") && name != ".venv") {
+			continue
+		}
+		out = append(out, findPythonVenvs(filepath.Join(dir, name), depth-1)...)
+	}
+	return out
+}
+
+// pythonVersion returns the installed version of python3
+func pythonVersion(root string) string {
+	// returns "Python 3.11.4"
+	return binaryVersion(root, pythonBinaryPaths, "--version", "Python")
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+func TestStorePython__bundles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("site-packages paths are unix specific")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	home := filepath.Join(root, file.HomeDir())
+	bundles := []string{
+		filepath.Join(root, "usr", "lib", "python3.11", "site-packages", "certifi", "cacert.pem"),
+		filepath.Join(home, ".local", "lib", "python3.11", "site-packages", "pip", "_vendor", "certifi", "cacert.pem"),
+		filepath.Join(root, "srv", "app", ".venv", "lib", "python3.11", "site-packages", "certifi", "cacert.pem"),
+		filepath.Join(root, "srv", "api", "env", "lib", "python3.10", "site-packages", "certifi", "cacert.pem"),
+	}
+	for i := range bundles {
+		if err := os.MkdirAll(filepath.Dir(bundles[i]), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := file.CopyFile(filepath.Join("..", "..", "testdata", "lots.crt"), bundles[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, venv := range []string{"srv/app/.venv", "srv/api/env"} {
+		if err := ioutil.WriteFile(filepath.Join(root, venv, "pyvenv.cfg"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Venvs are only found under VenvDirs
+	ms := PythonStore(&Options{Root: root}).(MultiStore)
+	if n := len(ms.Stores()); n != 2 {
+		t.Errorf("got %d stores", n)
+	}
+
+	opts := &Options{Root: root, VenvDirs: []string{"/srv"}}
+	ms = PythonStore(opts).(MultiStore)
+	stores := ms.Stores()
+	if len(stores) != 4 {
+		t.Fatalf("got %d stores: %v", len(stores), stores)
+	}
+	if expected := "python:" + unroot(root, bundles[0]); stores[0].Name != expected {
+		t.Errorf("got %s, expected %s", stores[0].Name, expected)
+	}
+
+	certs, err := ms.List(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 5 {
+		t.Errorf("got %d certificates", len(certs))
+	}
+
+	// Each is also in -app bundles, and one can be picked by path
+	if n := len(BundlesStore(opts).(MultiStore).Stores()); n != 4 {
+		t.Errorf("got %d bundles", n)
+	}
+	st, err := ForApp("python:"+unroot(root, bundles[2]), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := st.GetInfo().Location; loc != unroot(root, bundles[2]) {
+		t.Errorf("got location %s", loc)
+	}
+	if _, err := ForApp("python:/missing.pem", opts); err == nil {
+		t.Error("expected error")
+	}
+}
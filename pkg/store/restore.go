@@ -0,0 +1,174 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+// restorePointer is implemented by stores which can check a backup is theirs
+// before Restore changes anything. The backup Restore(where) would restore is
+// returned, which is empty when where is empty and no backups have been made.
+type restorePointer interface {
+	restorePoint(where string) (string, error)
+}
+
+// RestorePoint returns the backup s.Restore(where) would restore. where is either
+// empty for the latest backup, a backup ID from the BackupIndex, the path of a
+// backup file or directory, an archive (see WriteArchive) or an unpacked archive
+// (see UnpackArchive). An error is returned if the backup isn't of s.
+func RestorePoint(s Store, where string) (string, error) {
+	if r, ok := s.(restorePointer); ok {
+		return r.restorePoint(where)
+	}
+	if where != "" {
+		return "", errors.New("restoring a specific backup isn't supported")
+	}
+	return s.GetLatestBackup()
+}
+
+// findRestorePoint returns the backup of s to restore from dir, which is where s
+// keeps its backups. An empty where is the latest backup in dir, otherwise where
+// is a path, an archive or the ID of a backup in the BackupIndex. Backups kept by
+// cert-manage must be in dir, so another store's backup isn't restored.
+func findRestorePoint(s Store, root, dir, where string) (string, error) {
+	if where == "" {
+		return findLatestBackup(dir)
+	}
+	if IsArchive(where) && file.Exists(where) {
+		return "", fmt.Errorf("%s is an archive, it must be unpacked with UnpackArchive first", where)
+	}
+	if isArchiveDir(where) {
+		return archiveRestorePoint(s, where)
+	}
+
+	parent := certManageParentDir(root)
+	if _, err := os.Lstat(where); err == nil {
+		path, err := filepath.Abs(where)
+		if err != nil {
+			return "", err
+		}
+		snapshots := filepath.Join(parent, snapshotsDir)
+		if within(parent, path) && !within(dir, path) && !within(snapshots, path) {
+			return "", fmt.Errorf("%s isn't a backup of this store", where)
+		}
+		return path, nil
+	}
+
+	idx, err := OpenBackupIndex(&Options{Root: root})
+	if err != nil {
+		return "", err
+	}
+	b, err := idx.Find(where)
+	if err != nil {
+		return "", fmt.Errorf("%s isn't a backup file or directory and %v", where, err)
+	}
+	path := idx.Location(b)
+	if !within(dir, path) {
+		return "", fmt.Errorf("backup %s is of %s, not this store", b.ID, b.Store)
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return "", fmt.Errorf("backup %s is missing, %s not found", b.ID, path)
+	}
+	return path, nil
+}
+
+// within returns true if path is dir or is under it
+func within(dir, path string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkBackupDir returns an error unless path is a directory. Directories outside
+// of ~/.cert-manage weren't made by cert-manage, so they must only hold files read
+// can parse (other than those matching one of the ignore patterns) and at least
+// one CA certificate. Otherwise a directory like /tmp would replace the store.
+func checkBackupDir(root, path string, read func(string) ([]*x509.Certificate, error), ignore ...string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s isn't a backup directory", path)
+	}
+	if within(certManageParentDir(root), path) {
+		return nil
+	}
+
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	cas := 0
+	err = filepath.Walk(real, func(where string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		for i := range ignore {
+			if ok, _ := filepath.Match(ignore[i], info.Name()); ok {
+				return nil
+			}
+		}
+		certs, err := read(where)
+		if err != nil || len(certs) == 0 {
+			return fmt.Errorf("%s isn't a backup, %s isn't a certificate", path, where)
+		}
+		for i := range certs {
+			if certs[i].IsCA {
+				cas++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if cas == 0 {
+		return fmt.Errorf("%s isn't a backup, it has no CA certificates", path)
+	}
+	return nil
+}
+
+// matchRestore returns the stores a backup path or ID is of, which is usually
+// one store. An error describing why each store didn't match is returned when
+// none do.
+func matchRestore(stores []NamedStore, where string) ([]NamedStore, error) {
+	var out []NamedStore
+	var problems []string
+	for i := range stores {
+		if _, err := RestorePoint(stores[i].Store, where); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", stores[i].Name, err))
+			continue
+		}
+		out = append(out, stores[i])
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s can't be restored to any store, %s", where, strings.Join(problems, ", "))
+	}
+	return out, nil
+}
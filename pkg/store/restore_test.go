@@ -0,0 +1,176 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+func TestStore__RestorePoint(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	one, two := filepath.Join(root, "one.pem"), filepath.Join(root, "two.pem")
+	for _, path := range []string{one, two} {
+		if err := certutil.ToFile(path, certs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st1, st2 := python.bundle(root, "", one), python.bundle(root, "", two)
+	ms := multiStore{
+		app:  "python",
+		root: root,
+		stores: []NamedStore{
+			{Name: "python:/one.pem", Store: st1},
+			{Name: "python:/two.pem", Store: st2},
+		},
+	}
+
+	// Back up both bundles and record the backups
+	idx, err := OpenBackupIndex(&Options{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Backup(); err != nil {
+		t.Fatal(err)
+	}
+	b1, err := idx.Record("python:/one.pem", st1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Record("python:/two.pem", st2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Backups are found by their ID or path, but only by the store they're of
+	if backup, err := RestorePoint(st1, b1.ID); err != nil || backup != idx.Location(b1) {
+		t.Errorf("got %q err=%v", backup, err)
+	}
+	if backup, err := RestorePoint(st1, idx.Location(b1)); err != nil || backup != idx.Location(b1) {
+		t.Errorf("got %q err=%v", backup, err)
+	}
+	if _, err := RestorePoint(st2, b1.ID); err == nil || !strings.Contains(err.Error(), "not this store") {
+		t.Errorf("expected error, got %v", err)
+	}
+	if _, err := RestorePoint(st2, idx.Location(b1)); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := RestorePoint(st1, "zzzz"); err == nil {
+		t.Error("expected error")
+	}
+
+	// Files outside ~/.cert-manage must be a backup of the store's type
+	empty := filepath.Join(root, "empty.pem")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestorePoint(st1, empty); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := RestorePoint(st1, root); err == nil {
+		t.Error("expected error")
+	}
+
+	// Restoring a backup by ID only restores the store it's of
+	if err := certutil.ToFile(one, certs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := certutil.ToFile(two, certs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Restore(b1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := certutil.FromFile(one); err != nil || len(found) != len(certs) {
+		t.Errorf("got %d certs, err=%v", len(found), err)
+	}
+	if found, err := certutil.FromFile(two); err != nil || len(found) != 1 {
+		t.Errorf("got %d certs, err=%v", len(found), err)
+	}
+	if err := ms.Restore("zzzz"); err == nil {
+		t.Error("expected error")
+	}
+
+	// A bundle outside ~/.cert-manage could be restored to either store
+	if err := ms.Restore(one); err == nil || !strings.Contains(err.Error(), "choose one") {
+		t.Errorf("expected error, got %v", err)
+	}
+	if err := st2.Restore(one); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := certutil.FromFile(two); err != nil || len(found) != len(certs) {
+		t.Errorf("got %d certs, err=%v", len(found), err)
+	}
+}
+
+func TestStore__checkBackupDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// A directory of anything else isn't a backup
+	dir := filepath.Join(root, "tmp")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBackupDir(root, dir, certutil.FromFile); err == nil {
+		t.Error("expected error")
+	}
+	if err := checkBackupDir(root, dir, certutil.FromFile, "*.txt"); err == nil {
+		t.Error("expected error, no CA certificates")
+	}
+
+	// Directories of CA certificates are
+	if err := file.CopyFile(filepath.Join("..", "..", "testdata", "lots.crt"), filepath.Join(dir, "lots.crt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBackupDir(root, dir, certutil.FromFile); err == nil {
+		t.Error("expected error, notes.txt isn't a certificate")
+	}
+	if err := checkBackupDir(root, dir, certutil.FromFile, "*.txt"); err != nil {
+		t.Error(err)
+	}
+
+	// Backups made by cert-manage aren't read
+	backup, err := getCertManageDir(root, filepath.Join("linux", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBackupDir(root, backup, certutil.FromFile); err != nil {
+		t.Error(err)
+	}
+	if err := checkBackupDir(root, filepath.Join(dir, "lots.crt"), certutil.FromFile); err == nil {
+		t.Error("expected error, not a directory")
+	}
+}
@@ -15,11 +15,13 @@
 package store
 
 import (
+	"bytes"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -34,11 +36,34 @@ var (
 		strings.Contains(os.Getenv("GODEBUG"), "x509roots=1")
 
 	// Define a mapping between -app and the Store instance
-	appStores = map[string]Store{
-		"chrome":  ChromeStore(),
-		"firefox": FirefoxStore(),
-		"java":    JavaStore(),
-		"openssl": OpenSSLStore(),
+	appStores = map[string]func(*Options) Store{
+		"bundles":     BundlesStore,
+		"chrome":      ChromeStore,
+		"containerd":  ContainerdStore,
+		"curl":        CurlStore,
+		"docker":      DockerStore,
+		"firefox":     FirefoxStore,
+		"java":        JavaStore,
+		"libreoffice": LibreOfficeStore,
+		"node":        NodeStore,
+		"nssdb":       NSSDBStore,
+		"openssl":     OpenSSLStore,
+		"p11kit":      P11KitStore,
+		"python":      PythonStore,
+		"thunderbird": ThunderbirdStore,
+	}
+
+	// Apps which can be narrowed down to one install with -app <name>:<path>
+	appInstallStores = map[string]func(*Options, string) (Store, error){
+		"containerd":  containerd.storeAt,
+		"curl":        curl.storeAt,
+		"docker":      docker.storeAt,
+		"firefox":     firefox.storeAt,
+		"java":        javaStoreAt,
+		"node":        node.storeAt,
+		"nssdb":       nssdbStoreAt,
+		"python":      python.storeAt,
+		"thunderbird": thunderbird.storeAt,
 	}
 
 	// ErrNoBackupMade is returned if no backup of a certificate store can be found
@@ -47,6 +72,65 @@ var (
 	backupDirPerms os.FileMode = file.TempDirPermissions
 )
 
+// Options changes how stores are found and modified. A nil *Options is valid
+// and represents the defaults.
+type Options struct {
+	// Root is an alternate filesystem root (e.g. an unpacked container image or
+	// mounted disk) which all store paths are read, written and refreshed under.
+	//
+	// Only file based stores support Root. Backups of Root's stores are kept on
+	// the host, under ~/.cert-manage/roots.
+	Root string
+
+	// ExecRefresh makes stores run their platform's refresh command (e.g.
+	// update-ca-certificates) rather than a native implementation when one exists.
+	ExecRefresh bool
+
+	// JavaKeystore is the keystore the Java store operates on instead of each
+	// JDK's `cacerts`. It's under Root when one is given.
+	JavaKeystore string
+
+	// JavaStorePass is the password of Java keystores, JavaStorePassFile names
+	// a file which holds the password instead.
+	JavaStorePass     string
+	JavaStorePassFile string
+
+	// VenvDirs are searched for Python virtualenvs, whose certifi bundles the
+	// Python store operates on. They're under Root when one is given.
+	VenvDirs []string
+
+	// ArchiveRecipients are age recipients (age1...) archives are encrypted to.
+	// ArchivePassphraseFile names a file holding a passphrase archives are
+	// encrypted (and decrypted) with instead. ArchiveIdentityFiles are age
+	// identity files used to decrypt archives.
+	ArchiveRecipients     []string
+	ArchivePassphraseFile string
+	ArchiveIdentityFiles  []string
+
+	// ArchiveSigningKeyFile is a PEM encoded Ed25519 private key archives are
+	// signed with. When ArchiveTrustedKeyFiles (PEM encoded Ed25519 public keys,
+	// or $CERT_MANAGE_TRUSTED_KEYS) are given only archives signed by one of
+	// them are unpacked.
+	ArchiveSigningKeyFile  string
+	ArchiveTrustedKeyFiles []string
+}
+
+// root returns the cleaned Root, where "/" is returned as the empty string
+func (o *Options) root() string {
+	if o == nil || o.Root == "" {
+		return ""
+	}
+	root := filepath.Clean(o.Root)
+	if root == string(filepath.Separator) {
+		return ""
+	}
+	return root
+}
+
+func (o *Options) execRefresh() bool {
+	return o != nil && o.ExecRefresh
+}
+
 type ListOptions struct {
 	// Include "trusted" certificates
 	// This represents what'a good acting application or would return
@@ -55,7 +139,19 @@ type ListOptions struct {
 	// Include certificates specifically untrusted by a user/admin
 	Untrusted bool
 
-	// TODO(adam): Expired and Revoked
+	// Usage limits trust to one usage, e.g. S/MIME email. It's only honored by
+	// stores which keep trust for each usage (NSS), the default is TLS.
+	Usage whitelist.Usage
+
+	// Expired, NotYetValid and Revoked narrow the certificates down to those which
+	// are expired, not valid yet or revoked. When more than one is set certificates
+	// matching any of them are included.
+	Expired     bool
+	NotYetValid bool
+	Revoked     bool
+
+	// Revocation is checked for Revoked, which defaults to BlacklistRevocation()
+	Revocation RevocationSource
 }
 
 // Store represents a certificate store (set of x509 Certificates) and has
@@ -104,17 +200,41 @@ type Store interface {
 	Restore(where string) error
 }
 
+// MultiStore is a Store made up of several independent stores, such as the
+// keystore of every installed JDK. Operations on a MultiStore apply to each.
+type MultiStore interface {
+	Store
+
+	// Stores returns each underlying store
+	Stores() []NamedStore
+}
+
+// NamedStore is a Store along with the name (e.g. java:/usr/lib/jvm/java-17)
+// it can be addressed by in ForApp
+type NamedStore struct {
+	Name string
+
+	// Scope is what the store's certificates are trusted for (e.g. a registry host)
+	// when that's narrower than the whole app, otherwise it's empty.
+	Scope string
+
+	Store
+}
+
 // Info represents high-level information about a certificate store
 // There are no guarantees of machine parsing on this data, but it should
 // be easily human readable.
 type Info struct {
 	Name    string
 	Version string
+
+	// Location is where the store is kept (e.g. a keystore's path), if known
+	Location string
 }
 
 // Platform returns a new instance of Store for the running os/platform
-func Platform() Store {
-	return platform()
+func Platform(opts *Options) Store {
+	return platform(opts)
 }
 
 // GetApps returns an array the supported app names
@@ -128,29 +248,109 @@ func GetApps() []string {
 }
 
 // ForApp returns a `Store` instance for the given app
-func ForApp(app string) (Store, error) {
-	s, ok := appStores[strings.ToLower(app)]
+//
+// Some apps can be narrowed down to a specific install with `app:<path>`,
+// e.g. java:/usr/lib/jvm/java-17-openjdk-amd64
+func ForApp(app string, opts *Options) (Store, error) {
+	if idx := strings.Index(app, ":"); idx > 0 {
+		name, where := strings.ToLower(app[:idx]), app[idx+1:]
+		fn, ok := appInstallStores[name]
+		if !ok {
+			return nil, fmt.Errorf("application %q doesn't support selecting an install", name)
+		}
+		if where == "" {
+			return nil, fmt.Errorf("no install path given for %s", name)
+		}
+		return fn(opts, where)
+	}
+	fn, ok := appStores[strings.ToLower(app)]
 	if !ok {
 		return nil, fmt.Errorf("application %q not found", app)
 	}
-	return s, nil
+	return fn(opts), nil
+}
+
+// runRefresh runs a command which updates the system's trust (e.g. update-ca-trust)
+// with sudo, unless already root. Under an alternate root it's ran with chroot.
+func runRefresh(root string, args []string) error {
+	var out bytes.Buffer
+
+	// Run the refresh command from inside the alternate root
+	if root != "" {
+		args = append([]string{"chroot", root}, args...)
+	}
+
+	cmd := exec.Command("sudo", args...)
+	if os.Getuid() == 0 {
+		// drop sudo if we're already root
+		cmd = exec.Command(args[0], args[1:]...)
+	}
+	cmd.Stdout = &out
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("error updating trust status: err=%v, out=%s", err, out.String())
+	}
+	return nil
+}
+
+// unroot returns path as seen from inside of root
+func unroot(root, path string) string {
+	if root == "" {
+		return path
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return path
+	}
+	return string(filepath.Separator) + rel
+}
+
+// backupID names the backup directory of a store kept at path, which is path (as
+// seen from inside of root) with separators replaced.
+func backupID(root, path string) string {
+	r := strings.NewReplacer("/", "_", `\`, "_", ":", "")
+	return strings.Trim(r.Replace(unroot(root, path)), "_")
 }
 
 // getCertManageDir returns the fs location (always creating first) where a specific
 // store can save files into. This path is recommended for backups
 //
 // If `name` is an absolute fs reference then just ensure that directory is created
-// and has permissions setup properly.
-func getCertManageDir(name string) (string, error) {
-	parent, err := getCertManageParentDir()
+// and has permissions setup properly. Otherwise the directory is created under
+// `root`, which is empty unless operating on an alternate filesystem.
+func getCertManageDir(root, name string) (string, error) {
+	dir, err := certManageDir(root, name)
+	if err != nil {
+		return "", err
+	}
+	if parent := certManageParentDir(root); parent != "" {
+		// Make parent dir and set ownership
+		if err := os.MkdirAll(parent, os.ModeDir|backupDirPerms); err != nil {
+			return "", err
+		}
+	}
+
+	// Create the dir and set ownership
+	err = os.MkdirAll(dir, os.ModeDir|backupDirPerms)
 	if err != nil {
 		return "", err
 	}
 
+	return dir, nil
+}
+
+// certManageDir returns the location getCertManageDir would create, without
+// creating it. This is used when only reading backups.
+func certManageDir(root, name string) (string, error) {
+	parent := certManageParentDir(root)
+	if parent == "" && !filepath.IsAbs(name) {
+		return "", errors.New("unable to find the cert-manage directory")
+	}
 	dir := filepath.Join(parent, name)
 	// If `name` is actually an absolute fs reference then just ensure
-	// it's created and owned properly, otherwise append whatever was
-	// provided onto the parent dir.
+	// it's a directory, otherwise append whatever was provided onto the
+	// parent dir.
 	if filepath.IsAbs(name) {
 		s, err := os.Stat(name)
 		if err != nil && !os.IsNotExist(err) {
@@ -161,37 +361,39 @@ func getCertManageDir(name string) (string, error) {
 		}
 		dir = name
 	}
-
-	// Create the dir and set ownership
-	err = os.MkdirAll(dir, os.ModeDir|backupDirPerms)
-	if err != nil {
-		return "", err
-	}
-
 	return dir, nil
 }
 
-func getCertManageParentDir() (string, error) {
+// rootsDir holds the backups of each alternate root under ~/.cert-manage
+const rootsDir = "roots"
+
+func certManageParentDir(root string) string {
 	uhome := file.HomeDir()
-	if uhome != "" {
-		parent := ""
+	if uhome == "" {
+		return ""
+	}
 
-		// Setup parent dir
-		if runtime.GOOS == "darwin" {
-			parent = filepath.Join(uhome, "/Library/cert-manage")
-		}
-		if runtime.GOOS == "linux" || runtime.GOOS == "windows" {
-			parent = filepath.Join(uhome, ".cert-manage")
-		}
+	// Setup parent dir
+	var dir string
+	switch runtime.GOOS {
+	case "darwin":
+		dir = filepath.Join(uhome, "/Library/cert-manage")
+	case "linux", "windows":
+		dir = filepath.Join(uhome, ".cert-manage")
+	default:
+		return ""
+	}
 
-		// Make parent dir and set ownership
-		err := os.MkdirAll(parent, os.ModeDir|backupDirPerms)
+	// Keep the backups of an alternate root on the host, apart from the host's own,
+	// so only the trust stores inside of root are changed
+	if root != "" {
+		abs, err := filepath.Abs(root)
 		if err != nil {
-			return "", err
+			return ""
 		}
-		return parent, nil
+		dir = filepath.Join(dir, rootsDir, backupID("", abs))
 	}
-	return "", nil
+	return dir
 }
 
 // getLatestBackup returns the "biggest" file or dir at a given path
@@ -214,3 +416,12 @@ func getLatestBackup(dir string) (string, error) {
 	latest := fis[len(fis)-1]
 	return filepath.Join(dir, latest.Name()), nil
 }
+
+// findLatestBackup returns the latest backup in dir like getLatestBackup, but an
+// empty path is returned when dir doesn't exist as no backups have been made.
+func findLatestBackup(dir string) (string, error) {
+	if !file.Exists(dir) {
+		return "", nil
+	}
+	return getLatestBackup(dir)
+}
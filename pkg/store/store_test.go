@@ -15,20 +15,37 @@
 package store
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
 )
 
+// TestMain keeps the backups tests take in a temporary home directory, rather than
+// the user's ~/.cert-manage
+func TestMain(m *testing.M) {
+	home, err := ioutil.TempDir("", "cert-manage-home")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("USERPROFILE", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
 func TestStore__getCertManageDir(t *testing.T) {
 	name := "test-getCertManageDir"
-	d1, err := getCertManageDir(name)
+	d1, err := getCertManageDir("", name)
 	if err != nil {
 		t.Error(err)
 	}
 
 	// make the dir again just to make sure everything is ok
-	d2, err := getCertManageDir(name)
+	d2, err := getCertManageDir("", name)
 	if err != nil {
 		t.Error(err)
 	}
@@ -39,7 +56,7 @@ func TestStore__getCertManageDir(t *testing.T) {
 	defer os.Remove(d1)
 
 	// If we're asking for an abs reference just return that.
-	// AKA. Don't append getCertManageParentDir()
+	// AKA. Don't append certManageParentDir()
 	dir, err := filepath.Abs(filepath.Join("..", "..", "testdata", "backups", "files"))
 	if err != nil {
 		t.Error(err)
@@ -48,7 +65,7 @@ func TestStore__getCertManageDir(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	d3, err := getCertManageDir(dir)
+	d3, err := getCertManageDir("", dir)
 	if err != nil {
 		t.Error(err)
 	}
@@ -73,7 +90,7 @@ func TestStore__getCertManageDirNeg(t *testing.T) {
 	}
 
 	// "get dir"
-	dir, err := getCertManageDir(f)
+	dir, err := getCertManageDir("", f)
 	if err == nil {
 		t.Errorf("expected error, dir=%s", dir)
 	}
@@ -0,0 +1,48 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+var (
+	thunderbird = mozillaApp{
+		name:  "thunderbird",
+		title: "Thunderbird",
+		profileRoots: []string{
+			".thunderbird",                                  // Linux
+			"snap/thunderbird/common/.thunderbird",          // Ubuntu (Snap)
+			".var/app/org.mozilla.Thunderbird/.thunderbird", // Flatpak
+			"Library/Thunderbird",                           // Darwin
+			"AppData/Roaming/Thunderbird",                   // Windows
+		},
+		version: thunderbirdVersion,
+	}
+
+	thunderbirdBinaryPaths = []string{
+		"/usr/bin/thunderbird",  // Linux
+		"/snap/bin/thunderbird", // Ubuntu (Snap)
+		`/Applications/Thunderbird.app/Contents/MacOS/thunderbird`, // Darwin
+	}
+)
+
+// ThunderbirdStore returns a Mozilla Thunderbird implementation of Store. Every
+// profile with an NSS database is operated on, each is available from Stores().
+func ThunderbirdStore(opts *Options) Store {
+	return thunderbird.store(opts)
+}
+
+// thunderbirdVersion returns the installed version of Thunderbird
+func thunderbirdVersion(root string) string {
+	// returns "Thunderbird 115.3.1"
+	return binaryVersion(root, thunderbirdBinaryPaths, "-v", "Thunderbird")
+}
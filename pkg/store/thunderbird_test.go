@@ -0,0 +1,87 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+// copyCertdb creates dir with the testdata cert9.db inside of it
+func copyCertdb(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.CopyFile(filepath.Join("..", "..", "testdata", "cert9.db"), filepath.Join(dir, "cert9.db")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreThunderbird__profiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("profile paths are unix specific")
+	}
+
+	root, err := ioutil.TempDir("", "cert-manage-thunderbird")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if _, ok := ThunderbirdStore(&Options{Root: root}).(emptyStore); !ok {
+		t.Error("expected an empty store without profiles")
+	}
+
+	base := filepath.Join(root, file.HomeDir(), ".thunderbird")
+	copyCertdb(t, filepath.Join(base, "k2l3m4n5.default-esr"))
+	ini := "[Profile0]\nName=default-esr\nIsRelative=1\nPath=k2l3m4n5.default-esr\n"
+	if err := ioutil.WriteFile(filepath.Join(base, "profiles.ini"), []byte(ini), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &Options{Root: root}
+	ms, ok := ThunderbirdStore(opts).(MultiStore)
+	if !ok {
+		t.Fatal("expected a MultiStore")
+	}
+	stores := ms.Stores()
+	if len(stores) != 1 || stores[0].Name != "thunderbird:default-esr" {
+		t.Fatalf("got %v", stores)
+	}
+	if dir := stores[0].Store.(nssStore).backupDir; dir != filepath.Join("thunderbird", backupID(root, filepath.Join(base, "k2l3m4n5.default-esr"))) {
+		t.Errorf("got backup dir %s", dir)
+	}
+	if info := ms.GetInfo(); info.Name != "Thunderbird" {
+		t.Errorf("got %#v", info)
+	}
+
+	st, err := ForApp("thunderbird:default-esr", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := st.List(&ListOptions{Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 5 {
+		t.Errorf("got %d certificates", len(certs))
+	}
+}
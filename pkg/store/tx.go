@@ -0,0 +1,364 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+// snapshotsDir is where Transact keeps snapshots under ~/.cert-manage, outside of
+// each store's backups so they aren't restored as the latest backup
+const snapshotsDir = "snapshots"
+
+// TxError is returned by Transact when a change fails. It holds the change's
+// error and how rolling back each store went.
+type TxError struct {
+	Err       error
+	Rollbacks []Rollback
+}
+
+// Rollback is a store restored from the snapshot taken before a failed change
+type Rollback struct {
+	// Store is the name of the store, e.g. java:/usr/lib/jvm/java-17
+	Store string
+
+	// Snapshot is the backup taken before the change, which is kept when
+	// restoring it failed. It's empty for stores which didn't exist before the
+	// change, whose rollback removes Created instead.
+	Snapshot string
+	Created  string
+
+	// Err is why restoring the snapshot failed, nil if it was restored
+	Err error
+}
+
+func (e *TxError) Error() string {
+	if e.RolledBack() {
+		return fmt.Sprintf("%v, changes were rolled back", e.Err)
+	}
+	var failed []string
+	for _, r := range e.Rollbacks {
+		switch {
+		case r.Err == nil:
+		case r.Snapshot == "":
+			failed = append(failed, fmt.Sprintf("%s (%v, remove %s)", r.Store, r.Err, r.Created))
+		default:
+			failed = append(failed, fmt.Sprintf("%s (%v, snapshot kept at %s)", r.Store, r.Err, r.Snapshot))
+		}
+	}
+	return fmt.Sprintf("%v, rolling back failed for %s", e.Err, strings.Join(failed, ", "))
+}
+
+// RolledBack returns true if every store was restored from its snapshot
+func (e *TxError) RolledBack() bool {
+	for _, r := range e.Rollbacks {
+		if r.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshot is the backup of a store taken by Transact. Stores which don't exist
+// yet have an empty snapshot, created is what rolling back removes.
+type snapshot struct {
+	NamedStore
+	path    string
+	created string
+}
+
+// creator is implemented by stores which don't need to exist before they're
+// changed (e.g. a registry host without a certs.d directory), so they have nothing
+// to back up.
+type creator interface {
+	// creates returns the path a change would create if the store doesn't exist,
+	// otherwise it's empty
+	creates() string
+}
+
+// rollback restores the store from its snapshot, or removes what the change created
+func (s snapshot) rollback() error {
+	if s.path == "" {
+		return os.RemoveAll(s.created)
+	}
+	return s.Store.Restore(s.path)
+}
+
+// Transact runs change, which modifies s, after taking a snapshot (backup) of s. If
+// change fails each store is restored from its snapshot and a *TxError describing
+// what happened is returned.
+//
+// Snapshots are removed afterwards, unless restoring one failed. Stores which can't
+// be backed up (e.g. on windows) aren't rolled back.
+//
+// Transact only rolls back, it doesn't make a change atomic. Files are replaced
+// one at a time (through a rename where possible), so if cert-manage is killed
+// during a change the store is left partly changed and its snapshot is kept under
+// ~/.cert-manage/snapshots.
+func Transact(name string, s Store, opts *Options, change func() error) error {
+	stores := []NamedStore{{Name: name, Store: s}}
+	if ms, ok := s.(MultiStore); ok {
+		if ss := ms.Stores(); len(ss) > 0 {
+			stores = ss
+		}
+	}
+
+	dir, err := getCertManageDir(opts.root(), filepath.Join(snapshotsDir, fmt.Sprintf("%d", time.Now().UnixNano())))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// only removed once empty
+		if os.Remove(dir) == nil {
+			os.Remove(filepath.Dir(dir))
+		}
+	}()
+
+	var snapshots []snapshot
+	for i := range stores {
+		if c, ok := stores[i].Store.(creator); ok {
+			if created := c.creates(); created != "" {
+				snapshots = append(snapshots, snapshot{NamedStore: stores[i], created: created})
+				continue
+			}
+		}
+		path, err := takeSnapshot(stores[i].Store, filepath.Join(dir, fmt.Sprintf("%d", i)))
+		if err != nil {
+			removeSnapshots(snapshots)
+			return fmt.Errorf("%s: problem taking snapshot: %v", stores[i].Name, err)
+		}
+		if path != "" {
+			snapshots = append(snapshots, snapshot{NamedStore: stores[i], path: path})
+		}
+	}
+
+	if err := change(); err != nil {
+		txe := &TxError{
+			Err: err,
+		}
+		for i := range snapshots {
+			r := Rollback{
+				Store:    snapshots[i].Name,
+				Snapshot: snapshots[i].path,
+				Created:  snapshots[i].created,
+				Err:      snapshots[i].rollback(),
+			}
+			if r.Err == nil && r.Snapshot != "" {
+				os.RemoveAll(filepath.Dir(r.Snapshot))
+			}
+			txe.Rollbacks = append(txe.Rollbacks, r)
+		}
+		return txe
+	}
+	removeSnapshots(snapshots)
+	return nil
+}
+
+// takeSnapshot backs up s into dir, leaving the store's backups unchanged. The
+// snapshot's path is returned, which is empty if the store can't be backed up.
+func takeSnapshot(s Store, dir string) (path string, err error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	defer func() {
+		if path == "" {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	latest, err := s.GetLatestBackup()
+	if err != nil {
+		return "", err
+	}
+	waitForNewBackup(latest)
+
+	if err := s.Backup(); err != nil {
+		return "", err
+	}
+	backup, err := s.GetLatestBackup()
+	if err != nil || backup == "" {
+		return "", err
+	}
+	if backup == latest {
+		return "", fmt.Errorf("backup %s was replaced by the snapshot", latest)
+	}
+	path = filepath.Join(dir, filepath.Base(backup))
+	return path, os.Rename(backup, path)
+}
+
+// waitForNewBackup waits until a backup taken now won't replace latest. Backups
+// are named after the second they're taken in, so one taken within the same
+// second as latest would otherwise overwrite it.
+func waitForNewBackup(latest string) {
+	if latest == "" {
+		return
+	}
+	now := time.Now()
+	if strings.Contains(filepath.Base(latest), fmt.Sprintf("%d", now.Unix())) {
+		time.Sleep(now.Truncate(time.Second).Add(time.Second).Sub(now))
+	}
+}
+
+func removeSnapshots(snapshots []snapshot) {
+	for i := range snapshots {
+		if snapshots[i].path != "" {
+			os.RemoveAll(filepath.Dir(snapshots[i].path))
+		}
+	}
+}
+
+// missingParent returns the top most directory of path (or path itself) which
+// doesn't exist, which creating path would create. It's empty if path exists.
+func missingParent(path string) string {
+	var out string
+	for p := filepath.Clean(path); p != filepath.Dir(p); p = filepath.Dir(p) {
+		if _, err := os.Lstat(p); err == nil {
+			break
+		}
+		out = p
+	}
+	return out
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	fd, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name()) // no-op after a successful rename
+
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(fd.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(fd.Name(), path)
+}
+
+// writeCertsAtomic replaces path with certs encoded as PEM, keeping its permissions.
+// A symlink at path is replaced rather than written through.
+func writeCertsAtomic(path string, certs []*x509.Certificate) error {
+	var perm os.FileMode = 0644
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	var buf bytes.Buffer
+	for i := range certs {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: certs[i].Raw}); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, buf.Bytes(), perm)
+}
+
+// replaceEntry copies src (a file or symlink) next to dst and renames it over dst
+func replaceEntry(src, dst string) error {
+	tmp := stagingPath(dst)
+	if err := copyEntry(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// replaceEntries stages every src next to its dst before renaming any of them, so a
+// failed copy leaves all of dst untouched.
+func replaceEntries(src, dst []string) error {
+	tmp := make([]string, len(dst))
+	defer func() {
+		for i := range tmp {
+			if tmp[i] != "" {
+				os.Remove(tmp[i]) // no-op after a successful rename
+			}
+		}
+	}()
+	for i := range src {
+		tmp[i] = stagingPath(dst[i])
+		if err := copyEntry(src[i], tmp[i]); err != nil {
+			return err
+		}
+	}
+	for i := range tmp {
+		if err := os.Rename(tmp[i], dst[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceDir mirrors src next to dst and then swaps it with dst, so dst is only
+// missing between two renames rather than while every file is copied. The entries
+// of src named in skip aren't copied.
+func replaceDir(src, dst string, skip ...string) error {
+	tmp := stagingPath(dst)
+	if err := file.MirrorDir(src, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	for i := range skip {
+		if err := os.RemoveAll(filepath.Join(tmp, skip[i])); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+	old := stagingPath(dst)
+	if err := os.Rename(dst, old); err != nil {
+		if !os.IsNotExist(err) {
+			os.RemoveAll(tmp)
+			return err
+		}
+		old = ""
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		if old != "" {
+			os.Rename(old, dst)
+		}
+		os.RemoveAll(tmp)
+		return err
+	}
+	if old != "" {
+		return os.RemoveAll(old)
+	}
+	return nil
+}
+
+// stagingPath returns an unused path next to path for staging its replacement
+func stagingPath(path string) string {
+	return filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.cert-manage-%d", filepath.Base(path), time.Now().UnixNano()))
+}
@@ -0,0 +1,325 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+func TestStore__Transact(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	one, two := filepath.Join(root, "one.pem"), filepath.Join(root, "two.pem")
+	for _, path := range []string{one, two} {
+		if err := certutil.ToFile(path, certs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st1, st2 := python.bundle(root, "", one), python.bundle(root, "", two)
+	ms := multiStore{
+		app:  "python",
+		root: root,
+		stores: []NamedStore{
+			{Name: "python:/one.pem", Store: st1},
+			{Name: "python:/two.pem", Store: st2},
+		},
+	}
+	opts := &Options{Root: root}
+
+	if err := st1.Backup(); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := st1.GetLatestBackup()
+	if err != nil || latest == "" {
+		t.Fatalf("latest=%q err=%v", latest, err)
+	}
+	snapshots := filepath.Join(certManageParentDir(root), snapshotsDir)
+	count := func(path string) int {
+		certs, err := certutil.FromFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(certs)
+	}
+
+	// A failed change is rolled back in every store, including those of a
+	// MultiStore other than multiStore
+	wrapped := struct{ MultiStore }{ms}
+	err = Transact("python", wrapped, opts, func() error {
+		if err := certutil.ToFile(one, certs[:1]); err != nil {
+			return err
+		}
+		if err := certutil.ToFile(two, certs[:2]); err != nil {
+			return err
+		}
+		return errors.New("bad change")
+	})
+	txe, ok := err.(*TxError)
+	if !ok {
+		t.Fatalf("expected *TxError, got %T %v", err, err)
+	}
+	if !txe.RolledBack() || len(txe.Rollbacks) != 2 || txe.Err.Error() != "bad change" {
+		t.Errorf("unexpected rollback: %v", txe)
+	}
+	if n := count(one); n != len(certs) {
+		t.Errorf("one.pem: got %d certs", n)
+	}
+	if n := count(two); n != len(certs) {
+		t.Errorf("two.pem: got %d certs", n)
+	}
+
+	// Snapshots are removed and the store's backups are untouched
+	if _, err := os.Stat(snapshots); !os.IsNotExist(err) {
+		t.Errorf("snapshots left behind: %v", err)
+	}
+	if after, err := st1.GetLatestBackup(); err != nil || after != latest {
+		t.Errorf("latest backup %q changed to %q err=%v", latest, after, err)
+	}
+	if n := count(latest); n != len(certs) {
+		t.Errorf("latest backup: got %d certs", n)
+	}
+	if infos, err := ioutil.ReadDir(filepath.Dir(latest)); err != nil || len(infos) != 1 {
+		t.Errorf("expected only the latest backup: %d err=%v", len(infos), err)
+	}
+
+	// Successful changes are kept
+	err = Transact("python", st1, opts, func() error {
+		return certutil.ToFile(one, certs[:1])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := count(one); n != 1 {
+		t.Errorf("one.pem: got %d certs", n)
+	}
+	if _, err := os.Stat(snapshots); !os.IsNotExist(err) {
+		t.Errorf("snapshots left behind: %v", err)
+	}
+}
+
+func TestStore__TransactRollbackFailed(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "one.pem")
+	if err := file.CopyFile(filepath.Join("..", "..", "testdata", "lots.crt"), path); err != nil {
+		t.Fatal(err)
+	}
+	st := python.bundle(root, "", path)
+
+	// Replacing the bundle with a directory means the snapshot can't be restored
+	err = Transact("python", st, &Options{Root: root}, func() error {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(path, "dir"), 0755); err != nil {
+			return err
+		}
+		return errors.New("bad change")
+	})
+	txe, ok := err.(*TxError)
+	if !ok {
+		t.Fatalf("expected *TxError, got %T %v", err, err)
+	}
+	if txe.RolledBack() || len(txe.Rollbacks) != 1 || txe.Rollbacks[0].Err == nil {
+		t.Fatalf("expected failed rollback: %v", txe)
+	}
+	if _, err := os.Stat(txe.Rollbacks[0].Snapshot); err != nil {
+		t.Errorf("snapshot should be kept: %v", err)
+	}
+	if backup, err := RestorePoint(st, txe.Rollbacks[0].Snapshot); err != nil || backup != txe.Rollbacks[0].Snapshot {
+		t.Errorf("got %q err=%v", backup, err)
+	}
+}
+
+func TestStore__replaceDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	for _, path := range []string{filepath.Join(src, "a.pem"), filepath.Join(dst, "b.pem")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("cert"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := replaceDir(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if !file.Exists(filepath.Join(dst, "a.pem")) || file.Exists(filepath.Join(dst, "b.pem")) {
+		t.Error("dst wasn't replaced")
+	}
+
+	// dst doesn't need to exist, and nothing is left staged next to it
+	if err := replaceDir(src, filepath.Join(dir, "new")); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil || len(infos) != 3 {
+		t.Errorf("expected src, dst and new: %d err=%v", len(infos), err)
+	}
+}
+
+func TestStore__replaceEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var src, dst []string
+	for _, name := range []string{"cert9.db", "key4.db"} {
+		path := filepath.Join(dir, "backup-"+name)
+		if err := ioutil.WriteFile(path, []byte("new"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		src = append(src, path)
+		dst = append(dst, filepath.Join(dir, name))
+		if err := ioutil.WriteFile(dst[len(dst)-1], []byte("old"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a missing source leaves every destination alone
+	if err := replaceEntries(append(src, filepath.Join(dir, "missing")), append(dst, filepath.Join(dir, "pkcs11.txt"))); err == nil {
+		t.Error("expected error")
+	}
+	for i := range dst {
+		if bs, _ := ioutil.ReadFile(dst[i]); string(bs) != "old" {
+			t.Errorf("%s was replaced: %q", dst[i], bs)
+		}
+	}
+
+	if err := replaceEntries(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	for i := range dst {
+		if bs, _ := ioutil.ReadFile(dst[i]); string(bs) != "new" {
+			t.Errorf("%s wasn't replaced: %q", dst[i], bs)
+		}
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil || len(infos) != 4 {
+		t.Errorf("expected nothing staged: %d err=%v", len(infos), err)
+	}
+}
+
+func TestStore__writeCertsAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-manage-tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "lots.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, link := filepath.Join(dir, "target.pem"), filepath.Join(dir, "link.pem")
+	if err := certutil.ToFile(target, certs); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(target, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	// The link is replaced and its target is left alone
+	if err := writeCertsAtomic(link, certs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(link)
+	if err != nil || fi.Mode()&os.ModeSymlink != 0 || fi.Mode().Perm() != 0640 {
+		t.Errorf("link.pem wasn't replaced: %v err=%v", fi.Mode(), err)
+	}
+	if read, err := certutil.FromFile(link); err != nil || len(read) != 1 {
+		t.Errorf("link.pem: got %d certs err=%v", len(read), err)
+	}
+	if read, err := certutil.FromFile(target); err != nil || len(read) != len(certs) {
+		t.Errorf("target.pem: got %d certs err=%v", len(read), err)
+	}
+}
+
+func TestStore__TransactCreated(t *testing.T) {
+	root, err := ioutil.TempDir("", "cert-manage-tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	certs, err := certutil.FromFile(filepath.Join("..", "..", "testdata", "example.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "etc", "docker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{Root: root}
+	st, err := docker.storeAt(opts, "new.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed change to a host without a certs.d directory removes what it created
+	err = Transact("docker:new.example.com", st, opts, func() error {
+		if err := st.Add(certs); err != nil {
+			return err
+		}
+		return errors.New("bad change")
+	})
+	txe, ok := err.(*TxError)
+	if !ok || !txe.RolledBack() || len(txe.Rollbacks) != 1 {
+		t.Fatalf("unexpected rollback: %v", err)
+	}
+	if created := filepath.Join(root, "etc", "docker", "certs.d"); txe.Rollbacks[0].Created != created || file.Exists(created) {
+		t.Errorf("expected %s to be removed: %#v", created, txe.Rollbacks[0])
+	}
+	if !file.Exists(filepath.Join(root, "etc", "docker")) {
+		t.Error("only what the change created is removed")
+	}
+
+	// Successful changes are kept
+	if err := Transact("docker:new.example.com", st, opts, func() error { return st.Add(certs) }); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := st.List(&ListOptions{Trusted: true}); err != nil || len(found) != 1 {
+		t.Errorf("got %d certificates err=%v", len(found), err)
+	}
+}
@@ -20,6 +20,7 @@ package store
 import (
 	"bytes"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -59,7 +60,8 @@ var (
 
 type windowsStore struct{}
 
-func platform() Store {
+// platform returns the system store, alternate roots aren't supported on windows
+func platform(_ *Options) Store {
 	return windowsStore{}
 }
 
@@ -67,6 +69,10 @@ func (s windowsStore) Add(certs []*x509.Certificate) error {
 	return nil
 }
 
+func (s windowsStore) planAdd(certs []*x509.Certificate) ([]Change, error) {
+	return nil, nil
+}
+
 func (s windowsStore) Backup() error {
 	return nil
 }
@@ -97,7 +103,7 @@ func (s windowsStore) GetInfo() *Info {
 	}
 }
 
-func (s windowsStore) List(_ *ListOptions) ([]*x509.Certificate, error) {
+func (s windowsStore) List(opts *ListOptions) ([]*x509.Certificate, error) {
 	pool := certutil.Pool{}
 	for i := range windowsStoreNames {
 		certs, err := s.certsFromStore(windowsStoreNames[i])
@@ -106,7 +112,7 @@ func (s windowsStore) List(_ *ListOptions) ([]*x509.Certificate, error) {
 		}
 		pool.AddCertificates(certs)
 	}
-	return pool.GetCertificates(), nil
+	return opts.filter(pool.GetCertificates())
 }
 
 func (s windowsStore) certsFromStore(store string) ([]*x509.Certificate, error) {
@@ -222,6 +228,13 @@ func (s windowsStore) Remove(wh whitelist.Whitelist) error {
 	return nil
 }
 
+func (s windowsStore) planRemove(wh whitelist.Whitelist) ([]Change, error) {
+	return nil, nil
+}
+
 func (s windowsStore) Restore(where string) error {
+	if where != "" {
+		return errors.New("restoring a specific backup isn't supported on windows")
+	}
 	return nil
 }
@@ -59,7 +59,7 @@ Serial Number: 214817429841748bbbcccca`
 }
 
 func TestStoreWindows__getInfo(t *testing.T) {
-	info := Platform().GetInfo()
+	info := Platform(nil).GetInfo()
 	if info == nil {
 		t.Fatal("nil Info")
 	}
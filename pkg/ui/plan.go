@@ -0,0 +1,185 @@
+// Copyright 2018 Adam Shannon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ui
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adamdecaf/cert-manage/pkg/certutil"
+	"github.com/adamdecaf/cert-manage/pkg/file"
+)
+
+var (
+	planJSONFormat = "json"
+)
+
+// PlanFormats returns the formats a plan can be shown in, which are the
+// certificate formats along with json
+func PlanFormats() []string {
+	out := []string{planJSONFormat}
+	for k := range printers {
+		out = append(out, k)
+	}
+	file.SortNames(out)
+	return out
+}
+
+// Plan holds the changes a command (e.g. whitelist) would make to each store,
+// which are shown instead of being made.
+type Plan struct {
+	Command string
+	Stores  []StorePlan
+}
+
+// StorePlan is the changes to one store
+type StorePlan struct {
+	Name     string
+	Location string
+
+	// Backup is the backup which would be restored, if any
+	Backup string
+
+	// Note explains why Changes are missing, e.g. the backup can't be read
+	Note string
+
+	Changes []Change
+}
+
+// Change is a certificate which would be added, removed or distrusted
+type Change struct {
+	Action string
+
+	// Usage is set when trust would only change for one usage (e.g. email)
+	Usage string
+
+	Certificate *x509.Certificate
+}
+
+type planReport struct {
+	Command string            `json:"command"`
+	Stores  []storePlanReport `json:"stores"`
+}
+
+type storePlanReport struct {
+	Name     string         `json:"name"`
+	Location string         `json:"location,omitempty"`
+	Backup   string         `json:"backup,omitempty"`
+	Note     string         `json:"note,omitempty"`
+	Changes  []changeReport `json:"changes"`
+}
+
+type changeReport struct {
+	Action      string    `json:"action"`
+	Usage       string    `json:"usage,omitempty"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"notAfter"`
+}
+
+// ShowPlan outputs the changes of each store, as json with `-format json` and
+// otherwise as groups of certificates in `cfg.Format`
+func ShowPlan(plan Plan, cfg *Config) error {
+	if strings.EqualFold(cfg.Format, planJSONFormat) {
+		return writePlanJSON(plan, cfg)
+	}
+
+	for _, st := range plan.Stores {
+		if st.Location != "" {
+			fmt.Printf("%s (%s):\n", st.Name, st.Location)
+		} else {
+			fmt.Printf("%s:\n", st.Name)
+		}
+		if st.Backup != "" {
+			fmt.Printf("  Restore from %s\n", st.Backup)
+		}
+		if st.Note != "" {
+			fmt.Printf("  %s\n", st.Note)
+			continue
+		}
+		if len(st.Changes) == 0 {
+			fmt.Println("  No changes")
+			continue
+		}
+
+		// Group the certificates of each action (and usage) in the order they're found
+		var groups []string
+		certs := make(map[string][]*x509.Certificate)
+		for _, c := range st.Changes {
+			group := c.Action
+			if c.Usage != "" {
+				group = fmt.Sprintf("%s (%s)", c.Action, c.Usage)
+			}
+			if _, ok := certs[group]; !ok {
+				groups = append(groups, group)
+			}
+			certs[group] = append(certs[group], c.Certificate)
+		}
+		for _, group := range groups {
+			fmt.Printf("  %s %d certificates\n", group, len(certs[group]))
+			if cfg.Count {
+				continue
+			}
+			if err := showCertsOnCli(certs[group], cfg); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("Dry run of %s, no changes were made\n", plan.Command)
+	return nil
+}
+
+func writePlanJSON(plan Plan, cfg *Config) error {
+	report := planReport{
+		Command: plan.Command,
+		Stores:  make([]storePlanReport, len(plan.Stores)),
+	}
+	for i, st := range plan.Stores {
+		report.Stores[i] = storePlanReport{
+			Name:     st.Name,
+			Location: st.Location,
+			Backup:   st.Backup,
+			Note:     st.Note,
+			Changes:  make([]changeReport, len(st.Changes)),
+		}
+		for j, c := range st.Changes {
+			report.Stores[i].Changes[j] = changeReport{
+				Action:      c.Action,
+				Usage:       c.Usage,
+				Subject:     certutil.StringifyPKIXName(c.Certificate.Subject),
+				Issuer:      certutil.StringifyPKIXName(c.Certificate.Issuer),
+				Fingerprint: certutil.GetHexSHA256Fingerprint(*c.Certificate),
+				NotAfter:    c.Certificate.NotAfter.UTC(),
+			}
+		}
+	}
+
+	bs, err := json.MarshalIndent(&report, "", "  ")
+	if err != nil {
+		return err
+	}
+	bs = append(bs, '\n')
+	if cfg.Outfile != "" {
+		return ioutil.WriteFile(cfg.Outfile, bs, file.TempFilePermissions)
+	}
+	_, err = os.Stdout.Write(bs)
+	return err
+}
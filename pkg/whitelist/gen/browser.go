@@ -76,7 +76,7 @@ func FromAllBrowsers() ([]*url.URL, error) {
 func BrowserCAs() ([]*x509.Certificate, error) {
 	pool := certutil.Pool{}
 	for i := range browserNames {
-		st, err := store.ForApp(browserNames[i])
+		st, err := store.ForApp(browserNames[i], nil)
 		if err != nil {
 			if debug {
 				fmt.Printf("DEBUG: error getting hard-coded browser %s, err=%v\n", browserNames[i], err)
@@ -21,23 +21,26 @@ import (
 	"time"
 
 	"github.com/adamdecaf/cert-manage/pkg/file"
+	"github.com/adamdecaf/cert-manage/pkg/store"
 	"github.com/go-sqlite/sqlite3"
 )
 
-var (
-	firefoxProfileLocations = []string{
-		filepath.Join(file.HomeDir(), ".mozilla/firefox/*.default/places.sqlite"),                      // Ubuntu
-		filepath.Join(file.HomeDir(), "/Library/Application Support/Firefox/Profiles/*/places.sqlite"), // OSX
-		// C:\Users\%USERNAME%\AppData\Roaming\Mozilla\Firefox\Profiles\%PROFILE%.default\places.sqlite // TODO
-	}
-)
-
+// firefox returns the urls visited in every Firefox profile
 func firefox() ([]*url.URL, error) {
-	db, err := findFirefoxPlacesDB()
+	dbs, err := findFirefoxPlacesDBs()
 	if err != nil {
 		return nil, err
 	}
-	return getFirefoxUrls(db)
+	var acc []*url.URL
+	for i := range dbs {
+		urls, err := getFirefoxUrls(dbs[i])
+		dbs[i].Close()
+		if err != nil {
+			return nil, err
+		}
+		acc = append(acc, urls...)
+	}
+	return acc, nil
 }
 
 func getFirefoxUrls(db *sqlite3.DbFile) ([]*url.URL, error) {
@@ -52,18 +55,23 @@ func getFirefoxUrls(db *sqlite3.DbFile) ([]*url.URL, error) {
 	return getSqliteHistoryUrls(db, "Firefox", "moz_places", getter, oldestBrowserHistoryItemDate)
 }
 
-func findFirefoxPlacesDB() (*sqlite3.DbFile, error) {
-	// Paths are globs, so check each match
-	for i := range firefoxProfileLocations {
-		matches, err := filepath.Glob(firefoxProfileLocations[i])
+// findFirefoxPlacesDBs opens the places.sqlite of each Firefox profile
+func findFirefoxPlacesDBs() ([]*sqlite3.DbFile, error) {
+	var out []*sqlite3.DbFile
+	profiles := store.FirefoxProfiles(nil)
+	for i := range profiles {
+		where := filepath.Join(profiles[i].Dir, "places.sqlite")
+		if !file.Exists(where) {
+			continue
+		}
+		db, err := sqlite3.Open(where)
 		if err != nil {
 			return nil, err
 		}
-		for j := range matches {
-			if file.Exists(matches[j]) {
-				return sqlite3.Open(matches[j])
-			}
-		}
+		out = append(out, db)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("unable to find firefox places.sqlite")
 	}
-	return nil, errors.New("unable to find firefox places.sqlite")
+	return out, nil
 }
@@ -21,13 +21,13 @@ import (
 	"github.com/go-sqlite/sqlite3"
 )
 
-func TestWhitelistGen__findFirefoxPlacesDB(t *testing.T) {
-	db, err := findFirefoxPlacesDB()
+func TestWhitelistGen__findFirefoxPlacesDBs(t *testing.T) {
+	dbs, err := findFirefoxPlacesDBs()
 	if file.Exists("/Applications/Firefox.app") {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if db == nil {
+		if len(dbs) == 0 {
 			t.Fatal("no error, but didn't find firefox places.sqlite")
 		}
 	}
@@ -18,6 +18,7 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"strings"
 
@@ -26,6 +27,34 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// Usage is what a certificate can be trusted for. Stores such as NSS keep trust
+// for each usage separately.
+type Usage string
+
+const (
+	UsageTLS         Usage = "tls"
+	UsageEmail       Usage = "email"
+	UsageCodeSigning Usage = "code-signing"
+)
+
+// Usages returns every Usage
+func Usages() []Usage {
+	return []Usage{UsageTLS, UsageEmail, UsageCodeSigning}
+}
+
+// ParseUsage returns the Usage named by s, where an empty string is UsageTLS
+func ParseUsage(s string) (Usage, error) {
+	if s == "" {
+		return UsageTLS, nil
+	}
+	for _, u := range Usages() {
+		if strings.EqualFold(s, string(u)) {
+			return u, nil
+		}
+	}
+	return "", fmt.Errorf("unknown certificate usage %q", s)
+}
+
 // Whitelist is the structure holding various `item` types that match against
 // x509 certificates
 type Whitelist struct {
@@ -35,47 +64,139 @@ type Whitelist struct {
 	// ISO 3166-1 two-letter country codes used to match
 	// RFC 2253 Distinguished Names in certificates
 	Countries []string `json:"Countries,omitempty" yaml:"countries,omitempty"`
+
+	// Usages holds items which are only whitelisted for one Usage (e.g. a CA
+	// trusted for email, but not TLS). The items above apply to every Usage.
+	Usages map[Usage]Items `json:"Usages,omitempty" yaml:"usages,omitempty"`
+}
+
+// Items match certificates for one Usage of a Whitelist
+type Items struct {
+	Fingerprints []string `json:"Fingerprints,omitempty" yaml:"fingerprints,omitempty"`
+	Countries    []string `json:"Countries,omitempty" yaml:"countries,omitempty"`
 }
 
 // Matches checks a given x509 certificate against the criteria and
-// returns if it's matched by an item in the whitelist
+// returns if it's matched by an item in the whitelist for TLS, which is
+// the only usage most stores keep trust for.
 func (w Whitelist) Matches(inc *x509.Certificate) bool {
+	return w.MatchesUsage(inc, UsageTLS)
+}
+
+// MatchesUsage checks if a given x509 certificate is whitelisted for usage
+func (w Whitelist) MatchesUsage(inc *x509.Certificate, usage Usage) bool {
+	return w.Explain(inc, usage).Whitelisted
+}
+
+// Reason is the kind of rule which decided if a certificate is whitelisted
+type Reason string
+
+const (
+	// ReasonBlacklisted certificates are distrusted by Chromium, which always wins
+	ReasonBlacklisted Reason = "blacklisted"
+
+	ReasonFingerprint Reason = "fingerprint"
+	ReasonCountry     Reason = "country"
+
+	// ReasonNoRule is given when nothing in the whitelist matched
+	ReasonNoRule Reason = "no rule"
+)
+
+// Match explains why a certificate is or isn't whitelisted for a usage
+type Match struct {
+	Whitelisted bool
+	Reason      Reason
+
+	// Value is the fingerprint or country which matched
+	Value string
+
+	// Usage is set when the rule came from the whitelist's Usages
+	Usage Usage
+}
+
+func (m Match) String() string {
+	switch m.Reason {
+	case ReasonBlacklisted:
+		return fmt.Sprintf("blacklisted fingerprint %s", m.Value)
+	case ReasonFingerprint, ReasonCountry:
+		if m.Usage != "" {
+			return fmt.Sprintf("%s %s (usages.%s)", m.Reason, m.Value, m.Usage)
+		}
+		return fmt.Sprintf("%s %s", m.Reason, m.Value)
+	}
+	return string(ReasonNoRule)
+}
+
+// Explain returns if a given x509 certificate is whitelisted for usage, along with
+// the rule which decided it.
+func (w Whitelist) Explain(inc *x509.Certificate, usage Usage) Match {
 	if inc == nil {
-		return false
+		return Match{Reason: ReasonNoRule}
 	}
 
 	fp := certutil.GetHexSHA256Fingerprint(*inc)
 
 	// is the certificate explicitly distrusted?
+	if blacklisted(fp) {
+		return Match{Reason: ReasonBlacklisted, Value: fp}
+	}
+
+	if m, ok := matchItems(inc, fp, w.Fingerprints, w.Countries); ok {
+		return m
+	}
+	if items, ok := w.Usages[usage]; ok {
+		if m, ok := matchItems(inc, fp, items.Fingerprints, items.Countries); ok {
+			m.Usage = usage
+			return m
+		}
+	}
+	return Match{Reason: ReasonNoRule}
+}
+
+// Blacklisted checks if a certificate is one Chromium distrusts, which are never
+// whitelisted
+func Blacklisted(inc *x509.Certificate) bool {
+	return inc != nil && blacklisted(certutil.GetHexSHA256Fingerprint(*inc))
+}
+
+func blacklisted(fp string) bool {
 	for i := range blacklistedFingerprints {
 		if blacklistedFingerprints[i] == fp {
-			return false
+			return true
 		}
 	}
+	return false
+}
 
+func matchItems(inc *x509.Certificate, fp string, fingerprints, countries []string) (Match, bool) {
 	// check if our whitelist's fingerprints include this certificate
-	for i := range w.Fingerprints {
-		if w.Fingerprints[i] == fp {
-			return true
+	for i := range fingerprints {
+		if fingerprints[i] == fp {
+			return Match{Whitelisted: true, Reason: ReasonFingerprint, Value: fp}, true
 		}
 	}
 
 	// check Country in Subject
 	for i := range inc.Subject.Country {
-		for j := range w.Countries {
-			if strings.ToLower(inc.Subject.Country[i]) == strings.ToLower(w.Countries[j]) {
-				return true
+		for j := range countries {
+			if strings.ToLower(inc.Subject.Country[i]) == strings.ToLower(countries[j]) {
+				return Match{Whitelisted: true, Reason: ReasonCountry, Value: countries[j]}, true
 			}
 		}
 	}
 
-	return false
+	return Match{}, false
 }
 
 // MatchesAll checks if a given list of certificates all match against a whitelist
 func (w Whitelist) MatchesAll(cs []*x509.Certificate) bool {
+	return w.MatchesAllUsage(cs, UsageTLS)
+}
+
+// MatchesAllUsage checks if a given list of certificates are all whitelisted for usage
+func (w Whitelist) MatchesAllUsage(cs []*x509.Certificate, usage Usage) bool {
 	for i := range cs {
-		if !w.Matches(cs[i]) {
+		if !w.MatchesUsage(cs[i], usage) {
 			return false
 		}
 	}
@@ -104,16 +225,30 @@ func FromFile(path string) (Whitelist, error) {
 		return wh, err
 	}
 
-	// try reading as json
-	if err = json.Unmarshal(b, &wh); err == nil {
-		return wh, nil
+	// try reading as json, then yaml
+	if err = json.Unmarshal(b, &wh); err != nil {
+		wh = Whitelist{}
+		if err = yaml.Unmarshal(b, &wh); err != nil {
+			return wh, errors.New("Unable to read whitelist")
+		}
 	}
 
-	// try reading as yaml
-	if err = yaml.Unmarshal(b, &wh); err == nil {
-		return wh, nil
+	// usages are matched like ParseUsage, ignoring case (e.g. TLS or Email)
+	if wh.Usages != nil {
+		usages := make(map[Usage]Items, len(wh.Usages))
+		for usage, items := range wh.Usages {
+			u, err := ParseUsage(string(usage))
+			if err != nil || usage == "" {
+				return wh, fmt.Errorf("whitelist %s: unknown usage %q", path, usage)
+			}
+			usages[u] = Items{
+				Fingerprints: append(usages[u].Fingerprints, items.Fingerprints...),
+				Countries:    append(usages[u].Countries, items.Countries...),
+			}
+		}
+		wh.Usages = usages
 	}
-	return wh, errors.New("Unable to read whitelist")
+	return wh, nil
 }
 
 // ToFile take a Whitelist, encodes it in yaml and writes the result
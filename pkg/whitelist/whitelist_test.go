@@ -15,7 +15,9 @@
 package whitelist
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -204,3 +206,139 @@ func TestWhitlist__matching(t *testing.T) {
 		t.Error("should have matched")
 	}
 }
+
+func TestWhitelist__usages(t *testing.T) {
+	wh, err := FromFile("../../testdata/usage-whitelist.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := certutil.FromFile("../../testdata/example.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// example.crt is only whitelisted for email
+	if wh.Matches(certs[0]) || wh.MatchesUsage(certs[0], UsageCodeSigning) {
+		t.Error("should only match for email")
+	}
+	if !wh.MatchesUsage(certs[0], UsageEmail) || !wh.MatchesAllUsage(certs, UsageEmail) {
+		t.Error("should match for email")
+	}
+
+	// usages are read ignoring case
+	dir, err := ioutil.TempDir("", "cert-manage-whitelist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "whitelist.json")
+	err = ioutil.WriteFile(path, []byte(`{"Usages": {"Email": {"Countries": ["US"]}, "TLS": {"Countries": ["EE"]}}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wh, err = FromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wh.MatchesUsage(certs[0], UsageEmail) || wh.MatchesUsage(certs[0], UsageTLS) {
+		t.Errorf("unexpected usages: %v", wh.Usages)
+	}
+	err = ioutil.WriteFile(path, []byte(`{"Usages": {"ServerAuth": {"Countries": ["US"]}}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromFile(path); err == nil {
+		t.Error("expected error")
+	}
+
+	// items outside of usages apply to every usage
+	wh = Whitelist{
+		Countries: []string{"US"},
+	}
+	for _, u := range Usages() {
+		if !wh.MatchesUsage(certs[0], u) {
+			t.Errorf("should match for %s", u)
+		}
+	}
+}
+
+func TestWhitelist__Explain(t *testing.T) {
+	certs, err := certutil.FromFile("../../testdata/example.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := "05a6db389391df92e0be93fdfa4db1e3cf53903918b8d9d85a9c396cb55df030"
+
+	cases := []struct {
+		wh       Whitelist
+		usage    Usage
+		expected Match
+		reason   string
+	}{
+		{
+			wh:       Whitelist{},
+			usage:    UsageTLS,
+			expected: Match{Reason: ReasonNoRule},
+			reason:   "no rule",
+		},
+		{
+			wh:       Whitelist{Fingerprints: []string{fp}, Countries: []string{"US"}},
+			usage:    UsageTLS,
+			expected: Match{Whitelisted: true, Reason: ReasonFingerprint, Value: fp},
+			reason:   "fingerprint " + fp,
+		},
+		{
+			wh:       Whitelist{Countries: []string{"us"}},
+			usage:    UsageTLS,
+			expected: Match{Whitelisted: true, Reason: ReasonCountry, Value: "us"},
+			reason:   "country us",
+		},
+		{
+			wh:       Whitelist{Usages: map[Usage]Items{UsageEmail: {Countries: []string{"US"}}}},
+			usage:    UsageEmail,
+			expected: Match{Whitelisted: true, Reason: ReasonCountry, Value: "US", Usage: UsageEmail},
+			reason:   "country US (usages.email)",
+		},
+		{
+			wh:       Whitelist{Usages: map[Usage]Items{UsageEmail: {Countries: []string{"US"}}}},
+			usage:    UsageTLS,
+			expected: Match{Reason: ReasonNoRule},
+			reason:   "no rule",
+		},
+	}
+	for i := range cases {
+		m := cases[i].wh.Explain(certs[0], cases[i].usage)
+		if m != cases[i].expected {
+			t.Errorf("%d: got %#v", i, m)
+		}
+		if m.String() != cases[i].reason {
+			t.Errorf("%d: got reason %q", i, m.String())
+		}
+	}
+
+	if m := (Whitelist{}).Explain(nil, UsageTLS); m.Whitelisted || m.Reason != ReasonNoRule {
+		t.Errorf("got %#v", m)
+	}
+
+	// blacklisted certificates are never whitelisted
+	orig := blacklistedFingerprints
+	defer func() { blacklistedFingerprints = orig }()
+	blacklistedFingerprints = append([]string{fp}, orig...)
+
+	m := Whitelist{Fingerprints: []string{fp}}.Explain(certs[0], UsageTLS)
+	if m.Whitelisted || m.Reason != ReasonBlacklisted || m.String() != "blacklisted fingerprint "+fp {
+		t.Errorf("got %#v", m)
+	}
+}
+
+func TestWhitelist__ParseUsage(t *testing.T) {
+	if u, err := ParseUsage(""); err != nil || u != UsageTLS {
+		t.Errorf("got %q err=%v", u, err)
+	}
+	if u, err := ParseUsage("Email"); err != nil || u != UsageEmail {
+		t.Errorf("got %q err=%v", u, err)
+	}
+	if _, err := ParseUsage("other"); err == nil {
+		t.Error("expected error")
+	}
+}
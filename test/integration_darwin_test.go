@@ -123,7 +123,7 @@ func inPlatformStore(t *testing.T, fp string) bool {
 	t.Helper()
 
 	// Grab platform certs and verify ours is added
-	found, err := store.Platform().List(&store.ListOptions{
+	found, err := store.Platform(nil).List(&store.ListOptions{
 		Trusted: true,
 	})
 	if err != nil {
@@ -180,7 +180,7 @@ func TestIntegration__WhitelistAndRemove(t *testing.T) {
 	t.Helper()
 
 	// get cert count
-	certsBefore, err := store.Platform().List(&store.ListOptions{
+	certsBefore, err := store.Platform(nil).List(&store.ListOptions{
 		Trusted: true,
 	})
 	if err != nil {
@@ -201,7 +201,7 @@ func TestIntegration__WhitelistAndRemove(t *testing.T) {
 	cmd.SuccessT(t)
 
 	// verify cert count
-	certsAfter, err := store.Platform().List(&store.ListOptions{
+	certsAfter, err := store.Platform(nil).List(&store.ListOptions{
 		Trusted: true,
 	})
 	if err != nil {
@@ -223,7 +223,7 @@ func TestIntegration__WhitelistAndRemove(t *testing.T) {
 	cmd.SuccessT(t)
 
 	// verify cert count
-	certsAfterRestore, err := store.Platform().List(&store.ListOptions{
+	certsAfterRestore, err := store.Platform(nil).List(&store.ListOptions{
 		Trusted: true,
 	})
 	if err != nil {